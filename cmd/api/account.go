@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/nati3514/Social/internal/mailer"
+	"github.com/nati3514/Social/internal/store"
+)
+
+var errReservedUsername = errors.New("that username is reserved")
+
+type ChangeEmailPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+type ChangeUsernamePayload struct {
+	Username string `json:"username" validate:"required,max=100"`
+}
+
+// RequestEmailChange godoc
+// @Summary Change your email address
+// @Description Emails a confirmation link to the new address and a notice to the current one. The change only takes effect once confirmed
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param payload body ChangeEmailPayload true "New email address"
+// @Success 202 "Accepted"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/email [post]
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ChangeEmailPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getAuthUserFromContext(r)
+	if strings.EqualFold(payload.Email, user.Email) {
+		app.badRequestResponse(w, r, errors.New("that is already your email address"))
+		return
+	}
+
+	ctx := r.Context()
+
+	if _, err := app.store.Users.GetByEmail(ctx, payload.Email); err == nil {
+		app.badRequestResponse(w, r, store.ErrDuplicateEmail)
+		return
+	} else if !errors.Is(err, store.ErrNotFound) {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	plainToken := uuid.New().String()
+
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	if err := app.store.Users.CreateEmailChange(ctx, user.ID, payload.Email, hashToken, app.config.mail.emailChangeExp); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	go app.sendEmailChange(*user, payload.Email, plainToken)
+
+	if err := app.jsonResponse(w, http.StatusAccepted, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) sendEmailChange(user store.User, newEmail, plainToken string) {
+	confirm := struct {
+		Username   string
+		ConfirmURL string
+		Expiry     string
+	}{
+		Username:   user.Username,
+		ConfirmURL: fmt.Sprintf("%s/confirm-email/%s", app.config.frontendURL, plainToken),
+		Expiry:     app.config.mail.emailChangeExp.String(),
+	}
+
+	if err := app.mailer.Send(mailer.EmailChangeTemplate, user.Username, newEmail, confirm); err != nil {
+		log.Printf("Error sending email change confirmation to user %d: %v\n", user.ID, err)
+	}
+
+	notice := struct {
+		Username string
+		NewEmail string
+	}{
+		Username: user.Username,
+		NewEmail: newEmail,
+	}
+
+	if err := app.mailer.Send(mailer.EmailNoticeTemplate, user.Username, user.Email, notice); err != nil {
+		log.Printf("Error sending email change notice to user %d: %v\n", user.ID, err)
+	}
+}
+
+// ConfirmEmailChange godoc
+// @Summary Confirm an email change
+// @Description Switches the account to the new address the token was sent to
+// @Tags Users
+// @Produce json
+// @Param token path string true "Email change token"
+// @Success 204 "No Content"
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /users/email/{token} [put]
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	if err := app.store.Users.ConfirmEmailChange(r.Context(), token); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		case store.ErrDuplicateEmail:
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ChangeUsername godoc
+// @Summary Change your username
+// @Description Renames the authenticated user. Usernames can only be changed once per cooldown period, and previous usernames stay reserved for their owner
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param payload body ChangeUsernamePayload true "New username"
+// @Success 200 {object} store.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string "Changed too recently"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/username [put]
+func (app *application) changeUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ChangeUsernamePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if store.IsReservedUsername(payload.Username) {
+		app.badRequestResponse(w, r, errReservedUsername)
+		return
+	}
+
+	user := getAuthUserFromContext(r)
+	if payload.Username == user.Username {
+		app.badRequestResponse(w, r, errors.New("that is already your username"))
+		return
+	}
+
+	ctx := r.Context()
+
+	err := app.store.Users.ChangeUsername(ctx, user.ID, payload.Username, app.config.auth.usernameCooldown)
+	if err != nil {
+		switch err {
+		case store.ErrDuplicateUsername:
+			app.badRequestResponse(w, r, err)
+		case store.ErrUsernameCooldown:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	updated, err := app.store.Users.GetByID(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, updated); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type DeletionResponse struct {
+	DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+}
+
+// DeleteAccount godoc
+// @Summary Delete your account
+// @Description Signs the account out everywhere and schedules it for deletion after a grace period. Logging in again before then cancels the deletion
+// @Tags Users
+// @Produce json
+// @Success 202 {object} DeletionResponse
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me [delete]
+func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromContext(r)
+
+	at := time.Now().Add(app.config.account.deletionGrace)
+	if err := app.store.Users.ScheduleDeletion(r.Context(), user.ID, at); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, DeletionResponse{DeletionScheduledAt: at}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nati3514/Social/internal/store"
+)
+
+const (
+	// defaultStatsDays is how many days the stats cover when no range is
+	// given, maxStatsDays the most they can cover.
+	defaultStatsDays = 30
+	maxStatsDays     = 366
+)
+
+type UpdateRolePayload struct {
+	Role string `json:"role" validate:"required,oneof=user moderator admin"`
+}
+
+// adminUserResponse is a user as admins see it.
+type adminUserResponse struct {
+	*store.User
+	Counts *store.UserCounts `json:"counts"`
+}
+
+// ListUsers godoc
+// @Summary List users
+// @Description Lists and searches the users, newest first
+// @Tags Admin
+// @Produce json
+// @Param q query string false "Part of the username, email or display name"
+// @Param activated query bool false "Only activated or unactivated users"
+// @Param suspended query bool false "Only suspended or unsuspended users"
+// @Param role query string false "user, moderator or admin"
+// @Param created_from query string false "Created on or after this day, YYYY-MM-DD"
+// @Param created_to query string false "Created on or before this day, YYYY-MM-DD"
+// @Param limit query int false "Items per page (max 100)" default(20)
+// @Param offset query int false "Items to skip" default(0)
+// @Success 200 {array} store.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/users [get]
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := readPagination(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	filter, err := readUserFilter(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	users, err := app.store.Users.List(r.Context(), filter, limit, offset)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, users); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func readUserFilter(r *http.Request) (store.UserFilter, error) {
+	query := r.URL.Query()
+	filter := store.UserFilter{Query: query.Get("q")}
+
+	for name, field := range map[string]**bool{
+		"activated": &filter.Activated,
+		"suspended": &filter.Suspended,
+	} {
+		if s := query.Get(name); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be true or false", name)
+			}
+			*field = &b
+		}
+	}
+
+	filter.Role = query.Get("role")
+	if filter.Role != "" {
+		if err := Validate.Var(filter.Role, "oneof=user moderator admin"); err != nil {
+			return filter, errors.New("role must be user, moderator or admin")
+		}
+	}
+
+	from, err := readDate(r, "created_from")
+	if err != nil {
+		return filter, err
+	}
+	filter.CreatedAfter = from
+
+	to, err := readDate(r, "created_to")
+	if err != nil {
+		return filter, err
+	}
+	if to != nil {
+		// The last day is included
+		before := to.AddDate(0, 0, 1)
+		filter.CreatedBefore = &before
+	}
+
+	return filter, nil
+}
+
+// readDate reads a YYYY-MM-DD query parameter as midnight UTC, or nil if it
+// is missing.
+func readDate(r *http.Request, name string) (*time.Time, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return nil, nil
+	}
+	day, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a date like 2006-01-02", name)
+	}
+	return &day, nil
+}
+
+// GetUserAsAdmin godoc
+// @Summary Get a user
+// @Description Gets a user's account with counts of what they did
+// @Tags Admin
+// @Produce json
+// @Param userID path int true "User ID"
+// @Success 200 {object} adminUserResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/users/{userID} [get]
+func (app *application) getUserAsAdminHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	counts, err := app.store.Users.GetCounts(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, adminUserResponse{User: user, Counts: counts}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// SuspendUser godoc
+// @Summary Suspend a user
+// @Description Blocks logging into the account and using its personal access tokens, and signs it out everywhere. Admins can't be suspended
+// @Tags Admin
+// @Param userID path int true "User ID"
+// @Success 204 "Suspended"
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Already suspended"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/users/{userID}/suspend [post]
+func (app *application) suspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	if user.IsAdmin() {
+		app.forbiddenResponse(w, r, errors.New("admins cannot be suspended"))
+		return
+	}
+
+	suspended, err := app.store.Users.Suspend(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if !suspended {
+		app.conflictResponse(w, r, errors.New("the user is already suspended"))
+		return
+	}
+
+	app.respondToAdminAction(w, r, store.SecurityEventAccountSuspended, nil)
+}
+
+// UnsuspendUser godoc
+// @Summary Unsuspend a user
+// @Description Lets a suspended account be used again
+// @Tags Admin
+// @Param userID path int true "User ID"
+// @Success 204 "Unsuspended"
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Not suspended"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/users/{userID}/unsuspend [post]
+func (app *application) unsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	unsuspended, err := app.store.Users.Unsuspend(r.Context(), getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if !unsuspended {
+		app.conflictResponse(w, r, errors.New("the user is not suspended"))
+		return
+	}
+
+	app.respondToAdminAction(w, r, store.SecurityEventAccountUnsuspended, nil)
+}
+
+// ForceActivateUser godoc
+// @Summary Activate a user
+// @Description Activates an account without its invitation, which stops working
+// @Tags Admin
+// @Param userID path int true "User ID"
+// @Success 204 "Activated"
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Already active"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/users/{userID}/activate [post]
+func (app *application) forceActivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	activated, err := app.store.Users.ForceActivate(r.Context(), getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if !activated {
+		app.conflictResponse(w, r, errors.New("the user is already active"))
+		return
+	}
+
+	app.respondToAdminAction(w, r, store.SecurityEventAccountActivated, nil)
+}
+
+// ResetSessions godoc
+// @Summary Sign a user out everywhere
+// @Description Ends all sessions of the user. Their personal access tokens keep working
+// @Tags Admin
+// @Param userID path int true "User ID"
+// @Success 204 "Signed out"
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/users/{userID}/sessions [delete]
+func (app *application) resetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := app.store.Users.SignOutEverywhere(r.Context(), getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.respondToAdminAction(w, r, store.SecurityEventSessionsReset, map[string]any{"sessions": n})
+}
+
+// UpdateUserRole godoc
+// @Summary Change a user's role
+// @Description Makes a user a regular user, a moderator or an admin. Admins can't change their own role
+// @Tags Admin
+// @Accept json
+// @Param userID path int true "User ID"
+// @Param payload body UpdateRolePayload true "Role"
+// @Success 204 "Role changed"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/users/{userID}/role [put]
+func (app *application) updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateRolePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	if user.ID == getAuthUserFromContext(r).ID {
+		app.badRequestResponse(w, r, errors.New("you cannot change your own role"))
+		return
+	}
+
+	if user.Role == payload.Role {
+		if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.Users.SetRole(r.Context(), user.ID, payload.Role); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.respondToAdminAction(w, r, store.SecurityEventRoleChanged, map[string]any{
+		"from": user.Role,
+		"to":   payload.Role,
+	})
+}
+
+// respondToAdminAction records what an admin did to the user in the URL in
+// the user's security events, and responds with no content.
+func (app *application) respondToAdminAction(w http.ResponseWriter, r *http.Request, kind string, details map[string]any) {
+	user := getUserFromContext(r)
+
+	if details == nil {
+		details = map[string]any{}
+	}
+	details["admin_id"] = getAuthUserFromContext(r).ID
+
+	event := &store.SecurityEvent{
+		UserID:  &user.ID,
+		Kind:    kind,
+		IP:      clientIP(r),
+		Details: details,
+	}
+	if err := app.store.SecurityEvents.Create(r.Context(), event); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetStats godoc
+// @Summary Get platform stats
+// @Description Counts the signups, published posts, comments and follows of each day, in UTC. Defaults to the last 30 days
+// @Tags Admin
+// @Produce json
+// @Param from query string false "First day, YYYY-MM-DD"
+// @Param to query string false "Last day, YYYY-MM-DD" default(today)
+// @Success 200 {array} store.DailyStats
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/stats [get]
+func (app *application) getStatsHandler(w http.ResponseWriter, r *http.Request) {
+	to, err := readDate(r, "to")
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if to == nil {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		to = &today
+	}
+
+	from, err := readDate(r, "from")
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if from == nil {
+		start := to.AddDate(0, 0, 1-defaultStatsDays)
+		from = &start
+	}
+
+	switch days := int(to.Sub(*from).Hours()/24) + 1; {
+	case days < 1:
+		app.badRequestResponse(w, r, errors.New("from must not be after to"))
+		return
+	case days > maxStatsDays:
+		app.badRequestResponse(w, r, fmt.Errorf("stats can cover at most %d days", maxStatsDays))
+		return
+	}
+
+	stats, err := app.store.Stats.GetDaily(r.Context(), *from, *to)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, stats); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
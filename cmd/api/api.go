@@ -1,33 +1,156 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/nati3514/Social/docs"
+	"github.com/nati3514/Social/internal/mailer"
+	"github.com/nati3514/Social/internal/media"
+	"github.com/nati3514/Social/internal/moderation"
+	"github.com/nati3514/Social/internal/oidc"
+	"github.com/nati3514/Social/internal/ratelimiter"
+	"github.com/nati3514/Social/internal/realtime"
 	"github.com/nati3514/Social/internal/store"
+	"github.com/nati3514/Social/internal/totp"
+	"github.com/nati3514/Social/internal/unfurl"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
+// shutdownTimeout is how long requests in flight get to finish on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 type application struct {
-	config config
-	store  store.Storage
+	config      config
+	store       store.Storage
+	mailer      mailer.Client
+	rateLimiter ratelimiter.Limiter
+	blobs       media.BlobStore
+	previews    *previewQueue
+	hub         *realtime.Hub
+	filters     *moderation.Pipeline
+	spam        *moderation.SpamClassifier
+	// oidcProviders maps provider names to configured identity providers.
+	oidcProviders map[string]*oidc.Provider
 }
 
 type config struct {
-	addr   string
-	db     dbConfig
-	env    string
-	apiURL string
-	mail   mailConfig
+	addr        string
+	db          dbConfig
+	env         string
+	apiURL      string
+	frontendURL string
+	mail        mailConfig
+	rateLimiter ratelimiter.Config
+	janitor     janitorConfig
+	scheduler   schedulerConfig
+	auth        authConfig
+	oidc        oidcConfig
+	account     accountConfig
+	media       mediaConfig
+	trash       trashConfig
+	previews    previewConfig
+	moderation  moderationConfig
 }
-type mailConfig struct {
+
+type moderationConfig struct {
+	// Posts and comments with bannedWords are rejected, those with
+	// heldWords held for review.
+	bannedWords     []string
+	heldWords       []string
+	maxPostLinks    int
+	maxCommentLinks int
+	// duplicateWindow is how far back a user's posts are compared for
+	// duplicates.
+	duplicateWindow time.Duration
+	// trainInterval is how often the spam classifier relearns from
+	// moderation decisions.
+	trainInterval time.Duration
+}
+type mediaConfig struct {
+	// backend is "fs" to keep uploads in dir, or "s3".
+	backend       string
+	dir           string
+	baseURL       string
+	s3            media.S3Config
+	maxUploadSize int64
+	// unattachedTTL is how long uploads not used in any post are kept.
+	unattachedTTL time.Duration
+}
+type accountConfig struct {
+	// deletionGrace is how long a deleted account can still be restored by
+	// logging in before it is removed for good.
+	deletionGrace time.Duration
+	exportExp     time.Duration
+}
+type previewConfig struct {
+	unfurl  unfurl.Config
+	ttl     time.Duration
+	workers int
+}
+type trashConfig struct {
+	// retention is how long deleted posts and comments can be restored
+	// before they are purged.
+	retention time.Duration
+}
+type oidcConfig struct {
+	providers []oidc.Config
+	stateExp  time.Duration
+}
+type authConfig struct {
+	token            tokenConfig
+	lockout          lockoutConfig
+	totp             totp.Config
+	challengeExp     time.Duration
+	usernameCooldown time.Duration
+}
+type tokenConfig struct {
 	exp time.Duration
 }
+type lockoutConfig struct {
+	// threshold and ipThreshold are the consecutive failures after which an
+	// account or a client IP is locked out.
+	threshold   int
+	ipThreshold int
+	// window is how long a failure counts towards the threshold.
+	window      time.Duration
+	backoffBase time.Duration
+	maxBackoff  time.Duration
+	duration    time.Duration
+	unlockExp   time.Duration
+}
+type mailConfig struct {
+	exp              time.Duration
+	passwordResetExp time.Duration
+	emailChangeExp   time.Duration
+	fromEmail        string
+	smtp             smtpConfig
+}
+type smtpConfig struct {
+	host     string
+	port     int
+	username string
+	password string
+}
+type janitorConfig struct {
+	interval time.Duration
+	// unactivatedUserTTL is how long an account may stay unactivated before
+	// it is deleted. Zero keeps unactivated accounts forever.
+	unactivatedUserTTL time.Duration
+}
+type schedulerConfig struct {
+	// interval is how often scheduled posts are checked for being due.
+	interval time.Duration
+}
 type dbConfig struct {
 	addr         string
 	maxOpenConns int
@@ -49,36 +172,225 @@ func (app *application) mount() http.Handler {
 		r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(docsURL)))
 
 		r.Route("/posts", func(r chi.Router) {
-			r.Post("/", app.createPostHandler)
+			r.With(app.AuthTokenMiddleware, app.requireScope(scopePostsWrite)).Post("/", app.createPostHandler)
 
 			r.Route("/{postID}", func(r chi.Router) {
 				r.Use(app.postContextMiddleware)
 
-				r.Get("/", app.getPostHandler)
-				r.Delete("/", app.deletePostHandler)
-				r.Patch("/", app.updatePostHandler)
+				r.Group(func(r chi.Router) {
+					r.Use(app.OptionalAuthTokenMiddleware)
+					r.Use(app.requireVisiblePost)
+
+					r.Get("/", app.getPostHandler)
+					r.Get("/revisions", app.listRevisionsHandler)
+					r.Get("/revisions/diff", app.diffRevisionsHandler)
+					r.Get("/revisions/{version}", app.getRevisionHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.AuthTokenMiddleware)
+					r.Use(app.requireSession)
+
+					r.With(app.requireVisiblePost, app.requireLivePost).Put("/bookmark", app.bookmarkPostHandler)
+					r.Delete("/bookmark", app.unbookmarkPostHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.AuthTokenMiddleware)
+					r.Use(app.requireScope(scopePostsWrite))
+
+					r.Group(func(r chi.Router) {
+						r.Use(app.requireLivePost)
+						r.Use(app.requirePostOwner)
+
+						r.Delete("/", app.deletePostHandler)
+						r.Patch("/", app.updatePostHandler)
+						r.Post("/revisions/{version}/restore", app.restoreRevisionHandler)
+					})
+
+					r.With(app.requirePostOwner).Post("/restore", app.restorePostHandler)
+
+					r.With(app.requireVisiblePost, app.requireLivePost).Post("/comments", app.createCommentHandler)
+
+					r.Route("/comments/{commentID}", func(r chi.Router) {
+						r.Use(app.requireVisiblePost)
+						r.Use(app.requireLivePost)
+						r.Use(app.commentContextMiddleware)
+						r.Use(app.requireCommentOwner)
+
+						r.Delete("/", app.deleteCommentHandler)
+						r.Post("/restore", app.restoreCommentHandler)
+					})
+				})
 			})
 		})
+		r.Route("/media", func(r chi.Router) {
+			r.Group(func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.requireScope(scopePostsWrite))
+
+				r.Post("/", app.uploadMediaHandler)
+				r.Patch("/{mediaID}", app.updateMediaHandler)
+			})
+
+			if app.config.media.backend == "fs" {
+				r.Get("/files/*", app.serveMediaFileHandler)
+			}
+		})
 		r.Route("/users", func(r chi.Router) {
 			r.Put("/activate/{token}", app.activateUserHandler)
+			r.With(app.rateLimitMiddleware(app.rateLimiter)).Post("/activate/resend", app.resendActivationHandler)
+			r.Put("/email/{token}", app.confirmEmailChangeHandler)
+			r.Get("/export/{token}", app.downloadExportHandler)
+
+			r.Route("/me", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.requireSession)
+
+				r.Get("/", app.getCurrentUserHandler)
+				r.Patch("/", app.updateProfileHandler)
+				r.Delete("/", app.deleteAccountHandler)
+				r.Get("/export", app.exportDataHandler)
+				r.Post("/email", app.requestEmailChangeHandler)
+				r.Put("/username", app.changeUsernameHandler)
+				r.Get("/drafts", app.getDraftsHandler)
+				r.Get("/trash", app.getTrashHandler)
+				r.Get("/mentions", app.getMentionsHandler)
+				r.Get("/blocks", app.getBlockedHandler)
+				r.Get("/mutes", app.getMutedHandler)
+
+				r.Route("/bookmarks", func(r chi.Router) {
+					r.Get("/", app.getBookmarksHandler)
+					r.Get("/collections", app.getBookmarkCollectionsHandler)
+					r.Post("/collections", app.createBookmarkCollectionHandler)
+					r.Patch("/collections/{collectionID}", app.renameBookmarkCollectionHandler)
+					r.Delete("/collections/{collectionID}", app.deleteBookmarkCollectionHandler)
+				})
+
+				r.Post("/2fa", app.enrollTwoFactorHandler)
+				r.Post("/2fa/confirm", app.confirmTwoFactorHandler)
+				r.Delete("/2fa", app.disableTwoFactorHandler)
+
+				r.Post("/tokens", app.createAccessTokenHandler)
+				r.Get("/tokens", app.listAccessTokensHandler)
+				r.Delete("/tokens/{tokenID}", app.revokeAccessTokenHandler)
+			})
 
 			r.Route("/{userID}", func(r chi.Router) {
 				r.Use(app.userContextMiddleware)
 
 				r.Get("/", app.getUserHandler)
-				r.Put("/follow", app.followUserHandler)
-				r.Put("/unfollow", app.unfollowUserHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.AuthTokenMiddleware)
+					r.Use(app.requireScope(scopeFollowsWrite))
+
+					r.Put("/follow", app.followUserHandler)
+					r.Put("/unfollow", app.unfollowUserHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.AuthTokenMiddleware)
+					r.Use(app.requireSession)
+
+					r.Put("/block", app.blockUserHandler)
+					r.Put("/unblock", app.unblockUserHandler)
+					r.Put("/mute", app.muteUserHandler)
+					r.Put("/unmute", app.unmuteUserHandler)
+				})
 			})
 
 			r.Group(func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.requireScope(scopeFeedRead))
+
 				r.Get("/feed", app.getUserFeedHandler)
+				r.Get("/feed/stream", app.streamUserFeedHandler)
+			})
+		})
+
+		r.With(app.wsAuthMiddleware, app.requireSession).Get("/ws", app.wsHandler)
+		r.With(app.AuthTokenMiddleware, app.requireSession).Post("/ws/tickets", app.createWSTicketHandler)
+
+		r.Route("/conversations", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.requireSession)
+
+			r.Post("/", app.createConversationHandler)
+			r.Get("/", app.listConversationsHandler)
+
+			r.Route("/{conversationID}", func(r chi.Router) {
+				r.Use(app.conversationContextMiddleware)
+
+				r.Get("/", app.getConversationHandler)
+				r.Get("/messages", app.listMessagesHandler)
+				r.Post("/messages", app.sendMessageHandler)
+				r.Post("/read", app.markConversationReadHandler)
+			})
+		})
+
+		r.Route("/notifications", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.requireSession)
+
+			r.Get("/", app.getNotificationsHandler)
+			r.Post("/read", app.markNotificationsReadHandler)
+		})
+
+		r.With(app.AuthTokenMiddleware, app.requireSession).Post("/reports", app.createReportHandler)
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.requireSession)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireModerator)
+
+				r.Route("/reports", func(r chi.Router) {
+					r.Get("/", app.listReportsHandler)
+
+					r.Route("/{reportID}", func(r chi.Router) {
+						r.Use(app.reportContextMiddleware)
+
+						r.Get("/", app.getReportHandler)
+						r.With(app.requireOtherUsersReport).Post("/claim", app.claimReportHandler)
+						r.With(app.requireOtherUsersReport).Post("/resolve", app.resolveReportHandler)
+					})
+				})
 			})
 
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireAdmin)
+
+				r.Get("/stats", app.getStatsHandler)
+
+				r.Route("/users", func(r chi.Router) {
+					r.Get("/", app.listUsersHandler)
+
+					r.Route("/{userID}", func(r chi.Router) {
+						r.Use(app.userContextMiddleware)
+
+						r.Get("/", app.getUserAsAdminHandler)
+						r.Post("/suspend", app.suspendUserHandler)
+						r.Post("/unsuspend", app.unsuspendUserHandler)
+						r.Post("/activate", app.forceActivateUserHandler)
+						r.Delete("/sessions", app.resetSessionsHandler)
+						r.Put("/role", app.updateUserRoleHandler)
+					})
+				})
+			})
 		})
 
 		// Public routes
 		r.Route("/authentication", func(r chi.Router) {
 			r.Post("/user", app.registerUserHandler)
+			r.Post("/password-reset", app.requestPasswordResetHandler)
+			r.Put("/password-reset/{token}", app.resetPasswordHandler)
+			r.Post("/token", app.createTokenHandler)
+			r.Post("/token/2fa", app.completeLoginHandler)
+			r.Put("/unlock/{token}", app.unlockAccountHandler)
+			r.Get("/oidc/{provider}", app.oidcLoginHandler)
+			r.Get("/oidc/{provider}/callback", app.oidcCallbackHandler)
 		})
 	})
 	return r
@@ -96,6 +408,33 @@ func (app *application) run(mux http.Handler) error {
 		ReadTimeout:  10 * time.Second,
 	}
 
+	// Streams never go idle, so they are ended when shutdown starts rather
+	// than waited for
+	srv.RegisterOnShutdown(app.hub.Shutdown)
+
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		log.Printf("Shutting down server: %s\n", s)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
 	log.Println("Starting server on", app.config.addr)
-	return srv.ListenAndServe()
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	if err := <-shutdownErr; err != nil {
+		return err
+	}
+
+	log.Println("Server stopped")
+	return nil
 }
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 
+	"github.com/nati3514/Social/internal/mailer"
 	"github.com/nati3514/Social/internal/store"
 )
 
@@ -38,6 +45,11 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if store.IsReservedUsername(payload.Username) {
+		app.badRequestResponse(w, r, errReservedUsername)
+		return
+	}
+
 	user := &store.User{
 		Username: payload.Username,
 		Email:    payload.Email,
@@ -81,3 +93,297 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		app.internalServerError(w, r, err)
 	}
 }
+
+type PasswordResetRequestPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+type ResetPasswordPayload struct {
+	Password string `json:"password" validate:"required,min=3,max=72"`
+}
+
+// RequestPasswordReset godoc
+// @Summary Request a password reset
+// @Description Emails a single-use password reset link. Always responds 202 so it cannot be used to discover accounts
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param payload body PasswordResetRequestPayload true "Account email"
+// @Success 202 "Accepted"
+// @Failure 400 {object} map[string]string
+// @Router /authentication/password-reset [post]
+func (app *application) requestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var payload PasswordResetRequestPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// The lookup, token and email happen in the background so the response
+	// time is the same whether or not the account exists.
+	go app.sendPasswordReset(payload.Email)
+
+	if err := app.jsonResponse(w, http.StatusAccepted, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) sendPasswordReset(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), store.QueryTimeout*2)
+	defer cancel()
+
+	user, err := app.store.Users.GetByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("Error looking up user for password reset: %v\n", err)
+		}
+		return
+	}
+
+	plainToken := uuid.New().String()
+
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	if err := app.store.Users.CreatePasswordReset(ctx, user.ID, hashToken, app.config.mail.passwordResetExp); err != nil {
+		log.Printf("Error creating password reset for user %d: %v\n", user.ID, err)
+		return
+	}
+
+	data := struct {
+		Username string
+		ResetURL string
+		Expiry   string
+	}{
+		Username: user.Username,
+		ResetURL: fmt.Sprintf("%s/password-reset/%s", app.config.frontendURL, plainToken),
+		Expiry:   app.config.mail.passwordResetExp.String(),
+	}
+
+	if err := app.mailer.Send(mailer.PasswordResetTemplate, user.Username, user.Email, data); err != nil {
+		log.Printf("Error sending password reset email to user %d: %v\n", user.ID, err)
+	}
+}
+
+// ResetPassword godoc
+// @Summary Reset a password
+// @Description Sets a new password using a reset token and signs the user out of every session
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param token path string true "Password reset token"
+// @Param payload body ResetPasswordPayload true "New password"
+// @Success 204 "No Content"
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /authentication/password-reset/{token} [put]
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	var payload ResetPasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.Users.ResetPassword(r.Context(), token, payload.Password); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, ""); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type CreateTokenPayload struct {
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,min=3,max=72"`
+}
+
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var errAccountSuspended = errors.New("account has been suspended")
+
+// CreateToken godoc
+// @Summary Log in
+// @Description Exchanges an email and password for a session token. Repeated failures are slowed down and eventually lock the account and IP
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param payload body CreateTokenPayload true "User credentials"
+// @Success 201 {object} TokenResponse
+// @Success 202 {object} LoginChallengeResponse "Two-factor code required"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string "Account not activated or suspended"
+// @Failure 429 {object} map[string]string "Too many failed attempts"
+// @Failure 500 {object} map[string]string
+// @Router /authentication/token [post]
+func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateTokenPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	accountKey := accountThrottleKey(payload.Email)
+	ipKey := clientIP(r)
+
+	wait, err := app.loginWait(ctx, accountKey, ipKey)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.loginThrottledResponse(w, r, wait)
+		return
+	}
+
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// A missing user is compared as one without a password hash, which takes
+	// as long as a real comparison.
+	candidate := user
+	if candidate == nil {
+		candidate = &store.User{}
+	}
+	if err := candidate.Password.Compare(payload.Password); err != nil {
+		if err := app.recordLoginFailures(ctx, r, accountKey, ipKey, user); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		app.unauthorizedErrorResponse(w, r, errors.New("invalid credentials"))
+		return
+	}
+
+	if !user.IsActive {
+		app.forbiddenResponse(w, r, errors.New("account has not been activated"))
+		return
+	}
+
+	if user.SuspendedAt != nil {
+		app.forbiddenResponse(w, r, errAccountSuspended)
+		return
+	}
+
+	// With two-factor authentication the throttle is only reset once the
+	// second factor is verified, so codes can't be guessed on a fresh count
+	if user.TwoFactorEnabled {
+		app.respondWithLoginChallenge(w, r, user)
+		return
+	}
+
+	if err := app.store.LoginThrottles.Reset(ctx, store.ThrottleScopeAccount, accountKey); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	res, err := app.createSession(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, res); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) createSession(ctx context.Context, userID int64) (*TokenResponse, error) {
+	plainToken := uuid.New().String()
+
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	exp := app.config.auth.token.exp
+	if err := app.store.Sessions.Create(ctx, userID, hashToken, exp); err != nil {
+		return nil, err
+	}
+
+	// Logging in during the grace period keeps an account scheduled for deletion
+	cancelled, err := app.store.Users.CancelDeletion(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if cancelled {
+		log.Printf("Cancelled scheduled deletion of user %d on login\n", userID)
+	}
+
+	return &TokenResponse{
+		Token:     plainToken,
+		ExpiresAt: time.Now().Add(exp),
+	}, nil
+}
+
+// UnlockAccount godoc
+// @Summary Unlock an account
+// @Description Lifts a login lockout using the link emailed when the account was locked
+// @Tags Authentication
+// @Produce json
+// @Param token path string true "Unlock token"
+// @Success 204 "No Content"
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /authentication/unlock/{token} [put]
+func (app *application) unlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	ctx := r.Context()
+
+	userID, err := app.store.LoginThrottles.Unlock(ctx, token)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	event := &store.SecurityEvent{
+		UserID: &userID,
+		Kind:   store.SecurityEventAccountUnlocked,
+		IP:     clientIP(r),
+	}
+	if err := app.store.SecurityEvents.Create(ctx, event); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, ""); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nati3514/Social/internal/store"
+)
+
+// BlockUser godoc
+// @Summary Block a user
+// @Description Blocks the user in the path. Follows between the two of you are removed in both directions and can't be made again, your posts and comments are hidden from them, and neither of you can comment on the other's posts, mention or message the other
+// @Tags Users
+// @Param userID path int true "User ID to block"
+// @Success 204 "No Content"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Already blocked"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/{userID}/block [put]
+func (app *application) blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	blocked := getUserFromContext(r)
+	user := getAuthUserFromContext(r)
+
+	if blocked.ID == user.ID {
+		app.badRequestResponse(w, r, errors.New("you cannot block yourself"))
+		return
+	}
+
+	if err := app.store.Blocks.Block(r.Context(), user.ID, blocked.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UnblockUser godoc
+// @Summary Unblock a user
+// @Description Unblocks the user in the path. Follows removed by the block are not restored
+// @Tags Users
+// @Param userID path int true "User ID to unblock"
+// @Success 204 "No Content"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/{userID}/unblock [put]
+func (app *application) unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.store.Blocks.Unblock(r.Context(), getAuthUserFromContext(r).ID, getUserFromContext(r).ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// MuteUser godoc
+// @Summary Mute a user
+// @Description Hides the posts of the user in the path from your feed and stops their notifications. They are not told and can still interact with you
+// @Tags Users
+// @Param userID path int true "User ID to mute"
+// @Success 204 "No Content"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Already muted"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/{userID}/mute [put]
+func (app *application) muteUserHandler(w http.ResponseWriter, r *http.Request) {
+	muted := getUserFromContext(r)
+	user := getAuthUserFromContext(r)
+
+	if muted.ID == user.ID {
+		app.badRequestResponse(w, r, errors.New("you cannot mute yourself"))
+		return
+	}
+
+	if err := app.store.Mutes.Mute(r.Context(), user.ID, muted.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UnmuteUser godoc
+// @Summary Unmute a user
+// @Tags Users
+// @Param userID path int true "User ID to unmute"
+// @Success 204 "No Content"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/{userID}/unmute [put]
+func (app *application) unmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.store.Mutes.Unmute(r.Context(), getAuthUserFromContext(r).ID, getUserFromContext(r).ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetBlocked godoc
+// @Summary List blocked users
+// @Description Lists the users you blocked, the most recent first
+// @Tags Users
+// @Produce json
+// @Success 200 {array} store.RelatedUser
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/blocks [get]
+func (app *application) getBlockedHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := app.store.Blocks.GetBlocked(r.Context(), getAuthUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, users); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetMuted godoc
+// @Summary List muted users
+// @Description Lists the users you muted, the most recent first
+// @Tags Users
+// @Produce json
+// @Success 200 {array} store.RelatedUser
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/mutes [get]
+func (app *application) getMutedHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := app.store.Mutes.GetMuted(r.Context(), getAuthUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, users); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nati3514/Social/internal/store"
+)
+
+type BookmarkPostPayload struct {
+	// CollectionID is one of your collections to save the post into, or
+	// none.
+	CollectionID *int64 `json:"collection_id" validate:"omitempty,min=1"`
+}
+
+type BookmarkCollectionPayload struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type BookmarkList struct {
+	Bookmarks []store.Bookmark `json:"bookmarks"`
+	// NextCursor is passed as before to get the next page, it is missing on
+	// the last one.
+	NextCursor *int64 `json:"next_cursor,omitempty"`
+}
+
+// BookmarkPost godoc
+// @Summary Bookmark a post
+// @Description Saves the post to your bookmarks, which only you can see, optionally into one of your collections. Bookmarking it again moves it to the given collection
+// @Tags Bookmarks
+// @Accept json
+// @Param postID path int true "Post ID"
+// @Param payload body BookmarkPostPayload false "Collection"
+// @Success 204 "No Content"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /posts/{postID}/bookmark [put]
+func (app *application) bookmarkPostHandler(w http.ResponseWriter, r *http.Request) {
+	// The body is optional
+	var payload BookmarkPostPayload
+	if err := readJSON(w, r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	post, err := getPostFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if post.Status != store.PostPublished {
+		app.badRequestResponse(w, r, errors.New("only published posts can be bookmarked"))
+		return
+	}
+
+	user := getAuthUserFromContext(r)
+	if err := app.store.Bookmarks.Save(r.Context(), user.ID, post.ID, payload.CollectionID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("collection not found"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UnbookmarkPost godoc
+// @Summary Remove a bookmark
+// @Description Removes the post from your bookmarks
+// @Tags Bookmarks
+// @Param postID path int true "Post ID"
+// @Success 204 "No Content"
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /posts/{postID}/bookmark [delete]
+func (app *application) unbookmarkPostHandler(w http.ResponseWriter, r *http.Request) {
+	post, err := getPostFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Bookmarks.Delete(r.Context(), getAuthUserFromContext(r).ID, post.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetBookmarks godoc
+// @Summary List your bookmarks
+// @Description Lists your bookmarked posts, most recently bookmarked first. Posts that were deleted, or whose author blocked you, are left out
+// @Tags Bookmarks
+// @Produce json
+// @Param collection_id query int false "Only the bookmarks in this collection"
+// @Param before query int false "Cursor, from next_cursor of the previous page"
+// @Param limit query int false "Items per page (max 100)" default(20)
+// @Success 200 {object} BookmarkList
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/bookmarks [get]
+func (app *application) getBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	limit, _, err := readPagination(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+
+	var before int64
+	if s := query.Get("before"); s != "" {
+		before, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || before < 1 {
+			app.badRequestResponse(w, r, errors.New("invalid cursor"))
+			return
+		}
+	}
+
+	var collectionID *int64
+	if s := query.Get("collection_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 1 {
+			app.badRequestResponse(w, r, errors.New("invalid collection ID"))
+			return
+		}
+		collectionID = &id
+	}
+
+	ctx := r.Context()
+	bookmarks, err := app.store.Bookmarks.List(ctx, getAuthUserFromContext(r).ID, collectionID, before, limit)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	posts := make([]*store.Post, len(bookmarks))
+	for i := range bookmarks {
+		posts[i] = &bookmarks[i].Post.Post
+	}
+	if err := app.attachPreviews(ctx, posts...); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.attachMentions(ctx, posts...); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	res := BookmarkList{Bookmarks: bookmarks}
+	if len(bookmarks) == limit {
+		res.NextCursor = &bookmarks[len(bookmarks)-1].ID
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, res); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// attachBookmarked sets whether the viewer, if any, bookmarked the post.
+func (app *application) attachBookmarked(ctx context.Context, viewer *store.User, post *store.Post) error {
+	if viewer == nil {
+		return nil
+	}
+	bookmarked, err := app.store.Bookmarks.IsBookmarked(ctx, viewer.ID, post.ID)
+	if err != nil {
+		return err
+	}
+	post.Bookmarked = bookmarked
+	return nil
+}
+
+// GetBookmarkCollections godoc
+// @Summary List your bookmark collections
+// @Description Lists your bookmark collections by name, with how many bookmarks are in each
+// @Tags Bookmarks
+// @Produce json
+// @Success 200 {array} store.BookmarkCollection
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/bookmarks/collections [get]
+func (app *application) getBookmarkCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	collections, err := app.store.Bookmarks.GetCollections(r.Context(), getAuthUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, collections); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateBookmarkCollection godoc
+// @Summary Create a bookmark collection
+// @Description Creates a named collection to organize your bookmarks. Names are unique regardless of case
+// @Tags Bookmarks
+// @Accept json
+// @Produce json
+// @Param payload body BookmarkCollectionPayload true "Collection"
+// @Success 201 {object} store.BookmarkCollection
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string "Name taken"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/bookmarks/collections [post]
+func (app *application) createBookmarkCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var payload BookmarkCollectionPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	collection := &store.BookmarkCollection{
+		UserID: getAuthUserFromContext(r).ID,
+		Name:   payload.Name,
+	}
+	if err := app.store.Bookmarks.CreateCollection(r.Context(), collection); err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, errors.New("you already have a collection with that name"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, collection); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RenameBookmarkCollection godoc
+// @Summary Rename a bookmark collection
+// @Tags Bookmarks
+// @Accept json
+// @Produce json
+// @Param collectionID path int true "Collection ID"
+// @Param payload body BookmarkCollectionPayload true "Collection"
+// @Success 200 {object} store.BookmarkCollection
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Name taken"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/bookmarks/collections/{collectionID} [patch]
+func (app *application) renameBookmarkCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "collectionID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid collection ID"))
+		return
+	}
+
+	var payload BookmarkCollectionPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	collection := &store.BookmarkCollection{
+		ID:     id,
+		UserID: getAuthUserFromContext(r).ID,
+		Name:   payload.Name,
+	}
+	if err := app.store.Bookmarks.RenameCollection(r.Context(), collection); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("collection not found"))
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, errors.New("you already have a collection with that name"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, collection); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteBookmarkCollection godoc
+// @Summary Delete a bookmark collection
+// @Description Deletes the collection. Its bookmarks are kept, outside of any collection
+// @Tags Bookmarks
+// @Param collectionID path int true "Collection ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/bookmarks/collections/{collectionID} [delete]
+func (app *application) deleteBookmarkCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "collectionID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid collection ID"))
+		return
+	}
+
+	if err := app.store.Bookmarks.DeleteCollection(r.Context(), getAuthUserFromContext(r).ID, id); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("collection not found"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
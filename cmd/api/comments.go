@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/nati3514/Social/internal/moderation"
+	"github.com/nati3514/Social/internal/store"
+)
+
+type commentCtxKey struct{}
+
+type CreateCommentPayload struct {
+	Content string `json:"content" validate:"required,max=1000"`
+}
+
+// CreateComment godoc
+// @Summary Comment on a post
+// @Description Adds a comment to a post. @username mentions in it are linked to the users. Comments the content filters hold are saved hidden until a moderator reviews them
+// @Tags Comments
+// @Accept json
+// @Produce json
+// @Param postID path int true "Post ID"
+// @Param payload body CreateCommentPayload true "Comment"
+// @Success 201 {object} store.Comment
+// @Success 202 {object} store.Comment "Held for review"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 422 {object} map[string]string "Rejected by the content filters"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /posts/{postID}/comments [post]
+func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateCommentPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	post, err := getPostFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if post.Status != store.PostPublished {
+		app.badRequestResponse(w, r, errors.New("only published posts can be commented on"))
+		return
+	}
+
+	user := getAuthUserFromContext(r)
+
+	if user.ID != post.UserID {
+		blocked, err := app.store.Blocks.IsBlocked(r.Context(), user.ID, post.UserID)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if blocked {
+			app.forbiddenResponse(w, r, store.ErrBlocked)
+			return
+		}
+	}
+
+	hold, ok := app.screenContent(w, r, &moderation.Content{
+		Kind:   moderation.KindComment,
+		UserID: user.ID,
+		Text:   payload.Content,
+	})
+	if !ok {
+		return
+	}
+
+	comment := &store.Comment{
+		PostID:  post.ID,
+		UserID:  user.ID,
+		Content: payload.Content,
+		Hold:    hold,
+	}
+
+	if err := app.store.Comments.Create(r.Context(), comment); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	comment.User.Username = user.Username
+
+	status := http.StatusCreated
+	if comment.Hold != nil {
+		status = http.StatusAccepted
+	}
+
+	if err := app.jsonResponse(w, status, comment); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// commentContextMiddleware loads the comment in the URL, which must belong to
+// the post in the context.
+func (app *application) commentContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "commentID"), 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid comment ID"))
+			return
+		}
+
+		post, err := getPostFromContext(r)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		ctx := r.Context()
+		comment, err := app.store.Comments.GetByID(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.notFoundResponse(w, r, errors.New("comment not found"))
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		if comment.PostID != post.ID {
+			app.notFoundResponse(w, r, errors.New("comment not found"))
+			return
+		}
+
+		ctx = context.WithValue(ctx, commentCtxKey{}, comment)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireCommentOwner only lets the author of the comment in the context
+// through.
+func (app *application) requireCommentOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		comment, err := getCommentFromContext(r)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if comment.UserID != getAuthUserFromContext(r).ID {
+			app.forbiddenResponse(w, r, errors.New("you can only change your own comments"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func getCommentFromContext(r *http.Request) (*store.Comment, error) {
+	if comment, ok := r.Context().Value(commentCtxKey{}).(*store.Comment); ok {
+		return comment, nil
+	}
+	return nil, errors.New("comment not found in context")
+}
+
+// DeleteComment godoc
+// @Summary Delete a comment
+// @Description Moves one of your comments to the trash, from where it can be restored until it is purged
+// @Tags Comments
+// @Produce json
+// @Param postID path int true "Post ID"
+// @Param commentID path int true "Comment ID"
+// @Success 204
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /posts/{postID}/comments/{commentID} [delete]
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment, err := getCommentFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Comments.Delete(r.Context(), comment.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("comment not found"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RestoreComment godoc
+// @Summary Restore a deleted comment
+// @Description Takes one of your comments out of the trash, as long as it was deleted within the retention period
+// @Tags Comments
+// @Produce json
+// @Param postID path int true "Post ID"
+// @Param commentID path int true "Comment ID"
+// @Success 200 {object} store.Comment
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Comment is not deleted or was taken down by moderation"
+// @Failure 410 {object} map[string]string "Retention period is over"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /posts/{postID}/comments/{commentID}/restore [post]
+func (app *application) restoreCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment, err := getCommentFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if comment.DeletedAt == nil {
+		app.conflictResponse(w, r, errors.New("comment is not in the trash"))
+		return
+	}
+	if comment.Hidden {
+		app.conflictResponse(w, r, errors.New("comment was taken down by moderation and can only be restored by a moderator"))
+		return
+	}
+
+	if err := app.store.Comments.Restore(r.Context(), comment.ID, app.trashCutoff()); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.errorResponse(w, http.StatusGone, "comment was deleted too long ago to be restored")
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	comment.DeletedAt = nil
+
+	if err := app.jsonResponse(w, http.StatusOK, comment); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
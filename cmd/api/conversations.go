@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nati3514/Social/internal/store"
+)
+
+type conversationCtxKey struct{}
+
+type CreateConversationPayload struct {
+	// UserIDs are the other members; groups have at most 10 people.
+	UserIDs []int64 `json:"user_ids" validate:"required,min=1,max=9,dive,min=1"`
+	// Title makes a conversation with a single other user a group.
+	Title *string `json:"title" validate:"omitempty,min=1,max=100"`
+}
+
+type SendMessagePayload struct {
+	Content string `json:"content" validate:"required,max=2000"`
+}
+
+type MarkConversationReadPayload struct {
+	UpToID int64 `json:"up_to_id" validate:"required,min=1"`
+}
+
+// CreateConversation godoc
+// @Summary Start a conversation
+// @Description Starts a one-to-one conversation with a single user, or a group with up to 9 others or a title. You can only add people who follow you or have open DMs. If you already have a one-to-one conversation with the user it is returned with 200 instead
+// @Tags Conversations
+// @Accept json
+// @Produce json
+// @Param payload body CreateConversationPayload true "Members and optional title"
+// @Success 201 {object} store.Conversation
+// @Success 200 {object} store.Conversation "Existing one-to-one conversation"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /conversations [post]
+func (app *application) createConversationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateConversationPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getAuthUserFromContext(r)
+
+	slices.Sort(payload.UserIDs)
+	memberIDs := slices.Compact(payload.UserIDs)
+	if slices.Contains(memberIDs, user.ID) {
+		app.badRequestResponse(w, r, errors.New("you cannot start a conversation with yourself"))
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.checkCanMessage(ctx, user.ID, memberIDs); err != nil {
+		app.canMessageError(w, r, err)
+		return
+	}
+
+	conversation := &store.Conversation{
+		Title:     payload.Title,
+		CreatedBy: &user.ID,
+	}
+	created, err := app.store.Conversations.Create(ctx, conversation, memberIDs)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	if err := app.jsonResponse(w, status, conversation); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+var (
+	errCannotMessage      = errors.New("cannot message user")
+	errBlockedGroupMember = errors.New("a member of this group has blocked you")
+)
+
+// checkCanMessage returns a store.ErrNotFound or errCannotMessage error if the
+// sender may not message any of the users.
+func (app *application) checkCanMessage(ctx context.Context, senderID int64, userIDs []int64) error {
+	allowed, err := app.store.Conversations.CanMessage(ctx, senderID, userIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range userIDs {
+		ok, exists := allowed[id]
+		if !exists {
+			return fmt.Errorf("user %d: %w", id, store.ErrNotFound)
+		}
+		if !ok {
+			return fmt.Errorf("%w %d: they don't follow you and don't accept messages from everyone", errCannotMessage, id)
+		}
+	}
+	return nil
+}
+
+func (app *application) canMessageError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		app.notFoundResponse(w, r, errors.New("user not found"))
+	case errors.Is(err, errCannotMessage):
+		app.forbiddenResponse(w, r, err)
+	default:
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListConversations godoc
+// @Summary List your conversations
+// @Description Lists your conversations, the most recently active first, each with its members and their read receipts, the last message and how many messages you haven't read
+// @Tags Conversations
+// @Produce json
+// @Param limit query int false "Items per page (max 100)" default(20)
+// @Param offset query int false "Items to skip" default(0)
+// @Success 200 {array} store.Conversation
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /conversations [get]
+func (app *application) listConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := readPagination(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	conversations, err := app.store.Conversations.GetForUser(r.Context(), getAuthUserFromContext(r).ID, limit, offset)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, conversations); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// conversationContextMiddleware loads the conversation in the path, which
+// has to be one of the authenticated user's.
+func (app *application) conversationContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "conversationID"), 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid conversation ID"))
+			return
+		}
+
+		ctx := r.Context()
+		conversation, err := app.store.Conversations.Get(ctx, id, getAuthUserFromContext(r).ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.notFoundResponse(w, r, errors.New("conversation not found"))
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, conversationCtxKey{}, conversation)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getConversationFromContext(r *http.Request) (*store.Conversation, error) {
+	if conversation, ok := r.Context().Value(conversationCtxKey{}).(*store.Conversation); ok {
+		return conversation, nil
+	}
+	return nil, errors.New("conversation not found in context")
+}
+
+// GetConversation godoc
+// @Summary Get a conversation
+// @Tags Conversations
+// @Produce json
+// @Param conversationID path int true "Conversation ID"
+// @Success 200 {object} store.Conversation
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /conversations/{conversationID} [get]
+func (app *application) getConversationHandler(w http.ResponseWriter, r *http.Request) {
+	conversation, err := getConversationFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, conversation); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListMessages godoc
+// @Summary List messages
+// @Description Lists the messages of a conversation newest first. Pass the ID of the oldest message received as before to get the page before it
+// @Tags Conversations
+// @Produce json
+// @Param conversationID path int true "Conversation ID"
+// @Param before query int false "Only messages with a lower ID"
+// @Param limit query int false "Items per page (max 100)" default(20)
+// @Success 200 {array} store.Message
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /conversations/{conversationID}/messages [get]
+func (app *application) listMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	conversation, err := getConversationFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	limit, _, err := readPagination(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var before int64
+	if s := r.URL.Query().Get("before"); s != "" {
+		before, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || before < 1 {
+			app.badRequestResponse(w, r, errors.New("invalid cursor"))
+			return
+		}
+	}
+
+	messages, err := app.store.Conversations.GetMessages(r.Context(), conversation.ID, before, limit)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, messages); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// SendMessage godoc
+// @Summary Send a message
+// @Description Sends a message to a conversation. In a one-to-one conversation the other user still has to follow you or have open DMs, in a group no member may have blocked you
+// @Tags Conversations
+// @Accept json
+// @Produce json
+// @Param conversationID path int true "Conversation ID"
+// @Param payload body SendMessagePayload true "Message"
+// @Success 201 {object} store.Message
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /conversations/{conversationID}/messages [post]
+func (app *application) sendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	conversation, err := getConversationFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	var payload SendMessagePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := getAuthUserFromContext(r)
+
+	// Unfollowing someone stops their messages; group members were checked
+	// when they were added, but may have blocked the sender since. Blocking
+	// someone doesn't stop you writing in groups you share with them
+	if !conversation.IsGroup {
+		for _, m := range conversation.Members {
+			if m.UserID == user.ID {
+				continue
+			}
+			if err := app.checkCanMessage(ctx, user.ID, []int64{m.UserID}); err != nil {
+				app.canMessageError(w, r, err)
+				return
+			}
+		}
+	} else {
+		var others []int64
+		for _, m := range conversation.Members {
+			if m.UserID != user.ID {
+				others = append(others, m.UserID)
+			}
+		}
+		blocked, err := app.store.Blocks.BlockedByAny(ctx, user.ID, others)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if blocked {
+			app.forbiddenResponse(w, r, errBlockedGroupMember)
+			return
+		}
+	}
+
+	message := &store.Message{
+		ConversationID: conversation.ID,
+		UserID:         user.ID,
+		Username:       user.Username,
+		Content:        payload.Content,
+	}
+	if err := app.store.Conversations.CreateMessage(ctx, message); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, message); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// MarkConversationRead godoc
+// @Summary Mark a conversation as read
+// @Description Moves your read receipt up to the given message, which the other members see in last_read_message_id. Receipts never move back
+// @Tags Conversations
+// @Accept json
+// @Produce json
+// @Param conversationID path int true "Conversation ID"
+// @Param payload body MarkConversationReadPayload true "Newest message read"
+// @Success 200 {object} store.ReadReceipt
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /conversations/{conversationID}/read [post]
+func (app *application) markConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	conversation, err := getConversationFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	var payload MarkConversationReadPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getAuthUserFromContext(r)
+	lastRead, err := app.store.Conversations.MarkRead(r.Context(), conversation.ID, user.ID, payload.UpToID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("conversation not found"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	receipt := store.ReadReceipt{
+		ConversationID:    conversation.ID,
+		UserID:            user.ID,
+		LastReadMessageID: lastRead,
+	}
+	if err := app.jsonResponse(w, http.StatusOK, receipt); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -22,3 +22,22 @@ func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request,
 	//log.Printf("conflict error: %s path: %s", r.Method, r.URL.Path, err)
 	app.errorResponse(w, http.StatusConflict, err.Error())
 }
+
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter string) {
+	w.Header().Set("Retry-After", retryAfter)
+	app.errorResponse(w, http.StatusTooManyRequests, "rate limit exceeded, retry after: "+retryAfter)
+}
+
+func (app *application) unauthorizedErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, http.StatusUnauthorized, err.Error())
+}
+
+// contentRejectedResponse is for posts and comments the content filters
+// rejected.
+func (app *application) contentRejectedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, http.StatusUnprocessableEntity, err.Error())
+}
+
+func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, http.StatusForbidden, err.Error())
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/nati3514/Social/internal/mailer"
+	"github.com/nati3514/Social/internal/store"
+)
+
+// exportTimeout bounds how long building a single export may take.
+const exportTimeout = time.Minute
+
+// ExportData godoc
+// @Summary Export your data
+// @Description Starts building a ZIP of your profile, posts, comments, followers and following, unless one is already pending or ready. A download link is emailed once it is ready
+// @Tags Users
+// @Produce json
+// @Success 200 {object} store.DataExport "Export ready, the link has been emailed"
+// @Success 202 {object} store.DataExport "Export in progress"
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/export [get]
+func (app *application) exportDataHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromContext(r)
+	ctx := r.Context()
+
+	latest, err := app.store.DataExports.GetLatest(ctx, user.ID)
+	switch {
+	case err == nil && latest.Status == store.ExportPending:
+		app.respondWithExport(w, r, http.StatusAccepted, latest)
+		return
+	case err == nil && latest.Status == store.ExportReady && latest.ExpiresAt.After(time.Now()):
+		app.respondWithExport(w, r, http.StatusOK, latest)
+		return
+	case err != nil && !errors.Is(err, store.ErrNotFound):
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	export, err := app.store.DataExports.Create(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	go app.buildExport(*user, export)
+
+	app.respondWithExport(w, r, http.StatusAccepted, export)
+}
+
+func (app *application) respondWithExport(w http.ResponseWriter, r *http.Request, status int, export *store.DataExport) {
+	if err := app.jsonResponse(w, status, export); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) buildExport(user store.User, export *store.DataExport) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	fail := func(err error) {
+		log.Printf("Error building data export %d for user %d: %v\n", export.ID, user.ID, err)
+		if err := app.store.DataExports.Fail(ctx, export.ID); err != nil {
+			log.Printf("Error marking data export %d as failed: %v\n", export.ID, err)
+		}
+	}
+
+	data, err := app.store.DataExports.Collect(ctx, user.ID)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	for i := range data.Media {
+		app.setMediaURLs(&data.Media[i])
+	}
+
+	archive, err := zipUserData(data)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	plainToken := uuid.New().String()
+
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	if err := app.store.DataExports.Complete(ctx, export, archive, hashToken, app.config.account.exportExp); err != nil {
+		fail(err)
+		return
+	}
+
+	mail := struct {
+		Username    string
+		DownloadURL string
+		Expiry      string
+	}{
+		Username:    user.Username,
+		DownloadURL: fmt.Sprintf("%s/export/%s", app.config.frontendURL, plainToken),
+		Expiry:      app.config.account.exportExp.String(),
+	}
+
+	if err := app.mailer.Send(mailer.DataExportTemplate, user.Username, user.Email, mail); err != nil {
+		log.Printf("Error sending data export email to user %d: %v\n", user.ID, err)
+	}
+}
+
+// zipUserData writes each part of the export as its own JSON file.
+func zipUserData(data *store.UserData) ([]byte, error) {
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"profile.json", data.Profile},
+		{"posts.json", data.Posts},
+		{"comments.json", data.Comments},
+		{"followers.json", data.Followers},
+		{"following.json", data.Following},
+		{"conversations.json", data.Conversations},
+		{"messages.json", data.Messages},
+		{"bookmarks.json", data.Bookmarks},
+		{"collections.json", data.Collections},
+		{"media.json", data.Media},
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DownloadExport godoc
+// @Summary Download a data export
+// @Description Downloads the ZIP archive using the token from the export email
+// @Tags Users
+// @Produce application/zip
+// @Param token path string true "Export download token"
+// @Success 200 {file} file
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /users/export/{token} [get]
+func (app *application) downloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	archive, err := app.store.DataExports.GetArchive(r.Context(), token)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="social-export.zip"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
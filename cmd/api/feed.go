@@ -1,8 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nati3514/Social/internal/realtime"
+	"github.com/nati3514/Social/internal/store"
 )
 
 // GetUserFeed godoc
@@ -20,14 +30,15 @@ import (
 // @Param search query string false "Search in title and content"
 // @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
 // @Router /users/feed [get]
 func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	// For now, we're using user ID 1 for testing
-	// In a real app, you'd get this from the authenticated user's session
-	userID := int64(1)
+	userID := getAuthUserFromContext(r).ID
 
 	log.Printf("Fetching feed for user ID: %d\n", userID)
 
@@ -38,6 +49,19 @@ func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	posts := make([]*store.Post, len(feed))
+	for i := range feed {
+		posts[i] = &feed[i].Post
+	}
+	if err := app.attachPreviews(ctx, posts...); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.attachMentions(ctx, posts...); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
 	log.Printf("Successfully fetched %d posts for user ID: %d\n", len(feed), userID)
 
 	if err := app.jsonResponse(w, http.StatusOK, map[string]interface{}{
@@ -48,3 +72,169 @@ func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Reques
 		app.internalServerError(w, r, err)
 	}
 }
+
+const (
+	// keepAliveInterval is how often an idle stream gets a comment, so
+	// proxies don't time it out.
+	keepAliveInterval = 15 * time.Second
+	// streamReplayPageSize is how many missed posts are read at a time when
+	// a stream resumes.
+	streamReplayPageSize = 100
+)
+
+// StreamUserFeed godoc
+// @Summary Stream user feed
+// @Description Streams new posts of the feed as Server-Sent Events: each "post" event has the post's publication time, in Unix seconds, and ID joined by a dash as its id and a post with metadata, as in the feed, as its data. Reconnecting with the Last-Event-ID header replays the posts published since first. Comments are sent every 15 seconds to keep the connection alive
+// @Tags Feed
+// @Produce text/event-stream
+// @Param Last-Event-ID header string false "id of the last event received"
+// @Success 200 {object} store.PostWithMetadata
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/feed/stream [get]
+func (app *application) streamUserFeedHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getAuthUserFromContext(r).ID
+
+	var cursor *store.FeedCursor
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		c, err := parseFeedEventID(s)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		cursor = &c
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// Registered before replaying, so posts published meanwhile aren't missed
+	client := app.hub.Register(userID)
+	defer app.hub.Unregister(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(post *store.PostWithMetadata) error {
+		if err := app.attachPreviews(ctx, &post.Post); err != nil {
+			return err
+		}
+		if err := app.attachMentions(ctx, &post.Post); err != nil {
+			return err
+		}
+		data, err := json.Marshal(post)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %s\nevent: post\ndata: %s\n\n", feedEventID(post), data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	// Posts replayed may also come as events
+	replayed := map[int64]bool{}
+	if cursor != nil {
+		for {
+			feed, err := app.store.Posts.GetFeedSince(ctx, userID, *cursor, streamReplayPageSize)
+			if err != nil {
+				log.Printf("feed stream: replaying for user %d: %v\n", userID, err)
+				return
+			}
+			for i := range feed {
+				if err := send(&feed[i]); err != nil {
+					return
+				}
+				replayed[feed[i].ID] = true
+				if feed[i].PublishedAt != nil {
+					cursor.PublishedAt = *feed[i].PublishedAt
+				}
+				cursor.ID = feed[i].ID
+			}
+			if len(feed) < streamReplayPageSize {
+				break
+			}
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-client.Done():
+			return
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case payload := <-client.Send():
+			var event realtime.Event
+			if err := json.Unmarshal(payload, &event); err != nil || event.Type != realtime.EventFeedPost {
+				continue
+			}
+			var item store.FeedItem
+			if err := json.Unmarshal(event.Data, &item); err != nil || replayed[item.ID] {
+				continue
+			}
+
+			post, err := app.store.Posts.GetFeedPost(ctx, userID, item.ID)
+			if err != nil {
+				// Deleted again since, or unfollowed
+				if !errors.Is(err, store.ErrNotFound) {
+					log.Printf("feed stream: loading post %d: %v\n", item.ID, err)
+				}
+				continue
+			}
+			if err := send(post); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// feedEventID is the id of the stream event of a post: when it was published,
+// in Unix seconds, and its ID.
+func feedEventID(post *store.PostWithMetadata) string {
+	var published int64
+	if post.PublishedAt != nil {
+		published = post.PublishedAt.Unix()
+	}
+	return fmt.Sprintf("%d-%d", published, post.ID)
+}
+
+func parseFeedEventID(s string) (store.FeedCursor, error) {
+	errInvalid := errors.New("invalid Last-Event-ID")
+
+	published, id, ok := strings.Cut(s, "-")
+	if !ok {
+		return store.FeedCursor{}, errInvalid
+	}
+	sec, err := strconv.ParseInt(published, 10, 64)
+	if err != nil || sec < 0 {
+		return store.FeedCursor{}, errInvalid
+	}
+	postID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || postID < 0 {
+		return store.FeedCursor{}, errInvalid
+	}
+	return store.FeedCursor{PublishedAt: time.Unix(sec, 0), ID: postID}, nil
+}
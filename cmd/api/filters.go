@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nati3514/Social/internal/moderation"
+	"github.com/nati3514/Social/internal/store"
+)
+
+// spamTrainingExamples is how many of the latest moderation decisions the
+// spam classifier learns from.
+const spamTrainingExamples = 5000
+
+// newContentFilters sets up the filters posts and comments go through, in
+// the order they run.
+func newContentFilters(cfg moderationConfig, storage store.Storage, spam *moderation.SpamClassifier) *moderation.Pipeline {
+	recent := func(ctx context.Context, c *moderation.Content, since time.Time) ([]string, error) {
+		if c.Kind == moderation.KindComment {
+			return storage.Comments.GetRecentTexts(ctx, c.UserID, since, c.ID)
+		}
+		return storage.Posts.GetRecentTexts(ctx, c.UserID, since, c.ID)
+	}
+
+	return moderation.NewPipeline(
+		moderation.NewBannedWords(cfg.bannedWords, moderation.Reject),
+		moderation.NewBannedWords(cfg.heldWords, moderation.Hold),
+		moderation.NewLinkLimit(cfg.maxPostLinks, cfg.maxCommentLinks),
+		moderation.NewDuplicates(recent, cfg.duplicateWindow),
+		spam,
+	)
+}
+
+// splitList splits a comma separated setting, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// screenContent runs the content filters over what the user wrote. Rejected
+// content is responded to and false returned; held content comes back with
+// the hold to save it with. Moderators are not screened.
+func (app *application) screenContent(w http.ResponseWriter, r *http.Request, c *moderation.Content) (*store.Hold, bool) {
+	if getAuthUserFromContext(r).IsModerator() {
+		return nil, true
+	}
+
+	verdict := app.filters.Check(r.Context(), c)
+	switch verdict.Decision {
+	case moderation.Reject:
+		app.contentRejectedResponse(w, r, errors.New(verdict.Reason))
+		return nil, false
+	case moderation.Hold:
+		return &store.Hold{Category: verdict.Category, Reason: verdict.Reason}, true
+	default:
+		return nil, true
+	}
+}
+
+// runSpamTraining periodically retrains the spam classifier from moderation
+// decisions. It runs until ctx is cancelled.
+func (app *application) runSpamTraining(ctx context.Context) {
+	ticker := time.NewTicker(app.config.moderation.trainInterval)
+	defer ticker.Stop()
+
+	for {
+		app.trainSpamClassifier(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *application) trainSpamClassifier(ctx context.Context) {
+	decisions, err := app.store.Reports.GetDecisions(ctx, spamTrainingExamples)
+	if err != nil {
+		log.Printf("moderation: loading moderation decisions: %v\n", err)
+		return
+	}
+
+	examples := make([]moderation.Example, len(decisions))
+	for i, d := range decisions {
+		examples[i] = moderation.Example{Text: d.Text, Spam: d.Spam}
+	}
+	app.spam.Train(examples)
+
+	spam, ham := app.spam.Trained()
+	log.Printf("moderation: trained spam classifier on %d spam and %d other examples\n", spam, ham)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runJanitor periodically removes rows that are no longer useful. It runs
+// until ctx is cancelled.
+func (app *application) runJanitor(ctx context.Context) {
+	ticker := time.NewTicker(app.config.janitor.interval)
+	defer ticker.Stop()
+
+	for {
+		app.cleanup(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *application) cleanup(ctx context.Context) {
+	n, err := app.store.Users.DeleteExpiredInvitations(ctx)
+	if err != nil {
+		log.Printf("janitor: deleting expired invitations: %v\n", err)
+	} else if n > 0 {
+		log.Printf("janitor: deleted %d expired invitations\n", n)
+	}
+
+	n, err = app.store.Identities.DeleteExpiredLoginStates(ctx)
+	if err != nil {
+		log.Printf("janitor: deleting expired login states: %v\n", err)
+	} else if n > 0 {
+		log.Printf("janitor: deleted %d expired login states\n", n)
+	}
+
+	n, err = app.store.Sessions.DeleteExpiredWSTickets(ctx)
+	if err != nil {
+		log.Printf("janitor: deleting expired websocket tickets: %v\n", err)
+	} else if n > 0 {
+		log.Printf("janitor: deleted %d expired websocket tickets\n", n)
+	}
+
+	// Uploads are deleted first, the blobs can't be found once their rows are gone
+	removed, err := app.store.Media.DeleteOfScheduledUsers(ctx, time.Now())
+	if err != nil {
+		log.Printf("janitor: deleting media of scheduled accounts: %v\n", err)
+	}
+	for _, m := range removed {
+		app.deleteBlobs(ctx, m)
+	}
+
+	n, err = app.store.Users.DeleteScheduled(ctx, time.Now())
+	if err != nil {
+		log.Printf("janitor: deleting scheduled accounts: %v\n", err)
+	} else if n > 0 {
+		log.Printf("janitor: deleted %d accounts past their grace period\n", n)
+	}
+
+	n, err = app.store.DataExports.DeleteExpired(ctx, time.Now().Add(-exportTimeout))
+	if err != nil {
+		log.Printf("janitor: deleting expired data exports: %v\n", err)
+	} else if n > 0 {
+		log.Printf("janitor: deleted %d expired data exports\n", n)
+	}
+
+	n, err = app.store.LinkPreviews.DeleteExpired(ctx)
+	if err != nil {
+		log.Printf("janitor: deleting expired link previews: %v\n", err)
+	} else if n > 0 {
+		log.Printf("janitor: deleted %d expired link previews\n", n)
+	}
+
+	n, err = app.store.Notifications.DeleteReadBefore(ctx, time.Now().Add(-readNotificationTTL))
+	if err != nil {
+		log.Printf("janitor: deleting read notifications: %v\n", err)
+	} else if n > 0 {
+		log.Printf("janitor: deleted %d read notifications\n", n)
+	}
+
+	// Purge the trash first so the uploads of purged posts, which are now
+	// unattached, go in the same run
+	trashCutoff := app.trashCutoff()
+
+	n, err = app.store.Posts.PurgeDeleted(ctx, trashCutoff)
+	if err != nil {
+		log.Printf("janitor: purging deleted posts: %v\n", err)
+	} else if n > 0 {
+		log.Printf("janitor: purged %d deleted posts\n", n)
+	}
+
+	n, err = app.store.Comments.PurgeDeleted(ctx, trashCutoff)
+	if err != nil {
+		log.Printf("janitor: purging deleted comments: %v\n", err)
+	} else if n > 0 {
+		log.Printf("janitor: purged %d deleted comments\n", n)
+	}
+
+	removed, err = app.store.Media.DeleteUnattachedBefore(ctx, time.Now().Add(-app.config.media.unattachedTTL))
+	if err != nil {
+		log.Printf("janitor: deleting unattached media: %v\n", err)
+	} else if len(removed) > 0 {
+		log.Printf("janitor: deleted %d unattached uploads\n", len(removed))
+	}
+	for _, m := range removed {
+		app.deleteBlobs(ctx, m)
+	}
+
+	if ttl := app.config.janitor.unactivatedUserTTL; ttl > 0 {
+		n, err := app.store.Users.DeleteUnactivatedBefore(ctx, time.Now().Add(-ttl))
+		if err != nil {
+			log.Printf("janitor: deleting unactivated users: %v\n", err)
+		} else if n > 0 {
+			log.Printf("janitor: deleted %d unactivated users\n", n)
+		}
+	}
+}
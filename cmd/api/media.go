@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/nati3514/Social/internal/media"
+	"github.com/nati3514/Social/internal/store"
+)
+
+const maxAltTextLength = 1000
+
+type UpdateMediaPayload struct {
+	AltText string `json:"alt_text" validate:"max=1000"`
+}
+
+// UploadMedia godoc
+// @Summary Upload media
+// @Description Uploads an image to attach to a post later. The type is detected from the content; JPEG, PNG, GIF and WebP are accepted
+// @Tags Media
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "The file to upload"
+// @Param alt_text formData string false "Description of the image for screen readers"
+// @Success 201 {object} store.Media
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 415 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /media [post]
+func (app *application) uploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	maxSize := app.config.media.maxUploadSize
+
+	// leave some room for the rest of the multipart body
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			app.errorResponse(w, http.StatusRequestEntityTooLarge, "file is too large")
+			return
+		}
+		app.badRequestResponse(w, r, errors.New("a file is required"))
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if int64(len(data)) > maxSize {
+		app.errorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than %d bytes", maxSize))
+		return
+	}
+
+	altText := r.FormValue("alt_text")
+	if utf8.RuneCountInString(altText) > maxAltTextLength {
+		app.badRequestResponse(w, r, fmt.Errorf("alt text can be at most %d characters", maxAltTextLength))
+		return
+	}
+
+	upload, err := media.Process(data)
+	if err != nil {
+		switch err {
+		case media.ErrUnsupportedType:
+			app.errorResponse(w, http.StatusUnsupportedMediaType, err.Error())
+		case media.ErrImageTooLarge:
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user := getAuthUserFromContext(r)
+	ctx := r.Context()
+
+	m := &store.Media{
+		UserID:      user.ID,
+		Key:         mediaKey(upload.Extension),
+		ContentType: upload.ContentType,
+		Size:        int64(len(data)),
+		Width:       upload.Width,
+		Height:      upload.Height,
+		AltText:     altText,
+	}
+
+	if err := app.blobs.Put(ctx, m.Key, data, m.ContentType); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if upload.Thumbnail != nil {
+		thumbKey := mediaKey(upload.ThumbnailExtension)
+		if err := app.blobs.Put(ctx, thumbKey, upload.Thumbnail, upload.ThumbnailType); err != nil {
+			app.deleteBlobs(ctx, *m)
+			app.internalServerError(w, r, err)
+			return
+		}
+		m.ThumbnailKey = &thumbKey
+	}
+
+	if err := app.store.Media.Create(ctx, m); err != nil {
+		app.deleteBlobs(ctx, *m)
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.setMediaURLs(m)
+
+	if err := app.jsonResponse(w, http.StatusCreated, m); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UpdateMedia godoc
+// @Summary Update media
+// @Description Changes the alt text of one of your uploads
+// @Tags Media
+// @Accept json
+// @Produce json
+// @Param mediaID path int true "Media ID"
+// @Param payload body UpdateMediaPayload true "New alt text"
+// @Success 200 {object} store.Media
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /media/{mediaID} [patch]
+func (app *application) updateMediaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "mediaID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid media ID"))
+		return
+	}
+
+	var payload UpdateMediaPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	m, err := app.store.Media.GetByID(ctx, id)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// Someone else's upload is reported as missing rather than forbidden
+	if m.UserID != getAuthUserFromContext(r).ID {
+		app.notFoundResponse(w, r, store.ErrNotFound)
+		return
+	}
+
+	m.AltText = payload.AltText
+	if err := app.store.Media.UpdateAltText(ctx, m); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.setMediaURLs(m)
+
+	if err := app.jsonResponse(w, http.StatusOK, m); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// serveMediaFileHandler serves uploads kept on the local filesystem. Blobs in
+// S3 are downloaded from the bucket directly.
+func (app *application) serveMediaFileHandler(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
+
+	blob, err := app.blobs.Get(r.Context(), key)
+	if err != nil {
+		switch err {
+		case media.ErrBlobNotFound, media.ErrInvalidKey:
+			app.notFoundResponse(w, r, store.ErrNotFound)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	defer blob.Close()
+
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Printf("Error serving media %s: %v\n", key, err)
+	}
+}
+
+// mediaKey returns a new unguessable blob key, grouped by month.
+func mediaKey(ext string) string {
+	return fmt.Sprintf("%s/%s%s", time.Now().UTC().Format("2006/01"), uuid.New().String(), ext)
+}
+
+func (app *application) setMediaURLs(m *store.Media) {
+	m.URL = app.blobs.URL(m.Key)
+	if m.ThumbnailKey != nil {
+		m.ThumbnailURL = app.blobs.URL(*m.ThumbnailKey)
+	}
+}
+
+// deleteBlobs removes the files of an upload, logging rather than failing
+// since a leftover blob does no harm.
+func (app *application) deleteBlobs(ctx context.Context, m store.Media) {
+	keys := []string{m.Key}
+	if m.ThumbnailKey != nil {
+		keys = append(keys, *m.ThumbnailKey)
+	}
+
+	for _, key := range keys {
+		if err := app.blobs.Delete(ctx, key); err != nil {
+			log.Printf("Error deleting media blob %s: %v\n", key, err)
+		}
+	}
+}
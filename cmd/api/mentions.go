@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/nati3514/Social/internal/store"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// GetMentions godoc
+// @Summary List your mentions
+// @Description Lists the published posts and comments you were @mentioned in, most recent first
+// @Tags Users
+// @Produce json
+// @Param limit query int false "Items per page (max 100)" default(20)
+// @Param offset query int false "Items to skip" default(0)
+// @Success 200 {array} store.MentionedIn
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/mentions [get]
+func (app *application) getMentionsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := readPagination(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	mentions, err := app.store.Mentions.GetForUser(r.Context(), getAuthUserFromContext(r).ID, limit, offset)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, mentions); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// attachMentions sets the mentions of the posts and their comments.
+func (app *application) attachMentions(ctx context.Context, posts ...*store.Post) error {
+	postIDs := make([]int64, len(posts))
+	var commentIDs []int64
+	for i, post := range posts {
+		postIDs[i] = post.ID
+		for _, c := range post.Comments {
+			commentIDs = append(commentIDs, c.ID)
+		}
+	}
+
+	postMentions, err := app.store.Mentions.GetByPosts(ctx, postIDs)
+	if err != nil {
+		return err
+	}
+	commentMentions, err := app.store.Mentions.GetByComments(ctx, commentIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		post.Mentions = postMentions[post.ID]
+		for i := range post.Comments {
+			post.Comments[i].Mentions = commentMentions[post.Comments[i].ID]
+		}
+	}
+	return nil
+}
+
+// readPagination reads the limit and offset query parameters.
+func readPagination(r *http.Request) (limit, offset int, err error) {
+	query := r.URL.Query()
+
+	limit = defaultPageSize
+	if s := query.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return 0, 0, errors.New("limit must be between 1 and 100")
+		}
+	}
+
+	if s := query.Get("offset"); s != "" {
+		offset, err = strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be a positive number")
+		}
+	}
+
+	return limit, offset, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/nati3514/Social/internal/ratelimiter"
+	"github.com/nati3514/Social/internal/store"
+)
+
+const (
+	authUserCtx   userKey = "authUser"
+	authScopesCtx userKey = "authScopes"
+)
+
+// AuthTokenMiddleware requires a valid session or personal access token in
+// the Authorization header and stores its user in the request context. For
+// personal access tokens the granted scopes are stored as well.
+func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			app.unauthorizedErrorResponse(w, r, errors.New("authorization header is missing"))
+			return
+		}
+
+		parts := strings.SplitN(authHeader, " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+			app.unauthorizedErrorResponse(w, r, errors.New("authorization header is malformed"))
+			return
+		}
+
+		ctx := r.Context()
+		token := parts[1]
+
+		var user *store.User
+		var err error
+		if strings.HasPrefix(token, accessTokenPrefix) {
+			var scopes []string
+			user, scopes, err = app.store.AccessTokens.GetUserByToken(ctx, token)
+			ctx = context.WithValue(ctx, authScopesCtx, scopes)
+		} else {
+			user, err = app.store.Sessions.GetUserByToken(ctx, token)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.unauthorizedErrorResponse(w, r, errors.New("invalid or expired token"))
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, authUserCtx, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// OptionalAuthTokenMiddleware authenticates requests that carry an
+// Authorization header and lets anonymous ones through.
+func (app *application) OptionalAuthTokenMiddleware(next http.Handler) http.Handler {
+	authenticated := app.AuthTokenMiddleware(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
+func getAuthUserFromContext(r *http.Request) *store.User {
+	user, _ := r.Context().Value(authUserCtx).(*store.User)
+	return user
+}
+
+// requireScope rejects personal access tokens that were not granted scope.
+// Sessions are not restricted.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if scopes, ok := r.Context().Value(authScopesCtx).([]string); ok && !slices.Contains(scopes, scope) {
+				app.forbiddenResponse(w, r, fmt.Errorf("token is missing the %s scope", scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireSession rejects personal access tokens, for endpoints such as token
+// management that a bot should never be able to reach.
+func (app *application) requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(authScopesCtx).([]string); ok {
+			app.forbiddenResponse(w, r, errors.New("this endpoint cannot be used with a personal access token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireModerator rejects users who are neither moderators nor admins.
+func (app *application) requireModerator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !getAuthUserFromContext(r).IsModerator() {
+			app.forbiddenResponse(w, r, errors.New("this endpoint is for moderators"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdmin rejects users who aren't admins.
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !getAuthUserFromContext(r).IsAdmin() {
+			app.forbiddenResponse(w, r, errors.New("this endpoint is for admins"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitMiddleware limits requests per client IP. The RealIP middleware
+// has already replaced RemoteAddr with the forwarded address.
+func (app *application) rateLimitMiddleware(limiter ratelimiter.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if app.config.rateLimiter.Enabled {
+				if allow, retryAfter := limiter.Allow(clientIP(r)); !allow {
+					app.rateLimitExceededResponse(w, r, fmt.Sprintf("%.0f", math.Ceil(retryAfter.Seconds())))
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP returns the client address without its port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
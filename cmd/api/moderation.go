@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nati3514/Social/internal/store"
+)
+
+type reportCtxKey struct{}
+
+type ResolveReportPayload struct {
+	Action string `json:"action" validate:"required,oneof=dismiss hide warn suspend"`
+	// Note is kept in the audit trail, it is not shown to the users.
+	Note string `json:"note" validate:"max=1000"`
+}
+
+// ListReports godoc
+// @Summary List reports
+// @Description Lists the reports with a status, oldest first. target_reports is how many users reported the same target
+// @Tags Moderation
+// @Produce json
+// @Param status query string false "open, claimed or resolved" default(open)
+// @Param limit query int false "Items per page (max 100)" default(20)
+// @Param offset query int false "Items to skip" default(0)
+// @Success 200 {array} store.Report
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/reports [get]
+func (app *application) listReportsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := readPagination(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = store.ReportOpen
+	case store.ReportOpen, store.ReportClaimed, store.ReportResolved:
+	default:
+		app.badRequestResponse(w, r, errors.New("status must be open, claimed or resolved"))
+		return
+	}
+
+	reports, err := app.store.Reports.List(r.Context(), status, limit, offset)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, reports); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) reportContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "reportID"), 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid report ID"))
+			return
+		}
+
+		ctx := r.Context()
+		report, err := app.store.Reports.Get(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.notFoundResponse(w, r, errors.New("report not found"))
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, reportCtxKey{}, report)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getReportFromContext(r *http.Request) (*store.Report, error) {
+	if report, ok := r.Context().Value(reportCtxKey{}).(*store.Report); ok {
+		return report, nil
+	}
+	return nil, errors.New("report not found in context")
+}
+
+// requireOtherUsersReport keeps moderators from handling reports about
+// themselves.
+func (app *application) requireOtherUsersReport(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, err := getReportFromContext(r)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if report.TargetUserID == getAuthUserFromContext(r).ID {
+			app.forbiddenResponse(w, r, errors.New("reports about you are handled by other moderators"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetReport godoc
+// @Summary Get a report
+// @Description Gets a report with its audit trail
+// @Tags Moderation
+// @Produce json
+// @Param reportID path int true "Report ID"
+// @Success 200 {object} store.Report
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/reports/{reportID} [get]
+func (app *application) getReportHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := getReportFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, report); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ClaimReport godoc
+// @Summary Claim a report
+// @Description Assigns an open report to you, so other moderators leave it alone
+// @Tags Moderation
+// @Produce json
+// @Param reportID path int true "Report ID"
+// @Success 200 {object} store.Report
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Already claimed or resolved"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/reports/{reportID}/claim [post]
+func (app *application) claimReportHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := getReportFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.store.Reports.Claim(ctx, report.ID, getAuthUserFromContext(r).ID); err != nil {
+		app.moderationError(w, r, err)
+		return
+	}
+
+	app.respondWithReport(w, r, report.ID)
+}
+
+// ResolveReport godoc
+// @Summary Resolve a report
+// @Description Takes an action on what was reported and closes the report, along with every other open report of the same post, comment or user. dismiss leaves it be, hide removes the post or comment for good, warn sends its author a warning and suspend locks their account. The reporters are notified of the outcome. Open reports can be resolved without claiming them first
+// @Tags Moderation
+// @Accept json
+// @Produce json
+// @Param reportID path int true "Report ID"
+// @Param payload body ResolveReportPayload true "Action"
+// @Success 200 {object} store.Report
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Claimed by another moderator or already resolved"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/reports/{reportID}/resolve [post]
+func (app *application) resolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := getReportFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	var payload ResolveReportPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	if payload.Action == store.ReportActionSuspend {
+		target, err := app.store.Users.GetByID(ctx, report.TargetUserID)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if target.IsModerator() {
+			app.forbiddenResponse(w, r, errors.New("moderators cannot be suspended"))
+			return
+		}
+	}
+
+	if err := app.store.Reports.Resolve(ctx, report.ID, getAuthUserFromContext(r).ID, payload.Action, payload.Note); err != nil {
+		app.moderationError(w, r, err)
+		return
+	}
+
+	app.respondWithReport(w, r, report.ID)
+}
+
+func (app *application) moderationError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		app.notFoundResponse(w, r, errors.New("report not found"))
+	case errors.Is(err, store.ErrConflict):
+		app.conflictResponse(w, r, errors.New("the report was claimed by another moderator or already resolved"))
+	case errors.Is(err, store.ErrHideProfile):
+		app.badRequestResponse(w, r, err)
+	default:
+		app.internalServerError(w, r, err)
+	}
+}
+
+// respondWithReport responds with the report as it is after an action.
+func (app *application) respondWithReport(w http.ResponseWriter, r *http.Request, id int64) {
+	report, err := app.store.Reports.Get(r.Context(), id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, report); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
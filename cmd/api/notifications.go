@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nati3514/Social/internal/notifications"
+)
+
+// readNotificationTTL is how long notifications are kept once read.
+const readNotificationTTL = 90 * 24 * time.Hour
+
+type NotificationList struct {
+	Notifications []notifications.Notification `json:"notifications"`
+	UnreadCount   int                          `json:"unread_count"`
+	// NextCursor is passed as before to get the next page, it is missing on
+	// the last one.
+	NextCursor *int64 `json:"next_cursor,omitempty"`
+}
+
+type MarkNotificationsReadPayload struct {
+	UpToID int64 `json:"up_to_id" validate:"required,min=1"`
+}
+
+// GetNotifications godoc
+// @Summary List your notifications
+// @Description Lists your notifications newest first. Follows, comments on the same post and mentions in the same post or comment are grouped while unread
+// @Tags Notifications
+// @Produce json
+// @Param before query int false "Cursor, from next_cursor of the previous page"
+// @Param limit query int false "Items per page (max 100)" default(20)
+// @Success 200 {object} NotificationList
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /notifications [get]
+func (app *application) getNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, _, err := readPagination(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var before int64
+	if s := r.URL.Query().Get("before"); s != "" {
+		before, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || before < 1 {
+			app.badRequestResponse(w, r, errors.New("invalid cursor"))
+			return
+		}
+	}
+
+	ctx := r.Context()
+	user := getAuthUserFromContext(r)
+
+	list, err := app.store.Notifications.List(ctx, user.ID, before, limit)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	unread, err := app.store.Notifications.UnreadCount(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	res := NotificationList{Notifications: list, UnreadCount: unread}
+	if len(list) == limit {
+		res.NextCursor = &list[len(list)-1].ID
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, res); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// MarkNotificationsRead godoc
+// @Summary Mark notifications as read
+// @Description Marks your notifications up to and including the given ID as read and returns the unread count
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Param payload body MarkNotificationsReadPayload true "Newest notification read"
+// @Success 200 {object} map[string]int
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /notifications/read [post]
+func (app *application) markNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MarkNotificationsReadPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := getAuthUserFromContext(r)
+
+	if _, err := app.store.Notifications.MarkRead(ctx, user.ID, payload.UpToID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	unread, err := app.store.Notifications.UnreadCount(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, map[string]int{"unread_count": unread}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
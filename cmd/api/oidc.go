@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/nati3514/Social/internal/oidc"
+	"github.com/nati3514/Social/internal/store"
+)
+
+// OIDCLogin godoc
+// @Summary Start an external login
+// @Description Redirects to the identity provider using the authorization code flow with PKCE
+// @Tags Authentication
+// @Param provider path string true "Provider name"
+// @Success 302 "Redirect to the provider"
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /authentication/oidc/{provider} [get]
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("unknown identity provider"))
+		return
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	hash := sha256.Sum256([]byte(state))
+	hashState := hex.EncodeToString(hash[:])
+
+	ls := &store.OIDCLoginState{
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	}
+	if err := app.store.Identities.CreateLoginState(ctx, hashState, ls, app.config.oidc.stateExp); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback godoc
+// @Summary Finish an external login
+// @Description Validates the provider's response and logs in the linked user, linking or creating an account on first login when the provider has verified the email
+// @Tags Authentication
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State from the login request"
+// @Success 201 {object} TokenResponse
+// @Success 202 {object} LoginChallengeResponse "Two-factor code required"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /authentication/oidc/{provider}/callback [get]
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("unknown identity provider"))
+		return
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		app.badRequestResponse(w, r, errors.New("identity provider returned an error: "+e))
+		return
+	}
+
+	code, state := q.Get("code"), q.Get("state")
+	if code == "" || state == "" {
+		app.badRequestResponse(w, r, errors.New("code and state are required"))
+		return
+	}
+
+	ctx := r.Context()
+
+	ls, err := app.store.Identities.ConsumeLoginState(ctx, state)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedErrorResponse(w, r, errors.New("invalid or expired state"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if ls.Provider != provider.Name() {
+		app.unauthorizedErrorResponse(w, r, errors.New("invalid or expired state"))
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(ctx, code, ls.CodeVerifier)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, errors.New("could not exchange authorization code"))
+		return
+	}
+
+	claims, err := provider.Verify(ctx, rawIDToken, ls.Nonce)
+	if err != nil {
+		if errors.Is(err, oidc.ErrInvalidToken) {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.userForIdentity(ctx, provider.Name(), claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errEmailNotVerified), errors.Is(err, errAccountNotActivated):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if user.SuspendedAt != nil {
+		app.forbiddenResponse(w, r, errAccountSuspended)
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		app.respondWithLoginChallenge(w, r, user)
+		return
+	}
+
+	res, err := app.createSession(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, res); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+var (
+	errEmailNotVerified    = errors.New("the identity provider has not verified this email address")
+	errAccountNotActivated = errors.New("an account with this email exists but has not been activated")
+)
+
+// userForIdentity returns the user linked to the external identity. On first
+// login the identity is linked to the account with the same email, or a new
+// activated account is created, but only if the provider verified the email.
+func (app *application) userForIdentity(ctx context.Context, provider string, claims *oidc.Claims) (*store.User, error) {
+	user, err := app.store.Identities.GetUser(ctx, provider, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errEmailNotVerified
+	}
+
+	identity := &store.Identity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	user, err = app.store.Users.GetByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		// An unactivated account may have been registered by someone else
+		// with this address, linking it would hand them the login.
+		if !user.IsActive {
+			return nil, errAccountNotActivated
+		}
+		identity.UserID = user.ID
+		if err := app.store.Identities.Link(ctx, identity); err != nil {
+			return nil, err
+		}
+		return user, nil
+	case !errors.Is(err, store.ErrNotFound):
+		return nil, err
+	}
+
+	user = &store.User{Email: claims.Email}
+
+	// The account can only be used through the provider until the user
+	// resets the password.
+	if err := user.Password.Set(uuid.New().String()); err != nil {
+		return nil, err
+	}
+
+	base := claims.Username
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	if err := app.store.Identities.CreateUser(ctx, user, base, identity); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nati3514/Social/internal/oidc"
+	"github.com/nati3514/Social/internal/store"
+)
+
+// testIssuer is an identity provider whose token endpoint hands out an ID
+// token with the claims the test sets.
+type testIssuer struct {
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 iss.srv.URL,
+			"authorization_endpoint": iss.srv.URL + "/authorize",
+			"token_endpoint":         iss.srv.URL + "/token",
+			"jwks_uri":               iss.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   enc(key.N.Bytes()),
+			"e":   enc(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "code-1" || oidc.CodeChallenge(r.PostFormValue("code_verifier")) != oidc.CodeChallenge("verifier-1") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": iss.sign(t)})
+	})
+	iss.srv = httptest.NewServer(mux)
+	t.Cleanup(iss.srv.Close)
+
+	iss.claims = map[string]any{
+		"iss":            iss.srv.URL,
+		"sub":            "subject-1",
+		"aud":            "client-1",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          "nonce-1",
+		"email":          "ada@example.com",
+		"email_verified": true,
+	}
+	return iss
+}
+
+func (iss *testIssuer) sign(t *testing.T) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	payload, _ := json.Marshal(iss.claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Error(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// The fakes embed the real stores so they satisfy the interfaces, calling a
+// method they don't override panics.
+type fakeIdentities struct {
+	*store.IdentityStore
+	loginState *store.OIDCLoginState
+	linked     map[string]*store.User
+	links      []store.Identity
+	created    []store.Identity
+}
+
+func (f *fakeIdentities) ConsumeLoginState(ctx context.Context, state string) (*store.OIDCLoginState, error) {
+	if state != "state-1" || f.loginState == nil {
+		return nil, store.ErrNotFound
+	}
+	ls := f.loginState
+	f.loginState = nil
+	return ls, nil
+}
+
+func (f *fakeIdentities) GetUser(ctx context.Context, provider, subject string) (*store.User, error) {
+	if u, ok := f.linked[provider+"/"+subject]; ok {
+		return u, nil
+	}
+	return nil, store.ErrNotFound
+}
+
+func (f *fakeIdentities) Link(ctx context.Context, identity *store.Identity) error {
+	f.links = append(f.links, *identity)
+	return nil
+}
+
+func (f *fakeIdentities) CreateUser(ctx context.Context, user *store.User, base string, identity *store.Identity) error {
+	user.ID = 100
+	user.Username = base
+	user.IsActive = true
+	identity.UserID = user.ID
+	f.created = append(f.created, *identity)
+	return nil
+}
+
+type fakeUsers struct {
+	*store.UserStore
+	byEmail map[string]*store.User
+}
+
+func (f *fakeUsers) GetByEmail(ctx context.Context, email string) (*store.User, error) {
+	if u, ok := f.byEmail[email]; ok {
+		return u, nil
+	}
+	return nil, store.ErrNotFound
+}
+
+func (f *fakeUsers) CancelDeletion(ctx context.Context, userID int64) (bool, error) {
+	return false, nil
+}
+
+type fakeSessions struct {
+	*store.SessionStore
+	users []int64
+}
+
+func (f *fakeSessions) Create(ctx context.Context, userID int64, token string, exp time.Duration) error {
+	f.users = append(f.users, userID)
+	return nil
+}
+
+type callbackTest struct {
+	iss        *testIssuer
+	app        *application
+	identities *fakeIdentities
+	users      *fakeUsers
+	sessions   *fakeSessions
+}
+
+func newCallbackTest(t *testing.T) *callbackTest {
+	iss := newTestIssuer(t)
+	ct := &callbackTest{
+		iss: iss,
+		identities: &fakeIdentities{
+			loginState: &store.OIDCLoginState{Provider: "test", Nonce: "nonce-1", CodeVerifier: "verifier-1"},
+			linked:     map[string]*store.User{},
+		},
+		users:    &fakeUsers{byEmail: map[string]*store.User{}},
+		sessions: &fakeSessions{},
+	}
+	ct.app = &application{
+		config: config{auth: authConfig{token: tokenConfig{exp: time.Hour}}},
+		store: store.Storage{
+			Identities: ct.identities,
+			Users:      ct.users,
+			Sessions:   ct.sessions,
+		},
+		oidcProviders: map[string]*oidc.Provider{
+			"test": oidc.NewProvider(oidc.Config{
+				Name:        "test",
+				Issuer:      iss.srv.URL,
+				ClientID:    "client-1",
+				RedirectURL: "https://social.example/callback",
+			}, iss.srv.Client()),
+		},
+	}
+	return ct
+}
+
+func (ct *callbackTest) callback(t *testing.T) *httptest.ResponseRecorder {
+	t.Helper()
+	r := chi.NewRouter()
+	r.Get("/authentication/oidc/{provider}/callback", ct.app.oidcCallbackHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/authentication/oidc/test/callback?code=code-1&state=state-1", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestOIDCCallbackLinksVerifiedEmail(t *testing.T) {
+	ct := newCallbackTest(t)
+	ct.users.byEmail["ada@example.com"] = &store.User{ID: 7, Email: "ada@example.com", IsActive: true}
+
+	rr := ct.callback(t)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got %d %s, want 201", rr.Code, rr.Body)
+	}
+
+	if len(ct.identities.links) != 1 {
+		t.Fatalf("got %d links, want 1", len(ct.identities.links))
+	}
+	if l := ct.identities.links[0]; l.UserID != 7 || l.Provider != "test" || l.Subject != "subject-1" || l.Email != "ada@example.com" {
+		t.Errorf("linked %+v", l)
+	}
+	if len(ct.sessions.users) != 1 || ct.sessions.users[0] != 7 {
+		t.Errorf("got sessions for %v, want user 7", ct.sessions.users)
+	}
+
+	// State is single use
+	if rr := ct.callback(t); rr.Code != http.StatusUnauthorized {
+		t.Errorf("replayed state: got %d, want 401", rr.Code)
+	}
+}
+
+func TestOIDCCallbackRejectsUnverifiedEmail(t *testing.T) {
+	for name, verified := range map[string]any{"false": false, "missing": nil, "string false": "false"} {
+		t.Run(name, func(t *testing.T) {
+			ct := newCallbackTest(t)
+			ct.users.byEmail["ada@example.com"] = &store.User{ID: 7, Email: "ada@example.com", IsActive: true}
+			if verified == nil {
+				delete(ct.iss.claims, "email_verified")
+			} else {
+				ct.iss.claims["email_verified"] = verified
+			}
+
+			rr := ct.callback(t)
+			if rr.Code != http.StatusForbidden {
+				t.Fatalf("got %d %s, want 403", rr.Code, rr.Body)
+			}
+			if len(ct.identities.links) != 0 || len(ct.identities.created) != 0 {
+				t.Error("an unverified email was linked to an account")
+			}
+			if len(ct.sessions.users) != 0 {
+				t.Error("a session was created")
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsUnactivatedAccount(t *testing.T) {
+	ct := newCallbackTest(t)
+	// Anyone can register an address they don't own and leave it unactivated
+	ct.users.byEmail["ada@example.com"] = &store.User{ID: 7, Email: "ada@example.com"}
+
+	rr := ct.callback(t)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("got %d %s, want 403", rr.Code, rr.Body)
+	}
+	if len(ct.identities.links) != 0 || len(ct.sessions.users) != 0 {
+		t.Error("an unactivated account was linked")
+	}
+}
+
+func TestOIDCCallbackLinkedIdentity(t *testing.T) {
+	ct := newCallbackTest(t)
+	ct.identities.linked["test/subject-1"] = &store.User{ID: 9, IsActive: true}
+	// Already linked identities log in whatever the email claims
+	ct.iss.claims["email_verified"] = false
+
+	rr := ct.callback(t)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got %d %s, want 201", rr.Code, rr.Body)
+	}
+	if len(ct.sessions.users) != 1 || ct.sessions.users[0] != 9 {
+		t.Errorf("got sessions for %v, want user 9", ct.sessions.users)
+	}
+	if len(ct.identities.links) != 0 {
+		t.Error("a linked identity was linked again")
+	}
+}
+
+func TestOIDCCallbackCreatesAccount(t *testing.T) {
+	ct := newCallbackTest(t)
+
+	rr := ct.callback(t)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got %d %s, want 201", rr.Code, rr.Body)
+	}
+	if len(ct.identities.created) != 1 || ct.identities.created[0].Subject != "subject-1" {
+		t.Fatalf("created %+v", ct.identities.created)
+	}
+	if len(ct.sessions.users) != 1 || ct.sessions.users[0] != 100 {
+		t.Errorf("got sessions for %v, want the new user", ct.sessions.users)
+	}
+}
+
+func TestOIDCCallbackSuspendedUser(t *testing.T) {
+	ct := newCallbackTest(t)
+	now := time.Now()
+	ct.identities.linked["test/subject-1"] = &store.User{ID: 9, IsActive: true, SuspendedAt: &now}
+
+	if rr := ct.callback(t); rr.Code != http.StatusForbidden {
+		t.Fatalf("got %d %s, want 403", rr.Code, rr.Body)
+	}
+	if len(ct.sessions.users) != 0 {
+		t.Error("a session was created for a suspended user")
+	}
+}
+
+func TestOIDCCallbackInvalidToken(t *testing.T) {
+	ct := newCallbackTest(t)
+	ct.iss.claims["nonce"] = "someone-elses-nonce"
+
+	if rr := ct.callback(t); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("got %d %s, want 401", rr.Code, rr.Body)
+	}
+	if len(ct.sessions.users) != 0 {
+		t.Error("a session was created")
+	}
+}
+
+func TestOIDCCallbackStateFromOtherProvider(t *testing.T) {
+	ct := newCallbackTest(t)
+	ct.identities.loginState.Provider = "other"
+
+	if rr := ct.callback(t); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("got %d %s, want 401", rr.Code, rr.Body)
+	}
+}
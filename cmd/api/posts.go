@@ -5,23 +5,48 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/nati3514/Social/internal/moderation"
 	"github.com/nati3514/Social/internal/store"
 )
 
 type createPostPayload struct {
-	Title   string   `json:"title" validate:"required,max=100"`
-	Content string   `json:"content" validate:"required,max=1000"`
-	Tags    []string `json:"tags"`
+	Title    string   `json:"title" validate:"required,max=100"`
+	Content  string   `json:"content" validate:"required,max=1000"`
+	Tags     []string `json:"tags"`
+	MediaIDs []int64  `json:"media_ids" validate:"max=4,unique"`
+	// Status defaults to published. Scheduled posts need a PublishAt.
+	Status    string     `json:"status" validate:"omitempty,oneof=draft scheduled published"`
+	PublishAt *time.Time `json:"publish_at"`
 }
 
 type UpdatePostRequest struct {
-	Title   *string   `json:"title" validate:"omitempty,max=100"`
-	Content *string   `json:"content" validate:"omitempty,max=1000"`
-	Tags    *[]string `json:"tags" validate:"omitempty"`
-	Version *int32    `json:"version" validate:"omitempty"`
+	Title     *string    `json:"title" validate:"omitempty,max=100"`
+	Content   *string    `json:"content" validate:"omitempty,max=1000"`
+	Tags      *[]string  `json:"tags" validate:"omitempty"`
+	Status    *string    `json:"status" validate:"omitempty,oneof=draft scheduled published"`
+	PublishAt *time.Time `json:"publish_at"`
+	Version   *int32     `json:"version" validate:"omitempty"`
+}
+
+// checkPublishing validates the status and publish time of a post, dropping
+// the publish time of posts that are not scheduled.
+func checkPublishing(post *store.Post) error {
+	if post.Status != store.PostScheduled {
+		post.PublishAt = nil
+		return nil
+	}
+	if post.PublishAt == nil {
+		return errors.New("scheduled posts need a publish_at time")
+	}
+	if !post.PublishAt.After(time.Now()) {
+		return errors.New("publish_at must be in the future")
+	}
+	return nil
 }
 
 type postCtxKey struct{}
@@ -34,14 +59,19 @@ func writeJSON(w http.ResponseWriter, status int, data interface{}) error {
 
 // CreatePost godoc
 // @Summary Create a new post
-// @Description Create a new post with title, content, and tags
+// @Description Create a new post with title, content, tags and up to four of your uploaded media. Posts the content filters hold are saved hidden until a moderator reviews them
 // @Tags Posts
 // @Accept json
 // @Produce json
 // @Param post body createPostPayload true "Post data"
 // @Success 201 {object} store.Post
+// @Success 202 {object} store.Post "Held for review"
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 422 {object} map[string]string "Rejected by the content filters"
 // @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
 // @Router /posts [post]
 func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request) {
 	var payload createPostPayload
@@ -55,22 +85,64 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	user := getAuthUserFromContext(r)
+
 	post := &store.Post{
-		Title:   payload.Title,
-		Content: payload.Content,
-		Tags:    payload.Tags,
-		//TODO: get user id from auth
-		UserID: 1,
+		Title:     payload.Title,
+		Content:   payload.Content,
+		Tags:      payload.Tags,
+		UserID:    user.ID,
+		Status:    payload.Status,
+		PublishAt: payload.PublishAt,
+	}
+	if post.Status == "" {
+		post.Status = store.PostPublished
+	}
+
+	if err := checkPublishing(post); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Drafts are screened once they are published
+	if post.Status != store.PostDraft {
+		hold, ok := app.screenContent(w, r, &moderation.Content{
+			Kind:   moderation.KindPost,
+			UserID: user.ID,
+			Text:   post.Title + "\n\n" + post.Content,
+		})
+		if !ok {
+			return
+		}
+		post.Hold = hold
 	}
 
 	ctx := r.Context()
 
-	if err := app.store.Posts.Create(ctx, post); err != nil {
-		app.internalServerError(w, r, err)
+	if err := app.store.Posts.CreateWithMedia(ctx, post, payload.MediaIDs); err != nil {
+		switch err {
+		case store.ErrMediaUnavailable:
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusCreated, post); err != nil {
+	for i := range post.Media {
+		app.setMediaURLs(&post.Media[i])
+	}
+
+	// Unfurling takes a while, the previews show up once it is done
+	post.LinkPreviews = []store.LinkPreview{}
+	app.queuePreviews(post.Content)
+
+	status := http.StatusCreated
+	if post.Hold != nil {
+		status = http.StatusAccepted
+	}
+
+	if err := app.jsonResponse(w, status, post); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
@@ -87,33 +159,64 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 // @Failure 500 {object} map[string]string
 // @Router /posts/{postID} [get]
 func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
-	idParam := chi.URLParam(r, "postID")
-	id, err := strconv.ParseInt(idParam, 10, 64)
+	post, err := getPostFromContext(r)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
 	ctx := r.Context()
+	id := post.ID
 
-	post, err := app.store.Posts.GetByID(ctx, id)
+	// Moderators see what was deleted too
+	getComments := app.store.Comments.GetByPostsID
+	if viewer := getAuthUserFromContext(r); viewer != nil && viewer.IsModerator() {
+		getComments = app.store.Comments.GetByPostsIDWithDeleted
+	}
+
+	comments, err := getComments(ctx, id)
 	if err != nil {
-		switch {
-		case errors.Is(err, store.ErrNotFound):
-			app.notFoundResponse(w, r, err)
-		default:
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// Comments of users who blocked the viewer are hidden from them
+	if viewer := getAuthUserFromContext(r); viewer != nil {
+		blockers, err := app.store.Blocks.GetBlockers(ctx, viewer.ID)
+		if err != nil {
 			app.internalServerError(w, r, err)
+			return
 		}
-		return
+		comments = slices.DeleteFunc(comments, func(c store.Comment) bool {
+			return blockers[c.UserID]
+		})
 	}
 
-	comments, err := app.store.Comments.GetByPostsID(ctx, id)
+	post.Comments = comments
+
+	post.Media, err = app.store.Media.GetByPost(ctx, id)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
+	for i := range post.Media {
+		app.setMediaURLs(&post.Media[i])
+	}
 
-	post.Comments = comments
+	if err := app.attachPreviews(ctx, post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.attachMentions(ctx, post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.attachBookmarked(ctx, getAuthUserFromContext(r), post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
 
 	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
 		app.internalServerError(w, r, err)
@@ -122,14 +225,17 @@ func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
 
 // DeletePost godoc
 // @Summary Delete a post
-// @Description Delete a post by ID
+// @Description Moves a post to the trash, from where it can be restored until it is purged
 // @Tags Posts
 // @Accept json
 // @Produce json
 // @Param postID path int true "Post ID"
 // @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
 // @Router /posts/{postID} [delete]
 func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request) {
 	idParam := chi.URLParam(r, "postID")
@@ -166,8 +272,9 @@ func (app *application) postContextMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Deleted posts are loaded too, the routes decide who gets to see them
 		ctx := r.Context()
-		post, err := app.store.Posts.GetByID(ctx, id)
+		post, err := app.store.Posts.GetByIDWithDeleted(ctx, id)
 		if err != nil {
 			switch {
 			case errors.Is(err, store.ErrNotFound):
@@ -183,6 +290,81 @@ func (app *application) postContextMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// requireVisiblePost hides drafts and scheduled posts from everyone but their
+// author, deleted posts from everyone but moderators, and posts from the users
+// their author blocked, as if they did not exist.
+func (app *application) requireVisiblePost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		post, err := getPostFromContext(r)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		viewer := getAuthUserFromContext(r)
+		if post.Status != store.PostPublished && (viewer == nil || viewer.ID != post.UserID) {
+			app.notFoundResponse(w, r, errors.New("post not found"))
+			return
+		}
+		if post.DeletedAt != nil && (viewer == nil || !viewer.IsModerator()) {
+			app.notFoundResponse(w, r, errors.New("post not found"))
+			return
+		}
+		if viewer != nil && viewer.ID != post.UserID {
+			blockers, err := app.store.Blocks.GetBlockers(r.Context(), viewer.ID)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			if blockers[post.UserID] {
+				app.notFoundResponse(w, r, errors.New("post not found"))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireLivePost rejects posts in the trash, which can't be changed until
+// they are restored.
+func (app *application) requireLivePost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		post, err := getPostFromContext(r)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if post.DeletedAt != nil {
+			app.notFoundResponse(w, r, errors.New("post not found"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requirePostOwner only lets the author of the post in the context through.
+func (app *application) requirePostOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := getAuthUserFromContext(r)
+
+		post, err := getPostFromContext(r)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if post.UserID != user.ID {
+			app.forbiddenResponse(w, r, errors.New("you can only change your own posts"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func getPostFromContext(r *http.Request) (*store.Post, error) {
 	if post, ok := r.Context().Value(postCtxKey{}).(*store.Post); ok {
 		return post, nil
@@ -199,10 +381,15 @@ func getPostFromContext(r *http.Request) (*store.Post, error) {
 // @Param postID path int true "Post ID"
 // @Param post body UpdatePostRequest true "Post update data"
 // @Success 200 {object} store.Post
+// @Success 202 {object} store.Post "Held for review"
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Failure 409 {object} map[string]string "Version conflict"
+// @Failure 422 {object} map[string]string "Rejected by the content filters"
 // @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
 // @Router /posts/{postID} [patch]
 func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the post ID from the URL
@@ -239,6 +426,9 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Only what changed is screened again
+	screen := input.Title != nil || input.Content != nil || post.Status == store.PostDraft
+
 	// Apply updates
 	if input.Title != nil {
 		if *input.Title == "" {
@@ -260,6 +450,40 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		post.Tags = *input.Tags
 	}
 
+	if input.Status != nil {
+		if err := Validate.Var(*input.Status, "oneof=draft scheduled published"); err != nil {
+			app.badRequestResponse(w, r, errors.New("status must be draft, scheduled or published"))
+			return
+		}
+		if post.Status == store.PostPublished && *input.Status != store.PostPublished {
+			app.badRequestResponse(w, r, errors.New("published posts cannot be unpublished"))
+			return
+		}
+		post.Status = *input.Status
+	}
+
+	if input.PublishAt != nil {
+		post.PublishAt = input.PublishAt
+	}
+
+	if err := checkPublishing(post); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if screen && post.Status != store.PostDraft {
+		hold, ok := app.screenContent(w, r, &moderation.Content{
+			Kind:   moderation.KindPost,
+			ID:     post.ID,
+			UserID: post.UserID,
+			Text:   post.Title + "\n\n" + post.Content,
+		})
+		if !ok {
+			return
+		}
+		post.Hold = hold
+	}
+
 	// Attempt to update the post
 	if err := app.store.Posts.Update(ctx, post); err != nil {
 		switch {
@@ -275,14 +499,53 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	// Get the updated post to return
-	updatedPost, err := app.store.Posts.GetByID(ctx, post.ID)
+	// Get the updated post to return, held posts are hidden
+	getPost, status := app.store.Posts.GetByID, http.StatusOK
+	if post.Hold != nil {
+		getPost, status = app.store.Posts.GetByIDWithDeleted, http.StatusAccepted
+	}
+	updatedPost, err := getPost(ctx, post.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	updatedPost.Hold = post.Hold
+
+	// New links are queued for unfurling
+	if err := app.attachPreviews(ctx, updatedPost); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	updatedPost.Mentions = post.Mentions
+
+	if err := app.attachBookmarked(ctx, getAuthUserFromContext(r), updatedPost); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := writeJSON(w, status, updatedPost); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetDrafts godoc
+// @Summary List your drafts
+// @Description Lists your draft and scheduled posts, the next to be published first
+// @Tags Posts
+// @Produce json
+// @Success 200 {array} store.Post
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/drafts [get]
+func (app *application) getDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	drafts, err := app.store.Posts.GetDrafts(r.Context(), getAuthUserFromContext(r).ID)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	if err := writeJSON(w, http.StatusOK, updatedPost); err != nil {
+	if err := app.jsonResponse(w, http.StatusOK, drafts); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
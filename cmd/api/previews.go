@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nati3514/Social/internal/store"
+	"github.com/nati3514/Social/internal/unfurl"
+)
+
+const (
+	// maxPreviewsPerPost is how many URLs of a post get preview cards.
+	maxPreviewsPerPost = 3
+	// failedPreviewTTL is how long to wait before trying a URL that could not
+	// be unfurled again.
+	failedPreviewTTL = time.Hour
+	previewQueueSize = 256
+)
+
+// previewQueue hands URLs to the unfurl workers. Each URL is queued at most
+// once at a time, and URLs are dropped rather than blocking a request when
+// the queue is full; they are queued again the next time they are read.
+type previewQueue struct {
+	unfurler *unfurl.Unfurler
+	jobs     chan string
+	pending  sync.Map
+}
+
+func newPreviewQueue(cfg previewConfig) *previewQueue {
+	return &previewQueue{
+		unfurler: unfurl.New(cfg.unfurl),
+		jobs:     make(chan string, previewQueueSize),
+	}
+}
+
+func (q *previewQueue) add(url string) {
+	if _, queued := q.pending.LoadOrStore(url, struct{}{}); queued {
+		return
+	}
+	select {
+	case q.jobs <- url:
+	default:
+		q.pending.Delete(url)
+	}
+}
+
+// runUnfurler fetches queued URLs until ctx is cancelled.
+func (app *application) runUnfurler(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range app.config.previews.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case url := <-app.previews.jobs:
+					app.unfurl(ctx, url)
+					app.previews.pending.Delete(url)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (app *application) unfurl(ctx context.Context, url string) {
+	preview := &store.LinkPreview{URL: url}
+
+	p, err := app.previews.unfurler.Fetch(ctx, url)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		log.Printf("unfurl: %s: %v\n", url, err)
+		preview.ExpiresAt = time.Now().Add(failedPreviewTTL)
+	} else {
+		preview.OK = true
+		preview.Title = p.Title
+		preview.Description = p.Description
+		preview.ImageURL = p.ImageURL
+		preview.SiteName = p.SiteName
+		preview.ExpiresAt = time.Now().Add(app.config.previews.ttl)
+	}
+
+	if err := app.store.LinkPreviews.Upsert(ctx, preview); err != nil {
+		log.Printf("unfurl: saving preview of %s: %v\n", url, err)
+	}
+}
+
+// queuePreviews has the URLs in content unfurled in the background.
+func (app *application) queuePreviews(content string) {
+	for _, url := range unfurl.ExtractURLs(content, maxPreviewsPerPost) {
+		app.previews.add(url)
+	}
+}
+
+// attachPreviews sets the link previews of posts to those that are ready, and
+// queues the URLs that have none yet or whose preview expired.
+func (app *application) attachPreviews(ctx context.Context, posts ...*store.Post) error {
+	urls := make([][]string, len(posts))
+	var all []string
+	for i, post := range posts {
+		urls[i] = unfurl.ExtractURLs(post.Content, maxPreviewsPerPost)
+		all = append(all, urls[i]...)
+	}
+
+	previews, err := app.store.LinkPreviews.GetFresh(ctx, all)
+	if err != nil {
+		return err
+	}
+
+	for i, post := range posts {
+		post.LinkPreviews = []store.LinkPreview{}
+		for _, url := range urls[i] {
+			p, ok := previews[url]
+			if !ok {
+				app.previews.add(url)
+				continue
+			}
+			if p.OK {
+				post.LinkPreviews = append(post.LinkPreviews, p)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nati3514/Social/internal/store"
+)
+
+type UpdateProfilePayload struct {
+	DisplayName *string `json:"display_name" validate:"omitempty,max=100"`
+	Bio         *string `json:"bio" validate:"omitempty,max=500"`
+	Location    *string `json:"location" validate:"omitempty,max=100"`
+	Website     *string `json:"website" validate:"omitempty,http_url,max=255"`
+	AvatarURL   *string `json:"avatar_url" validate:"omitempty,http_url,max=255"`
+	OpenDMs     *bool   `json:"open_dms"`
+	Version     *int32  `json:"version" validate:"omitempty"`
+}
+
+// GetCurrentUser godoc
+// @Summary Get your own account
+// @Description Returns the authenticated user, including private fields such as the email
+// @Tags Users
+// @Produce json
+// @Success 200 {object} store.User
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me [get]
+func (app *application) getCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.store.Users.GetByID(r.Context(), getAuthUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UpdateProfile godoc
+// @Summary Update your profile
+// @Description Partially update the profile of the authenticated user with optimistic concurrency control. open_dms lets users you don't follow message you
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param payload body UpdateProfilePayload true "Profile fields to change"
+// @Success 200 {object} store.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string "Version conflict"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me [patch]
+func (app *application) updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateProfilePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	// Get a fresh copy, the user in the context only carries the auth fields
+	user, err := app.store.Users.GetByID(ctx, getAuthUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if payload.Version != nil && *payload.Version != user.Version {
+		app.errorResponse(w, http.StatusConflict, "edit conflict: profile has been modified in the meantime")
+		return
+	}
+
+	if payload.DisplayName != nil {
+		user.DisplayName = *payload.DisplayName
+	}
+	if payload.Bio != nil {
+		user.Bio = *payload.Bio
+	}
+	if payload.Location != nil {
+		user.Location = *payload.Location
+	}
+	if payload.Website != nil {
+		user.Website = *payload.Website
+	}
+	if payload.AvatarURL != nil {
+		user.AvatarURL = *payload.AvatarURL
+	}
+	if payload.OpenDMs != nil {
+		user.OpenDMs = *payload.OpenDMs
+	}
+
+	if err := app.store.Users.UpdateProfile(ctx, user); err != nil {
+		switch {
+		case errors.Is(err, store.ErrEditConflict):
+			app.errorResponse(w, http.StatusConflict, "edit conflict: profile has been modified in the meantime")
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
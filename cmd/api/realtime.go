@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nati3514/Social/internal/realtime"
+	"github.com/nati3514/Social/internal/store"
+	"github.com/nati3514/Social/internal/websocket"
+)
+
+const (
+	// pingInterval is how often connections are pinged, and pongWait how
+	// long one may stay silent before it is considered dead.
+	pingInterval = 30 * time.Second
+	pongWait     = 2 * pingInterval
+	// wsWriteWait is how long a write to a connection may take.
+	wsWriteWait = 10 * time.Second
+	// wsReadLimit is the largest message clients may send. They have
+	// nothing to say yet, so it is small.
+	wsReadLimit = 4 << 10
+	// wsTicketExp is how long a ticket to open a WebSocket is valid.
+	wsTicketExp = 30 * time.Second
+)
+
+type WSTicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// runRealtime delivers the events published by every instance to the
+// connections of this one until ctx is cancelled.
+func (app *application) runRealtime(ctx context.Context) {
+	for {
+		err := app.hub.Run(ctx, app.config.db.addr)
+		if err == nil {
+			return
+		}
+		log.Printf("realtime: %v\n", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+// CreateWSTicket godoc
+// @Summary Get a ticket to open a WebSocket
+// @Description Returns a ticket that opens one WebSocket within 30 seconds, for browsers, which can't set headers on WebSockets. Pass it as the ticket query parameter of GET /ws
+// @Tags Notifications
+// @Produce json
+// @Success 201 {object} WSTicketResponse
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /ws/tickets [post]
+func (app *application) createWSTicketHandler(w http.ResponseWriter, r *http.Request) {
+	plainToken := uuid.New().String()
+
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	if err := app.store.Sessions.CreateWSTicket(r.Context(), getAuthUserFromContext(r).ID, hashToken, wsTicketExp); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	res := WSTicketResponse{
+		Ticket:    plainToken,
+		ExpiresAt: time.Now().Add(wsTicketExp),
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, res); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// WebSocket godoc
+// @Summary Receive events in real time
+// @Description Upgrades to a WebSocket that receives events as JSON text messages of the form {"type": ..., "data": ...}: "notification" with a notification as listed by GET /notifications, and "feed.post" when someone you follow publishes a post. Browsers, which can't set headers on WebSockets, pass a ticket from POST /ws/tickets as the ticket query parameter instead. Connections are pinged every 30 seconds and closed if they stop answering, or if they fall too far behind
+// @Tags Notifications
+// @Param ticket query string false "Ticket from POST /ws/tickets, if the token isn't sent in the Authorization header"
+// @Success 101
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /ws [get]
+func (app *application) wsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromContext(r)
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) {
+			app.badRequestResponse(w, r, err)
+		} else {
+			log.Printf("websocket: upgrading: %v\n", err)
+		}
+		return
+	}
+
+	client := app.hub.Register(user.ID)
+	defer app.hub.Unregister(client)
+
+	// Reading answers pings and notices pongs and the client going away;
+	// anything the client sends is ignored
+	conn.SetReadLimit(wsReadLimit)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func() {
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-gone:
+			return
+		case <-client.Done():
+			code := websocket.ClosePolicyViolation
+			if errors.Is(client.Err(), realtime.ErrShuttingDown) {
+				code = websocket.CloseGoingAway
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			_ = conn.Close(code, client.Err().Error())
+			<-gone
+			return
+		case event := <-client.Send():
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteMessage(websocket.TextMessage, event)
+		case <-ticker.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.Ping()
+		}
+		if err != nil {
+			_ = conn.Close(websocket.CloseGoingAway, "")
+			<-gone
+			return
+		}
+	}
+}
+
+// wsAuthMiddleware authenticates WebSocket requests by a ticket passed as the
+// ticket query parameter, for clients that can't set the Authorization
+// header, and any other request as AuthTokenMiddleware does. Tickets are
+// single-use and short-lived, so it doesn't matter that URLs get logged.
+func (app *application) wsAuthMiddleware(next http.Handler) http.Handler {
+	authenticated := app.AuthTokenMiddleware(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ticket := r.URL.Query().Get("ticket")
+		if ticket == "" || r.Header.Get("Authorization") != "" {
+			authenticated.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.store.Sessions.ConsumeWSTicket(r.Context(), ticket)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.unauthorizedErrorResponse(w, r, errors.New("invalid or expired ticket"))
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authUserCtx, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/nati3514/Social/internal/store"
+)
+
+type CreateReportPayload struct {
+	TargetType string `json:"target_type" validate:"required,oneof=post comment user"`
+	TargetID   int64  `json:"target_id" validate:"required,min=1"`
+	Reason     string `json:"reason" validate:"required,oneof=spam harassment hate violence nudity misinformation other"`
+	Details    string `json:"details" validate:"max=1000"`
+}
+
+// CreateReport godoc
+// @Summary Report a post, comment or user
+// @Description Reports content that breaks the rules to the moderators, who notify you once they have handled it. You can report the same post, comment or user once
+// @Tags Reports
+// @Accept json
+// @Produce json
+// @Param payload body CreateReportPayload true "What is reported and why"
+// @Success 201 {object} store.Report
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Already reported"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /reports [post]
+func (app *application) createReportHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateReportPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getAuthUserFromContext(r)
+	report := &store.Report{
+		ReporterID: &user.ID,
+		TargetType: payload.TargetType,
+		TargetID:   payload.TargetID,
+		Reason:     payload.Reason,
+		Details:    payload.Details,
+	}
+
+	if err := app.fillReportTarget(r, report); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New(payload.TargetType+" not found"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if report.TargetUserID == user.ID {
+		app.badRequestResponse(w, r, errors.New("you cannot report yourself"))
+		return
+	}
+
+	if err := app.store.Reports.Create(r.Context(), report); err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, errors.New("you already reported this "+payload.TargetType))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, report); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// fillReportTarget sets who the report is about and a snapshot of what they
+// wrote. Targets the reporter can't see are a store.ErrNotFound.
+func (app *application) fillReportTarget(r *http.Request, report *store.Report) error {
+	ctx := r.Context()
+	viewer := getAuthUserFromContext(r)
+
+	switch report.TargetType {
+	case store.ReportTargetPost:
+		post, err := app.store.Posts.GetByID(ctx, report.TargetID)
+		if err != nil {
+			return err
+		}
+		if post.Status != store.PostPublished && post.UserID != viewer.ID {
+			return store.ErrNotFound
+		}
+		report.TargetUserID = post.UserID
+		report.Snapshot = post.Title + "\n\n" + post.Content
+	case store.ReportTargetComment:
+		comment, err := app.store.Comments.GetByID(ctx, report.TargetID)
+		if err != nil {
+			return err
+		}
+		if comment.DeletedAt != nil {
+			return store.ErrNotFound
+		}
+		report.TargetUserID = comment.UserID
+		report.Snapshot = comment.Content
+	case store.ReportTargetUser:
+		user, err := app.store.Users.GetByID(ctx, report.TargetID)
+		if err != nil {
+			return err
+		}
+		report.TargetUserID = user.ID
+
+		var fields []string
+		for _, f := range []string{user.Username, user.DisplayName, user.Bio, user.Location, user.Website, user.AvatarURL} {
+			if f != "" {
+				fields = append(fields, f)
+			}
+		}
+		report.Snapshot = strings.Join(fields, "\n")
+	}
+	return nil
+}
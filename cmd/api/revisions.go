@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/nati3514/Social/internal/diff"
+	"github.com/nati3514/Social/internal/moderation"
+	"github.com/nati3514/Social/internal/store"
+)
+
+type RestoreRevisionPayload struct {
+	// Version is the current version of the post, as for an update.
+	Version *int32 `json:"version" validate:"required"`
+}
+
+type RevisionDiff struct {
+	From int32  `json:"from"`
+	To   int32  `json:"to"`
+	Diff string `json:"diff"`
+}
+
+// ListRevisions godoc
+// @Summary List the versions of a post
+// @Description Lists every version of a post, the current one first
+// @Tags Posts
+// @Produce json
+// @Param postID path int true "Post ID"
+// @Success 200 {array} store.PostRevision
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /posts/{postID}/revisions [get]
+func (app *application) listRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	post, err := getPostFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	revisions, err := app.store.Revisions.GetByPost(r.Context(), post.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, revisions); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetRevision godoc
+// @Summary Get a version of a post
+// @Description Gets a post as it was at a version
+// @Tags Posts
+// @Produce json
+// @Param postID path int true "Post ID"
+// @Param version path int true "Version"
+// @Success 200 {object} store.PostRevision
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /posts/{postID}/revisions/{version} [get]
+func (app *application) getRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	post, err := getPostFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	version, err := parseVersion(chi.URLParam(r, "version"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rev, ok := app.getRevision(w, r, post.ID, version)
+	if !ok {
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, rev); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DiffRevisions godoc
+// @Summary Compare two versions of a post
+// @Description Returns a unified diff of the title and content of a post between two versions
+// @Tags Posts
+// @Produce json
+// @Param postID path int true "Post ID"
+// @Param from query int true "Old version"
+// @Param to query int false "New version, the current one by default"
+// @Success 200 {object} RevisionDiff
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /posts/{postID}/revisions/diff [get]
+func (app *application) diffRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	post, err := getPostFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+
+	from, err := parseVersion(query.Get("from"))
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("from: %w", err))
+		return
+	}
+
+	to := post.Version
+	if query.Has("to") {
+		if to, err = parseVersion(query.Get("to")); err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("to: %w", err))
+			return
+		}
+	}
+
+	old, ok := app.getRevision(w, r, post.ID, from)
+	if !ok {
+		return
+	}
+	cur, ok := app.getRevision(w, r, post.ID, to)
+	if !ok {
+		return
+	}
+
+	d := RevisionDiff{
+		From: from,
+		To:   to,
+		Diff: diff.Unified(
+			fmt.Sprintf("version %d", from),
+			fmt.Sprintf("version %d", to),
+			revisionText(old),
+			revisionText(cur),
+		),
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, d); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RestoreRevision godoc
+// @Summary Restore a version of a post
+// @Description Makes the title, content and tags of an earlier version current again. The current version must be sent, as for an update, and the restored text is screened by the content filters the same way
+// @Tags Posts
+// @Accept json
+// @Produce json
+// @Param postID path int true "Post ID"
+// @Param version path int true "Version to restore"
+// @Param payload body RestoreRevisionPayload true "Current version of the post"
+// @Success 200 {object} store.Post
+// @Success 202 {object} store.Post "Held for review"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Version conflict"
+// @Failure 422 {object} map[string]string "Rejected by the content filters"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /posts/{postID}/revisions/{version}/restore [post]
+func (app *application) restoreRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	post, err := getPostFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	version, err := parseVersion(chi.URLParam(r, "version"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload RestoreRevisionPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if *payload.Version != post.Version {
+		app.errorResponse(w, http.StatusConflict, "edit conflict: post has been modified by another user")
+		return
+	}
+
+	rev, ok := app.getRevision(w, r, post.ID, version)
+	if !ok {
+		return
+	}
+
+	post.Title = rev.Title
+	post.Content = rev.Content
+	post.Tags = rev.Tags
+
+	// Restoring is an edit, screened like any other
+	if post.Status != store.PostDraft {
+		hold, ok := app.screenContent(w, r, &moderation.Content{
+			Kind:   moderation.KindPost,
+			ID:     post.ID,
+			UserID: post.UserID,
+			Text:   post.Title + "\n\n" + post.Content,
+		})
+		if !ok {
+			return
+		}
+		post.Hold = hold
+	}
+
+	ctx := r.Context()
+
+	if err := app.store.Posts.Update(ctx, post); err != nil {
+		switch {
+		case errors.Is(err, store.ErrEditConflict):
+			app.errorResponse(w, http.StatusConflict, "edit conflict: post has been modified by another user")
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// Held posts are hidden
+	getPost, status := app.store.Posts.GetByID, http.StatusOK
+	if post.Hold != nil {
+		getPost, status = app.store.Posts.GetByIDWithDeleted, http.StatusAccepted
+	}
+	restored, err := getPost(ctx, post.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	restored.Hold = post.Hold
+
+	if err := app.jsonResponse(w, status, restored); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// getRevision loads a version of the post, writing the error response when it
+// can't.
+func (app *application) getRevision(w http.ResponseWriter, r *http.Request, postID int64, version int32) (*store.PostRevision, bool) {
+	rev, err := app.store.Revisions.Get(r.Context(), postID, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, fmt.Errorf("version %d not found", version))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return nil, false
+	}
+	return rev, true
+}
+
+func parseVersion(s string) (int32, error) {
+	version, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || version < 0 {
+		return 0, errors.New("invalid version")
+	}
+	return int32(version), nil
+}
+
+// revisionText is what diffs compare: the title, a blank line and the content.
+func revisionText(rev *store.PostRevision) string {
+	return rev.Title + "\n\n" + rev.Content
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// schedulerBatchSize is how many due posts are published per query.
+const schedulerBatchSize = 100
+
+// runScheduler periodically publishes scheduled posts whose time has come. It
+// runs until ctx is cancelled. Several instances can run side by side since
+// each due post is claimed by exactly one of them.
+func (app *application) runScheduler(ctx context.Context) {
+	ticker := time.NewTicker(app.config.scheduler.interval)
+	defer ticker.Stop()
+
+	for {
+		app.publishDuePosts(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *application) publishDuePosts(ctx context.Context) {
+	for {
+		posts, err := app.store.Posts.PublishDue(ctx, time.Now(), schedulerBatchSize)
+		if err != nil {
+			log.Printf("scheduler: publishing due posts: %v\n", err)
+			return
+		}
+
+		for _, post := range posts {
+			log.Printf("scheduler: published post %d of user %d\n", post.ID, post.UserID)
+		}
+
+		if len(posts) < schedulerBatchSize {
+			return
+		}
+	}
+}
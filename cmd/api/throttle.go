@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nati3514/Social/internal/mailer"
+	"github.com/nati3514/Social/internal/store"
+)
+
+// backoff is how long a client has to wait after its nth consecutive failure.
+// It doubles with every failure up to maxBackoff.
+func (c lockoutConfig) backoff(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+
+	d := c.backoffBase
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if d >= c.maxBackoff {
+			return c.maxBackoff
+		}
+	}
+	return d
+}
+
+// accountThrottleKey is what accounts are throttled by: the email rather than
+// the user ID, so that an email without an account behaves exactly like one
+// with an account.
+func accountThrottleKey(email string) string {
+	return strings.ToLower(email)
+}
+
+// loginWait reports how long the longer of the account and IP throttles still
+// holds off logging in. Zero means it may be tried now.
+func (app *application) loginWait(ctx context.Context, accountKey, ipKey string) (time.Duration, error) {
+	var wait time.Duration
+	for _, scope := range []struct{ name, key string }{
+		{store.ThrottleScopeAccount, accountKey},
+		{store.ThrottleScopeIP, ipKey},
+	} {
+		d, err := app.loginRetryAfter(ctx, scope.name, scope.key)
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, d)
+	}
+	return wait, nil
+}
+
+func (app *application) loginThrottledResponse(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	app.rateLimitExceededResponse(w, r, fmt.Sprintf("%.0f", math.Ceil(wait.Seconds())))
+}
+
+// recordLoginFailures counts a wrong password or second factor against both
+// the account and the IP. user is nil when the email has no account.
+func (app *application) recordLoginFailures(ctx context.Context, r *http.Request, accountKey, ipKey string, user *store.User) error {
+	cfg := app.config.auth.lockout
+
+	if err := app.recordLoginFailure(ctx, r, store.ThrottleScopeAccount, accountKey, cfg.threshold, user); err != nil {
+		return err
+	}
+	return app.recordLoginFailure(ctx, r, store.ThrottleScopeIP, ipKey, cfg.ipThreshold, nil)
+}
+
+// loginRetryAfter reports how long the account or IP must wait before it may
+// try to log in again. Zero means it may try now.
+func (app *application) loginRetryAfter(ctx context.Context, scope, key string) (time.Duration, error) {
+	t, err := app.store.LoginThrottles.Get(ctx, scope, key)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	cfg := app.config.auth.lockout
+	now := time.Now()
+
+	var wait time.Duration
+	if t.LockedUntil != nil && t.LockedUntil.After(now) {
+		wait = t.LockedUntil.Sub(now)
+	}
+
+	if now.Sub(t.LastFailureAt) < cfg.window {
+		if d := t.LastFailureAt.Add(cfg.backoff(t.Failures)).Sub(now); d > wait {
+			wait = d
+		}
+	}
+
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed attempt and locks the account or IP once
+// it reaches its threshold. user is nil when the email has no account.
+func (app *application) recordLoginFailure(ctx context.Context, r *http.Request, scope, key string, threshold int, user *store.User) error {
+	cfg := app.config.auth.lockout
+
+	t, err := app.store.LoginThrottles.RecordFailure(ctx, scope, key, cfg.window)
+	if err != nil {
+		return err
+	}
+
+	if t.Failures < threshold {
+		return nil
+	}
+
+	if err := app.store.LoginThrottles.Lock(ctx, scope, key, time.Now().Add(cfg.duration)); err != nil {
+		return err
+	}
+
+	// Only announce the lockout once, when the threshold is first crossed.
+	if t.Failures != threshold {
+		return nil
+	}
+
+	event := &store.SecurityEvent{
+		Kind: store.SecurityEventIPLocked,
+		IP:   clientIP(r),
+		Details: map[string]any{
+			"failures": t.Failures,
+			"duration": cfg.duration.String(),
+		},
+	}
+	if scope == store.ThrottleScopeAccount {
+		event.Kind = store.SecurityEventAccountLocked
+		if user != nil {
+			event.UserID = &user.ID
+		}
+	}
+
+	if err := app.store.SecurityEvents.Create(ctx, event); err != nil {
+		return err
+	}
+	log.Printf("security: %s key=%q ip=%s failures=%d\n", event.Kind, key, event.IP, t.Failures)
+
+	if scope == store.ThrottleScopeAccount && user != nil {
+		go app.sendUnlockEmail(user)
+	}
+
+	return nil
+}
+
+func (app *application) sendUnlockEmail(user *store.User) {
+	ctx, cancel := context.WithTimeout(context.Background(), store.QueryTimeout)
+	defer cancel()
+
+	plainToken := uuid.New().String()
+
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	if err := app.store.LoginThrottles.CreateUnlockToken(ctx, user.ID, hashToken, app.config.auth.lockout.unlockExp); err != nil {
+		log.Printf("Error creating unlock token for user %d: %v\n", user.ID, err)
+		return
+	}
+
+	data := struct {
+		Username  string
+		UnlockURL string
+		Duration  string
+	}{
+		Username:  user.Username,
+		UnlockURL: fmt.Sprintf("%s/unlock/%s", app.config.frontendURL, plainToken),
+		Duration:  app.config.auth.lockout.duration.String(),
+	}
+
+	if err := app.mailer.Send(mailer.AccountLockedTemplate, user.Username, user.Email, data); err != nil {
+		log.Printf("Error sending unlock email to user %d: %v\n", user.ID, err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nati3514/Social/internal/store"
+)
+
+// accessTokenPrefix marks a bearer token as a personal access token rather
+// than a session token.
+const accessTokenPrefix = "pat_"
+
+// Scopes a personal access token can be granted. Session tokens have all of them.
+const (
+	scopePostsWrite   = "posts:write"
+	scopeFeedRead     = "feed:read"
+	scopeFollowsWrite = "follows:write"
+)
+
+type CreateAccessTokenPayload struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,oneof=posts:write feed:read follows:write"`
+	ExpiresInDays *int     `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+}
+
+type AccessTokenWithSecret struct {
+	*store.AccessToken
+	Token string `json:"token"`
+}
+
+// CreateAccessToken godoc
+// @Summary Create a personal access token
+// @Description Creates a scoped token for bots and integrations. The token is only returned once
+// @Tags Tokens
+// @Accept json
+// @Produce json
+// @Param payload body CreateAccessTokenPayload true "Token name, scopes and lifetime"
+// @Success 201 {object} AccessTokenWithSecret
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/tokens [post]
+func (app *application) createAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromContext(r)
+
+	var payload CreateAccessTokenPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	plainToken := accessTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	token := &store.AccessToken{
+		UserID: user.ID,
+		Name:   payload.Name,
+		Scopes: payload.Scopes,
+	}
+	if payload.ExpiresInDays != nil {
+		exp := time.Now().Add(time.Duration(*payload.ExpiresInDays) * 24 * time.Hour)
+		token.ExpiresAt = &exp
+	}
+
+	if err := app.store.AccessTokens.Create(r.Context(), token, hashToken); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	res := AccessTokenWithSecret{
+		AccessToken: token,
+		Token:       plainToken,
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, res); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListAccessTokens godoc
+// @Summary List personal access tokens
+// @Description Lists the caller's tokens without their secrets
+// @Tags Tokens
+// @Produce json
+// @Success 200 {array} store.AccessToken
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/tokens [get]
+func (app *application) listAccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromContext(r)
+
+	tokens, err := app.store.AccessTokens.GetByUser(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RevokeAccessToken godoc
+// @Summary Revoke a personal access token
+// @Tags Tokens
+// @Produce json
+// @Param tokenID path int true "Token ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/tokens/{tokenID} [delete]
+func (app *application) revokeAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromContext(r)
+
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "tokenID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid token ID"))
+		return
+	}
+
+	if err := app.store.AccessTokens.Revoke(r.Context(), user.ID, tokenID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, ""); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
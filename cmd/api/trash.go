@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nati3514/Social/internal/store"
+)
+
+type Trash struct {
+	Posts    []store.Post    `json:"posts"`
+	Comments []store.Comment `json:"comments"`
+	// RetentionDays is how long deleted items can be restored.
+	RetentionDays int `json:"retention_days"`
+}
+
+// GetTrash godoc
+// @Summary List your trash
+// @Description Lists your deleted posts and comments that can still be restored
+// @Tags Users
+// @Produce json
+// @Success 200 {object} Trash
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/trash [get]
+func (app *application) getTrashHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromContext(r)
+	ctx := r.Context()
+	since := app.trashCutoff()
+
+	posts, err := app.store.Posts.GetDeleted(ctx, user.ID, since)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	comments, err := app.store.Comments.GetDeleted(ctx, user.ID, since)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	trash := Trash{
+		Posts:         posts,
+		Comments:      comments,
+		RetentionDays: int(app.config.trash.retention / (24 * time.Hour)),
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, trash); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RestorePost godoc
+// @Summary Restore a deleted post
+// @Description Takes one of your posts out of the trash, as long as it was deleted within the retention period
+// @Tags Posts
+// @Produce json
+// @Param postID path int true "Post ID"
+// @Success 200 {object} store.Post
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string "Post is not deleted or was taken down by moderation"
+// @Failure 410 {object} map[string]string "Retention period is over"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /posts/{postID}/restore [post]
+func (app *application) restorePostHandler(w http.ResponseWriter, r *http.Request) {
+	post, err := getPostFromContext(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if post.DeletedAt == nil {
+		app.conflictResponse(w, r, errors.New("post is not in the trash"))
+		return
+	}
+	if post.Hidden {
+		app.conflictResponse(w, r, errors.New("post was taken down by moderation and can only be restored by a moderator"))
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := app.store.Posts.Restore(ctx, post.ID, app.trashCutoff()); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.errorResponse(w, http.StatusGone, "post was deleted too long ago to be restored")
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	restored, err := app.store.Posts.GetByID(ctx, post.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, restored); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// trashCutoff is the earliest deletion time that can still be restored.
+func (app *application) trashCutoff() time.Time {
+	return time.Now().Add(-app.config.trash.retention)
+}
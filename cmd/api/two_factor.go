@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nati3514/Social/internal/store"
+	"github.com/nati3514/Social/internal/totp"
+)
+
+const (
+	recoveryCodeCount     = 10
+	maxChallengeAttempts  = 5
+	recoveryCodeSeparator = "-"
+)
+
+var errInvalidCode = errors.New("invalid two-factor code")
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type TwoFactorCodePayload struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type LoginChallengeResponse struct {
+	Challenge string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type CompleteLoginPayload struct {
+	Challenge string `json:"challenge" validate:"required"`
+	Code      string `json:"code" validate:"required,max=32"`
+}
+
+// EnrollTwoFactor godoc
+// @Summary Start two-factor enrollment
+// @Description Generates a new TOTP secret. It is only used once confirmed with a code from the authenticator app
+// @Tags Users
+// @Produce json
+// @Success 201 {object} TwoFactorEnrollment
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string "Already enabled"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/2fa [post]
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromContext(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.SetPendingSecret(r.Context(), user.ID, secret); err != nil {
+		switch {
+		case errors.Is(err, store.ErrTwoFactorEnabled):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	res := TwoFactorEnrollment{
+		Secret: secret,
+		URI:    app.config.auth.totp.URI(user.Email, secret),
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, res); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ConfirmTwoFactor godoc
+// @Summary Confirm two-factor enrollment
+// @Description Enables two-factor authentication with a first code and returns one-time recovery codes. They are only shown once
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param payload body TwoFactorCodePayload true "Code from the authenticator app"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string "Already enabled"
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/2fa/confirm [post]
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromContext(r)
+
+	var payload TwoFactorCodePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	tf, err := app.store.TwoFactor.Get(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if tf.Enabled {
+		app.conflictResponse(w, r, store.ErrTwoFactorEnabled)
+		return
+	}
+	if tf.Secret == "" {
+		app.badRequestResponse(w, r, errors.New("two-factor enrollment has not been started"))
+		return
+	}
+
+	step, ok := app.config.auth.totp.Validate(tf.Secret, payload.Code)
+	if !ok {
+		app.badRequestResponse(w, r, errInvalidCode)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.Enable(ctx, user.ID, step, hashes); err != nil {
+		switch {
+		case errors.Is(err, store.ErrTwoFactorEnabled):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DisableTwoFactor godoc
+// @Summary Disable two-factor authentication
+// @Description Turns off two-factor authentication after checking a TOTP or recovery code
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param payload body TwoFactorCodePayload true "TOTP or recovery code"
+// @Success 204 "No Content"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /users/me/2fa [delete]
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromContext(r)
+
+	var payload TwoFactorCodePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := app.verifySecondFactor(ctx, user.ID, payload.Code); err != nil {
+		switch {
+		case errors.Is(err, errInvalidCode):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.TwoFactor.Disable(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, ""); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CompleteLogin godoc
+// @Summary Complete a two-factor login
+// @Description Exchanges the challenge returned by the password step and a TOTP or recovery code for a session token
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param payload body CompleteLoginPayload true "Challenge and code"
+// @Success 201 {object} TokenResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string "Too many failed attempts"
+// @Failure 500 {object} map[string]string
+// @Router /authentication/token/2fa [post]
+func (app *application) completeLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CompleteLoginPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	userID, err := app.store.TwoFactor.AttemptChallenge(ctx, payload.Challenge, maxChallengeAttempts)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedErrorResponse(w, r, errors.New("invalid or expired challenge"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// Codes count against the same throttles as passwords, a challenge only
+	// caps the attempts made with it
+	accountKey := accountThrottleKey(user.Email)
+	ipKey := clientIP(r)
+
+	wait, err := app.loginWait(ctx, accountKey, ipKey)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.loginThrottledResponse(w, r, wait)
+		return
+	}
+
+	if err := app.verifySecondFactor(ctx, userID, payload.Code); err != nil {
+		switch {
+		case errors.Is(err, errInvalidCode):
+			if err := app.recordLoginFailures(ctx, r, accountKey, ipKey, user); err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.LoginThrottles.Reset(ctx, store.ThrottleScopeAccount, accountKey); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.DeleteChallenge(ctx, payload.Challenge); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	res, err := app.createSession(ctx, userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, res); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// respondWithLoginChallenge answers a login with a challenge for the second
+// factor, unless the account or IP is locked out from failed attempts.
+func (app *application) respondWithLoginChallenge(w http.ResponseWriter, r *http.Request, user *store.User) {
+	ctx := r.Context()
+
+	wait, err := app.loginWait(ctx, accountThrottleKey(user.Email), clientIP(r))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.loginThrottledResponse(w, r, wait)
+		return
+	}
+
+	challenge, err := app.createLoginChallenge(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, challenge); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) createLoginChallenge(ctx context.Context, userID int64) (*LoginChallengeResponse, error) {
+	plainToken := uuid.New().String()
+
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	exp := app.config.auth.challengeExp
+	if err := app.store.TwoFactor.CreateChallenge(ctx, userID, hashToken, exp); err != nil {
+		return nil, err
+	}
+
+	return &LoginChallengeResponse{
+		Challenge: plainToken,
+		ExpiresAt: time.Now().Add(exp),
+	}, nil
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code.
+// Every code can only be used once.
+func (app *application) verifySecondFactor(ctx context.Context, userID int64, code string) error {
+	tf, err := app.store.TwoFactor.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !tf.Enabled {
+		return errInvalidCode
+	}
+
+	if step, ok := app.config.auth.totp.ValidateAfter(tf.Secret, code, tf.LastStep); ok {
+		if err := app.store.TwoFactor.UseStep(ctx, userID, step); err != nil {
+			if errors.Is(err, store.ErrCodeReused) {
+				return errInvalidCode
+			}
+			return err
+		}
+		return nil
+	}
+
+	if err := app.store.TwoFactor.UseRecoveryCode(ctx, userID, hashRecoveryCode(code)); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return errInvalidCode
+		}
+		return err
+	}
+	return nil
+}
+
+// generateRecoveryCodes returns n codes formatted as "xxxxx-xxxxx" along with
+// their hashes for storage.
+func generateRecoveryCodes(n int) ([]string, []string, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, n)
+	hashes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(enc.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + recoveryCodeSeparator + raw[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.TrimSpace(code))
+	normalized = strings.ReplaceAll(normalized, recoveryCodeSeparator, "")
+
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
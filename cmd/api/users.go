@@ -2,10 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/nati3514/Social/internal/mailer"
 	"github.com/nati3514/Social/internal/store"
 )
 
@@ -41,58 +48,138 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+type ResendActivationPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// ResendActivation godoc
+// @Summary Resend the activation email
+// @Description Replaces any outstanding invitation for an unactivated account and emails a new one. Always responds 202 so it cannot be used to discover accounts
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param payload body ResendActivationPayload true "Account email"
+// @Success 202 "Accepted"
+// @Failure 400 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /users/activate/resend [post]
+func (app *application) resendActivationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResendActivationPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	go app.resendActivation(payload.Email)
+
+	if err := app.jsonResponse(w, http.StatusAccepted, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) resendActivation(email string) {
+	// Also limit per address so a single inbox cannot be flooded from many IPs.
+	if app.config.rateLimiter.Enabled {
+		if allow, _ := app.rateLimiter.Allow("resend-activation:" + email); !allow {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), store.QueryTimeout*2)
+	defer cancel()
+
+	user, err := app.store.Users.GetByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("Error looking up user for activation resend: %v\n", err)
+		}
+		return
+	}
+	if user.IsActive {
+		return
+	}
+
+	plainToken := uuid.New().String()
+
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	if err := app.store.Users.ReplaceInvitation(ctx, user.ID, hashToken, app.config.mail.exp); err != nil {
+		log.Printf("Error replacing invitation for user %d: %v\n", user.ID, err)
+		return
+	}
+
+	data := struct {
+		Username      string
+		ActivationURL string
+		Expiry        string
+	}{
+		Username:      user.Username,
+		ActivationURL: fmt.Sprintf("%s/confirm/%s", app.config.frontendURL, plainToken),
+		Expiry:        app.config.mail.exp.String(),
+	}
+
+	if err := app.mailer.Send(mailer.UserInvitationTemplate, user.Username, user.Email, data); err != nil {
+		log.Printf("Error sending activation email to user %d: %v\n", user.ID, err)
+	}
+}
+
 // GetUser godoc
 // @Summary Get a user profile
-// @Description Fetches a user profile by ID
+// @Description Fetches the public profile of a user by ID
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Param userID path int true "User ID"
-// @Success 200 {object} store.User
+// @Success 200 {object} store.PublicProfile
 // @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /users/{userID} [get]
 func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromContext(r)
 
-	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+	if err := app.jsonResponse(w, http.StatusOK, user.PublicProfile()); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
 
-type FollowUser struct {
-	UserID int64 `json:"user_id"`
-}
-
 // FollowUser godoc
 // @Summary Follow a user
-// @Description Follow a user by providing the follower's user ID
+// @Description The authenticated user starts following the user in the path
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Param userID path int true "User ID to follow"
-// @Param payload body FollowUser true "Follower user ID"
 // @Success 204 "No Content"
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 409 {object} map[string]string "Already following"
 // @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
 // @Router /users/{userID}/follow [put]
 func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request) {
-	followerUser := getUserFromContext(r)
+	followedUser := getUserFromContext(r)
+	follower := getAuthUserFromContext(r)
 
-	//TODO: Revert back to auth userID from ctx
-	var payload FollowUser
-	if err := readJSON(w, r, &payload); err != nil {
-		app.badRequestResponse(w, r, err)
+	if followedUser.ID == follower.ID {
+		app.badRequestResponse(w, r, errors.New("you cannot follow yourself"))
 		return
 	}
 
 	ctx := r.Context()
 
-	if err := app.store.Followers.Follow(ctx, followerUser.ID, payload.UserID); err != nil {
+	if err := app.store.Followers.Follow(ctx, follower.ID, followedUser.ID); err != nil {
 		switch err {
 		case store.ErrConflict:
 			app.conflictResponse(w, r, err)
+		case store.ErrBlocked:
+			app.forbiddenResponse(w, r, err)
 		default:
 			app.internalServerError(w, r, err)
 		}
@@ -106,29 +193,25 @@ func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request
 
 // UnfollowUser godoc
 // @Summary Unfollow a user
-// @Description Unfollow a user by providing the follower's user ID
+// @Description The authenticated user stops following the user in the path
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Param userID path int true "User ID to unfollow"
-// @Param payload body FollowUser true "Follower user ID"
 // @Success 204 "No Content"
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
 // @Router /users/{userID}/unfollow [put]
 func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Request) {
 	unfollowedUser := getUserFromContext(r)
-
-	//TODO: Revert back to auth userID from ctx
-	var payload FollowUser
-	if err := readJSON(w, r, &payload); err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
+	follower := getAuthUserFromContext(r)
 
 	ctx := r.Context()
 
-	if err := app.store.Followers.Unfollow(ctx, unfollowedUser.ID, payload.UserID); err != nil {
+	if err := app.store.Followers.Unfollow(ctx, follower.ID, unfollowedUser.ID); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
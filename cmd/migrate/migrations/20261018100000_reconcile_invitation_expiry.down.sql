@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_user_invitations_expiry;
+DROP INDEX IF EXISTS idx_user_invitations_user_id;
+
+ALTER TABLE user_invitations DROP CONSTRAINT IF EXISTS fk_user_invitations_user_id;
+
+ALTER TABLE user_invitations
+    ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ NOT NULL DEFAULT (NOW() + INTERVAL '7 days');
+
+UPDATE user_invitations SET expires_at = expiry;
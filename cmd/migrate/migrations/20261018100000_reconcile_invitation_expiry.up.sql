@@ -0,0 +1,15 @@
+-- expiry is the column the application reads and writes; expires_at only ever
+-- held its default, so it is dropped rather than kept in sync.
+ALTER TABLE user_invitations DROP COLUMN IF EXISTS expires_at;
+
+-- Invitations must not outlive their user.
+DELETE FROM user_invitations ui
+WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = ui.user_id);
+
+ALTER TABLE user_invitations
+    ADD CONSTRAINT fk_user_invitations_user_id FOREIGN KEY (user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_user_invitations_user_id ON user_invitations (user_id);
+CREATE INDEX IF NOT EXISTS idx_user_invitations_expiry ON user_invitations (expiry);
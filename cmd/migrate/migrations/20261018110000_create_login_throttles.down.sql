@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_security_events_user_id;
+DROP TABLE IF EXISTS security_events;
+
+DROP INDEX IF EXISTS idx_account_unlocks_user_id;
+DROP TABLE IF EXISTS account_unlocks;
+
+DROP TABLE IF EXISTS login_throttles;
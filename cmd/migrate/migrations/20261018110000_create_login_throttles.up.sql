@@ -0,0 +1,31 @@
+-- One row per account (lower-cased email) or client IP that has failed to log in.
+CREATE TABLE IF NOT EXISTS login_throttles (
+    scope VARCHAR(16) NOT NULL,
+    key TEXT NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP(0) WITH TIME ZONE,
+    PRIMARY KEY (scope, key),
+    CONSTRAINT login_throttles_scope_check CHECK (scope IN ('account', 'ip'))
+);
+
+CREATE TABLE IF NOT EXISTS account_unlocks (
+    token bytea PRIMARY KEY,
+    user_id bigint NOT NULL,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_unlocks_user_id ON account_unlocks (user_id);
+
+CREATE TABLE IF NOT EXISTS security_events (
+    id bigserial PRIMARY KEY,
+    user_id bigint,
+    kind VARCHAR(50) NOT NULL,
+    ip TEXT NOT NULL DEFAULT '',
+    details jsonb NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_user_id ON security_events (user_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS oidc_login_states;
+
+DROP INDEX IF EXISTS idx_user_identities_user_id;
+DROP TABLE IF EXISTS user_identities;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    subject TEXT NOT NULL,
+    email citext,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT user_identities_provider_subject_key UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+
+-- Pending authorization requests, consumed by the callback.
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state bytea PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1,7 @@
+ALTER TABLE users DROP COLUMN IF EXISTS username_changed_at;
+
+DROP INDEX IF EXISTS idx_previous_usernames_user_id;
+
+DROP TABLE IF EXISTS previous_usernames;
+
+DROP TABLE IF EXISTS email_changes;
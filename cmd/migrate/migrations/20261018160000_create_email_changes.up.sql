@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS email_changes (
+    token bytea PRIMARY KEY,
+    user_id bigint NOT NULL UNIQUE,
+    new_email citext NOT NULL,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS previous_usernames (
+    username citext PRIMARY KEY,
+    user_id bigint NOT NULL,
+    changed_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_previous_usernames_user_id ON previous_usernames (user_id);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username_changed_at TIMESTAMP(0) WITH TIME ZONE;
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_data_exports_user_id;
+
+DROP TABLE IF EXISTS data_exports;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_at;
+
+DROP INDEX IF EXISTS idx_comments_user_id;
+
+ALTER TABLE comments
+    DROP CONSTRAINT IF EXISTS fk_comments_post_id,
+    DROP CONSTRAINT IF EXISTS fk_comments_user_id;
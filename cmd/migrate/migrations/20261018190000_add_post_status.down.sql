@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_posts_scheduled_publish_at;
+
+ALTER TABLE posts
+    DROP CONSTRAINT IF EXISTS posts_scheduled_publish_at_check,
+    DROP CONSTRAINT IF EXISTS posts_status_check,
+    DROP COLUMN IF EXISTS published_at,
+    DROP COLUMN IF EXISTS publish_at,
+    DROP COLUMN IF EXISTS status;
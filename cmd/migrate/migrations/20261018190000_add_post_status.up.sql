@@ -0,0 +1,13 @@
+ALTER TABLE posts
+    ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'published',
+    ADD COLUMN IF NOT EXISTS publish_at TIMESTAMP(0) WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS published_at TIMESTAMP(0) WITH TIME ZONE;
+
+UPDATE posts SET published_at = created_at WHERE published_at IS NULL AND status = 'published';
+
+ALTER TABLE posts
+    ADD CONSTRAINT posts_status_check CHECK (status IN ('draft', 'scheduled', 'published')),
+    ADD CONSTRAINT posts_scheduled_publish_at_check CHECK (status <> 'scheduled' OR publish_at IS NOT NULL);
+
+-- the scheduler only ever looks at scheduled posts
+CREATE INDEX IF NOT EXISTS idx_posts_scheduled_publish_at ON posts (publish_at) WHERE status = 'scheduled';
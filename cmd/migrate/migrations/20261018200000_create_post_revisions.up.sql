@@ -0,0 +1,12 @@
+-- A revision is a post as it was at a version, saved when the post is changed.
+-- The current version lives in posts only.
+CREATE TABLE IF NOT EXISTS post_revisions (
+    post_id bigint NOT NULL,
+    version INT NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    content text NOT NULL,
+    tags VARCHAR(100)[],
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (post_id, version),
+    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_link_previews_expires_at;
+
+DROP TABLE IF EXISTS link_previews;
@@ -0,0 +1,14 @@
+-- Failed fetches are kept too, with ok false, so they are not retried on every
+-- read until they expire.
+CREATE TABLE IF NOT EXISTS link_previews (
+    url text PRIMARY KEY,
+    ok boolean NOT NULL,
+    title VARCHAR(300) NOT NULL DEFAULT '',
+    description VARCHAR(1000) NOT NULL DEFAULT '',
+    image_url VARCHAR(2048) NOT NULL DEFAULT '',
+    site_name VARCHAR(300) NOT NULL DEFAULT '',
+    fetched_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP(0) WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_link_previews_expires_at ON link_previews (expires_at);
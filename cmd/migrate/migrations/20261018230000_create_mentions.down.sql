@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_mentions_comment_id;
+DROP INDEX IF EXISTS idx_mentions_post_id;
+DROP INDEX IF EXISTS idx_mentions_user_id;
+
+DROP TABLE IF EXISTS mentions;
@@ -0,0 +1,19 @@
+-- One row per @username in a post or comment that resolved to a user.
+-- Offsets are in characters and cover the @ and the username.
+CREATE TABLE IF NOT EXISTS mentions (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    post_id bigint,
+    comment_id bigint,
+    start_offset INT NOT NULL,
+    end_offset INT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
+    CONSTRAINT mentions_target_check CHECK ((post_id IS NULL) <> (comment_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_mentions_user_id ON mentions (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_mentions_post_id ON mentions (post_id) WHERE post_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_mentions_comment_id ON mentions (comment_id) WHERE comment_id IS NOT NULL;
@@ -0,0 +1,6 @@
+ALTER TABLE mentions DROP COLUMN IF EXISTS notified;
+
+DROP INDEX IF EXISTS idx_notifications_user_id;
+DROP INDEX IF EXISTS idx_notifications_unread_group;
+
+DROP TABLE IF EXISTS notifications;
@@ -0,0 +1,27 @@
+-- One row per group of events, like everyone who followed a user since they
+-- last read their notifications. Unread rows are grouped by group_key, the
+-- most recent actor first in actor_ids.
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    group_key VARCHAR(100) NOT NULL,
+    post_id bigint,
+    comment_id bigint,
+    actor_ids bigint[] NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    read_at TIMESTAMP(0) WITH TIME ZONE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE SET NULL,
+    CONSTRAINT notifications_type_check CHECK (type IN ('follow', 'comment', 'mention'))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_unread_group ON notifications (user_id, group_key) WHERE read_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications (user_id, id DESC);
+
+-- Mentions are notified once, when the post or comment is first visible.
+-- Existing ones were made before there were notifications.
+ALTER TABLE mentions ADD COLUMN IF NOT EXISTS notified BOOLEAN NOT NULL DEFAULT false;
+UPDATE mentions SET notified = true;
@@ -0,0 +1,40 @@
+-- Users who don't follow someone can only message them if they opted in.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS open_dms BOOLEAN NOT NULL DEFAULT false;
+
+-- A one-to-one conversation has a direct_key made of its two user IDs, so
+-- there is only ever one between the same users. Groups have none.
+CREATE TABLE IF NOT EXISTS conversations (
+    id bigserial PRIMARY KEY,
+    title VARCHAR(100),
+    direct_key VARCHAR(50) UNIQUE,
+    created_by bigint,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_message_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- last_read_message_id is the newest message the member has read, and what
+-- the other members see as their read receipt.
+CREATE TABLE IF NOT EXISTS conversation_members (
+    conversation_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    joined_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_read_message_id bigint NOT NULL DEFAULT 0,
+    last_read_at TIMESTAMP(0) WITH TIME ZONE,
+    PRIMARY KEY (conversation_id, user_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id bigserial PRIMARY KEY,
+    conversation_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_members_user_id ON conversation_members (user_id);
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages (conversation_id, id DESC);
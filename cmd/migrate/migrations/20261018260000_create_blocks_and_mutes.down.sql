@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS mutes;
+
+DROP INDEX IF EXISTS idx_blocks_blocked_id;
+DROP TABLE IF EXISTS blocks;
@@ -0,0 +1,19 @@
+DELETE FROM notifications WHERE type IN ('report', 'warning');
+
+ALTER TABLE notifications
+    DROP CONSTRAINT IF EXISTS notifications_type_check,
+    ADD CONSTRAINT notifications_type_check CHECK (type IN ('follow', 'comment', 'mention')),
+    DROP COLUMN IF EXISTS report_id;
+
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_at;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS hidden;
+ALTER TABLE posts DROP COLUMN IF EXISTS hidden;
+
+DROP INDEX IF EXISTS idx_moderation_actions_report_id;
+DROP TABLE IF EXISTS moderation_actions;
+
+DROP INDEX IF EXISTS idx_reports_status;
+DROP INDEX IF EXISTS idx_reports_target;
+DROP INDEX IF EXISTS idx_reports_reporter_target;
+DROP TABLE IF EXISTS reports;
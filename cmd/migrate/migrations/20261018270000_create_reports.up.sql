@@ -0,0 +1,65 @@
+-- Reports of posts, comments and profiles. target_user_id is whoever the
+-- report is about, and snapshot what they had written when it was made, so
+-- moderators see it even after it was edited or deleted.
+CREATE TABLE IF NOT EXISTS reports (
+    id bigserial PRIMARY KEY,
+    reporter_id bigint,
+    target_type VARCHAR(10) NOT NULL,
+    target_id bigint NOT NULL,
+    target_user_id bigint NOT NULL,
+    reason VARCHAR(20) NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    snapshot TEXT NOT NULL DEFAULT '',
+    status VARCHAR(10) NOT NULL DEFAULT 'open',
+    claimed_by bigint,
+    claimed_at TIMESTAMP(0) WITH TIME ZONE,
+    resolved_by bigint,
+    resolved_at TIMESTAMP(0) WITH TIME ZONE,
+    action VARCHAR(10),
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (reporter_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (target_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (claimed_by) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (resolved_by) REFERENCES users(id) ON DELETE SET NULL,
+    CONSTRAINT reports_target_type_check CHECK (target_type IN ('post', 'comment', 'user')),
+    CONSTRAINT reports_reason_check CHECK (reason IN ('spam', 'harassment', 'hate', 'violence', 'nudity', 'misinformation', 'other')),
+    CONSTRAINT reports_status_check CHECK (status IN ('open', 'claimed', 'resolved')),
+    CONSTRAINT reports_action_check CHECK (action IN ('dismiss', 'hide', 'warn', 'suspend'))
+);
+
+-- Each user reports the same thing once
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_reporter_target ON reports (reporter_id, target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_reports_target ON reports (target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status, id);
+
+-- Every claim and resolution by a moderator. Rows outlive the reports and
+-- users they refer to.
+CREATE TABLE IF NOT EXISTS moderation_actions (
+    id bigserial PRIMARY KEY,
+    moderator_id bigint,
+    report_id bigint,
+    action VARCHAR(10) NOT NULL,
+    target_type VARCHAR(10) NOT NULL,
+    target_id bigint NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (moderator_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (report_id) REFERENCES reports(id) ON DELETE SET NULL,
+    CONSTRAINT moderation_actions_action_check CHECK (action IN ('claim', 'dismiss', 'hide', 'warn', 'suspend'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_report_id ON moderation_actions (report_id);
+
+-- Hidden posts and comments are deleted by a moderator, and their authors
+-- can't restore them.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS hidden BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS hidden BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP(0) WITH TIME ZONE;
+
+-- Reporters hear how their report was handled, and warned users why
+ALTER TABLE notifications
+    ADD COLUMN IF NOT EXISTS report_id bigint REFERENCES reports(id) ON DELETE CASCADE,
+    DROP CONSTRAINT IF EXISTS notifications_type_check,
+    ADD CONSTRAINT notifications_type_check CHECK (type IN ('follow', 'comment', 'mention', 'report', 'warning'));
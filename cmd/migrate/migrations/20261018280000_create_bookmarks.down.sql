@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_bookmarks_collection_id;
+DROP INDEX IF EXISTS idx_bookmarks_user_id;
+DROP INDEX IF EXISTS idx_bookmarks_user_post;
+DROP TABLE IF EXISTS bookmarks;
+
+DROP INDEX IF EXISTS idx_bookmark_collections_user_name;
+DROP TABLE IF EXISTS bookmark_collections;
@@ -0,0 +1,8 @@
+-- Short-lived, single-use tickets that open a WebSocket, so session tokens
+-- never go in URLs.
+CREATE TABLE IF NOT EXISTS ws_tickets (
+    token bytea PRIMARY KEY,
+    user_id bigint NOT NULL,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
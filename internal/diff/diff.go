@@ -0,0 +1,155 @@
+// Package diff renders line-based differences between two texts in the
+// unified format read by diff(1) and patch(1).
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Context is how many unchanged lines surround each change.
+const Context = 3
+
+type kind byte
+
+const (
+	same    kind = ' '
+	removed kind = '-'
+	added   kind = '+'
+)
+
+type edit struct {
+	kind kind
+	line string
+}
+
+// Unified returns the unified diff turning a into b, labelled with the names
+// of the two sides. It is empty when the texts are the same.
+func Unified(fromName, toName, a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	edits := lineEdits(splitLines(a), splitLines(b))
+
+	// line numbers in a and b before each edit
+	aPos := make([]int, len(edits)+1)
+	bPos := make([]int, len(edits)+1)
+	for i, e := range edits {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if e.kind != added {
+			aPos[i+1]++
+		}
+		if e.kind != removed {
+			bPos[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(edits); {
+		for i < len(edits) && edits[i].kind == same {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		// Grow the hunk over changes that are close enough for their
+		// context to overlap
+		start, end := max(i-Context, 0), i
+		for {
+			for end < len(edits) && edits[end].kind != same {
+				end++
+			}
+			next := end
+			for next < len(edits) && edits[next].kind == same {
+				next++
+			}
+			if next == len(edits) || next-end > 2*Context {
+				end = min(end+Context, len(edits))
+				break
+			}
+			end = next
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(aPos[start], aPos[end]-aPos[start]),
+			hunkRange(bPos[start], bPos[end]-bPos[start]),
+		)
+		for _, e := range edits[start:end] {
+			out.WriteByte(byte(e.kind))
+			out.WriteString(e.line)
+			out.WriteByte('\n')
+		}
+		i = end
+	}
+
+	return out.String()
+}
+
+// hunkRange formats the lines of one side of a hunk that start after the
+// first before lines, the way GNU diff does.
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprintf("%d", before+1)
+	default:
+		return fmt.Sprintf("%d,%d", before+1, count)
+	}
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lineEdits finds the shortest edit script from a to b through their longest
+// common subsequence. It takes quadratic time and space, which is fine for
+// texts the size of a post.
+func lineEdits(a, b []string) []edit {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	edits := make([]edit, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, edit{same, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, edit{removed, a[i]})
+			i++
+		default:
+			edits = append(edits, edit{added, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, edit{removed, a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, edit{added, b[j]})
+	}
+	return edits
+}
@@ -0,0 +1,142 @@
+package diff
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lines returns "line 1" to "line n", one per line, with changed lines
+// replaced by "changed N"
+func lines(n int, changed ...int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		line := fmt.Sprintf("line %d", i)
+		for _, c := range changed {
+			if c == i {
+				line = fmt.Sprintf("changed %d", i)
+			}
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "same",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			want: "",
+		},
+		{
+			name: "pure insert",
+			a:    "a\nb\nc\n",
+			b:    "a\nb\nx\nc\n",
+			want: "--- a\n+++ b\n@@ -1,3 +1,4 @@\n a\n b\n+x\n c\n",
+		},
+		{
+			name: "pure delete",
+			a:    lines(10),
+			b:    strings.Replace(lines(10), "line 5\n", "", 1),
+			want: "--- a\n+++ b\n@@ -2,7 +2,6 @@\n line 2\n line 3\n line 4\n-line 5\n line 6\n line 7\n line 8\n",
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "x\ny\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n",
+		},
+		{
+			name: "to empty",
+			a:    "x\ny\n",
+			b:    "",
+			want: "--- a\n+++ b\n@@ -1,2 +0,0 @@\n-x\n-y\n",
+		},
+		{
+			name: "single line",
+			a:    "x\n",
+			b:    "y\n",
+			want: "--- a\n+++ b\n@@ -1 +1 @@\n-x\n+y\n",
+		},
+		{
+			name: "changes whose context overlaps share a hunk",
+			a:    lines(20),
+			b:    lines(20, 3, 10),
+			want: "--- a\n+++ b\n@@ -1,13 +1,13 @@\n" +
+				" line 1\n line 2\n-line 3\n+changed 3\n" +
+				" line 4\n line 5\n line 6\n line 7\n line 8\n line 9\n" +
+				"-line 10\n+changed 10\n line 11\n line 12\n line 13\n",
+		},
+		{
+			name: "changes further apart get their own hunks",
+			a:    lines(20),
+			b:    lines(20, 3, 11),
+			want: "--- a\n+++ b\n" +
+				"@@ -1,6 +1,6 @@\n line 1\n line 2\n-line 3\n+changed 3\n line 4\n line 5\n line 6\n" +
+				"@@ -8,7 +8,7 @@\n line 8\n line 9\n line 10\n-line 11\n+changed 11\n line 12\n line 13\n line 14\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unified("a", "b", tt.a, tt.b); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedPatch(t *testing.T) {
+	if _, err := exec.LookPath("patch"); err != nil {
+		t.Skip("patch is not installed")
+	}
+
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"pure insert", "a\nb\nc\n", "a\nb\nx\nc\n"},
+		{"pure delete", lines(10), strings.Replace(lines(10), "line 5\n", "", 1)},
+		{"from empty", "", "x\ny\n"},
+		{"to empty", "x\ny\n", ""},
+		{"overlapping context", lines(20), lines(20, 3, 10)},
+		{"separate hunks", lines(20), lines(20, 3, 11)},
+		{"first and last lines", lines(30), lines(30, 1, 30)},
+		{"everything changed", lines(5), "a\nb\n"},
+		{"insert at the start", lines(8), "new\n" + lines(8)},
+		{"append at the end", lines(8), lines(8) + "new\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			orig := filepath.Join(dir, "a")
+			out := filepath.Join(dir, "b")
+			if err := os.WriteFile(orig, []byte(tt.a), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			// No fuzz: the hunks have to apply exactly where they say
+			cmd := exec.Command("patch", "--silent", "--fuzz=0", "--output="+out, orig)
+			cmd.Stdin = strings.NewReader(Unified("a", "b", tt.a, tt.b))
+			if output, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("patch: %v\n%s", err, output)
+			}
+
+			got, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.b {
+				t.Errorf("patched to\n%s\nwant\n%s", got, tt.b)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package mailer
+
+import "log"
+
+// LogMailer renders emails to the application log instead of delivering
+// them. It is used in development when no SMTP host is configured.
+type LogMailer struct{}
+
+func (LogMailer) Send(templateFile, username, email string, data any) error {
+	subject, body, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Email to %s <%s>\nSubject: %s\n%s\n", username, email, subject, body)
+	return nil
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"text/template"
+)
+
+const (
+	FromName               = "Social"
+	maxRetries             = 3
+	PasswordResetTemplate  = "password_reset.tmpl"
+	UserInvitationTemplate = "user_invitation.tmpl"
+	AccountLockedTemplate  = "account_locked.tmpl"
+	EmailChangeTemplate    = "email_change.tmpl"
+	EmailNoticeTemplate    = "email_change_notice.tmpl"
+	DataExportTemplate     = "data_export.tmpl"
+)
+
+//go:embed "templates"
+var FS embed.FS
+
+type Client interface {
+	Send(templateFile, username, email string, data any) error
+}
+
+// render executes the "subject" and "body" blocks of an embedded template.
+func render(templateFile string, data any) (string, string, error) {
+	tmpl, err := template.ParseFS(FS, "templates/"+templateFile)
+	if err != nil {
+		return "", "", err
+	}
+
+	subject := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(subject, "subject", data); err != nil {
+		return "", "", err
+	}
+
+	body := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(body, "body", data); err != nil {
+		return "", "", err
+	}
+
+	return subject.String(), body.String(), nil
+}
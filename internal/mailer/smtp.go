@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type SMTPMailer struct {
+	fromEmail string
+	addr      string
+	auth      smtp.Auth
+}
+
+func NewSMTP(host string, port int, username, password, fromEmail string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		fromEmail: fromEmail,
+		addr:      fmt.Sprintf("%s:%d", host, port),
+		auth:      auth,
+	}
+}
+
+func (m *SMTPMailer) Send(templateFile, username, email string, data any) error {
+	subject, body, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s <%s>\r\n", FromName, m.fromEmail)
+	fmt.Fprintf(&msg, "To: %s <%s>\r\n", username, email)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.TrimSpace(subject))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=\"UTF-8\"\r\n\r\n")
+	msg.WriteString(body)
+
+	for i := 0; i < maxRetries; i++ {
+		err = smtp.SendMail(m.addr, m.auth, m.fromEmail, []string{email}, []byte(msg.String()))
+		if err == nil {
+			return nil
+		}
+
+		log.Printf("Failed to send email to %s, attempt %d of %d: %v\n", email, i+1, maxRetries, err)
+		time.Sleep(time.Second * time.Duration(i+1))
+	}
+
+	return fmt.Errorf("failed to send email after %d attempts: %w", maxRetries, err)
+}
@@ -0,0 +1,35 @@
+// Package markdown turns the Markdown source of posts into HTML that is safe
+// to embed in a page.
+package markdown
+
+import (
+	"github.com/russross/blackfriday/v2"
+)
+
+const extensions = blackfriday.NoIntraEmphasis |
+	blackfriday.Tables |
+	blackfriday.FencedCode |
+	blackfriday.Autolink |
+	blackfriday.Strikethrough |
+	blackfriday.SpaceHeadings |
+	blackfriday.BackslashLineBreak
+
+const htmlFlags = blackfriday.UseXHTML |
+	blackfriday.Safelink |
+	blackfriday.NofollowLinks |
+	blackfriday.NoreferrerLinks
+
+// Render converts Markdown to HTML. Raw HTML in the source is kept only as far
+// as Sanitize allows it.
+func Render(source string) string {
+	renderer := blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+		Flags: htmlFlags,
+	})
+
+	out := blackfriday.Run(
+		[]byte(source),
+		blackfriday.WithExtensions(extensions),
+		blackfriday.WithRenderer(renderer),
+	)
+	return Sanitize(string(out))
+}
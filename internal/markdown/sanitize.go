@@ -0,0 +1,203 @@
+package markdown
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// allowedTags maps the elements that are kept to the attributes they may
+// keep. Everything else is dropped, though the text inside stays.
+var allowedTags = map[string]map[string]bool{
+	"a":          {"href": true, "title": true},
+	"b":          {},
+	"blockquote": {},
+	"br":         {},
+	"code":       {"class": true},
+	"dd":         {},
+	"del":        {},
+	"dl":         {},
+	"dt":         {},
+	"em":         {},
+	"h1":         {},
+	"h2":         {},
+	"h3":         {},
+	"h4":         {},
+	"h5":         {},
+	"h6":         {},
+	"hr":         {},
+	"i":          {},
+	"img":        {"src": true, "alt": true, "title": true},
+	"li":         {},
+	"ol":         {"start": true},
+	"p":          {},
+	"pre":        {},
+	"s":          {},
+	"strong":     {},
+	"sub":        {},
+	"sup":        {},
+	"table":      {},
+	"tbody":      {},
+	"td":         {"align": true},
+	"th":         {"align": true},
+	"thead":      {},
+	"tr":         {},
+	"ul":         {},
+}
+
+// droppedWithContent are elements whose content is removed along with them,
+// since it is code or markup rather than text. Void elements such as embed
+// have no content and must not be listed, or everything after them would be
+// dropped.
+var droppedWithContent = map[string]bool{
+	"iframe":   true,
+	"math":     true,
+	"noscript": true,
+	"object":   true,
+	"script":   true,
+	"select":   true,
+	"style":    true,
+	"svg":      true,
+	"template": true,
+	"textarea": true,
+	"title":    true,
+}
+
+var voidTags = map[string]bool{
+	"br":  true,
+	"hr":  true,
+	"img": true,
+}
+
+var (
+	languageClass = regexp.MustCompile(`^language-[A-Za-z0-9_+-]+$`)
+	digits        = regexp.MustCompile(`^[0-9]{1,9}$`)
+)
+
+// Sanitize reduces HTML to an allowlist of elements and attributes. Links may
+// only point to http, https and mailto URLs or relative ones, and are marked
+// nofollow. Elements are closed in order, whatever the input looks like.
+func Sanitize(s string) string {
+	z := html.NewTokenizer(strings.NewReader(s))
+
+	var b strings.Builder
+	var open []string
+
+	// skipping is the element whose content is being dropped, depth how
+	// deeply it is nested in itself
+	var skipping string
+	var depth int
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return ""
+			}
+			for i := len(open) - 1; i >= 0; i-- {
+				b.WriteString("</" + open[i] + ">")
+			}
+			return b.String()
+
+		case html.TextToken:
+			if depth == 0 {
+				b.WriteString(html.EscapeString(string(z.Text())))
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if depth > 0 {
+				if tok.Data == skipping && tt == html.StartTagToken {
+					depth++
+				}
+				continue
+			}
+			if droppedWithContent[tok.Data] {
+				if tt == html.StartTagToken && !voidTags[tok.Data] {
+					skipping, depth = tok.Data, 1
+				}
+				continue
+			}
+
+			attrs, ok := allowedTags[tok.Data]
+			if !ok {
+				continue
+			}
+
+			b.WriteString("<" + tok.Data)
+			for _, attr := range tok.Attr {
+				if attrs[attr.Key] && allowedValue(tok.Data, attr.Key, attr.Val) {
+					b.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+				}
+			}
+			if tok.Data == "a" {
+				b.WriteString(` rel="nofollow noopener noreferrer"`)
+			}
+
+			if voidTags[tok.Data] {
+				b.WriteString(" />")
+				continue
+			}
+			b.WriteString(">")
+			if tt == html.SelfClosingTagToken {
+				b.WriteString("</" + tok.Data + ">")
+				continue
+			}
+			open = append(open, tok.Data)
+
+		case html.EndTagToken:
+			tok := z.Token()
+			if depth > 0 {
+				if tok.Data == skipping {
+					depth--
+				}
+				continue
+			}
+
+			// Close everything opened since the matching start tag, and
+			// ignore end tags that match nothing
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != tok.Data {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					b.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+		}
+	}
+}
+
+func allowedValue(tag, attr, val string) bool {
+	switch attr {
+	case "href", "src":
+		return safeURL(val)
+	case "class":
+		return tag == "code" && languageClass.MatchString(val)
+	case "align":
+		return val == "left" || val == "right" || val == "center"
+	case "start":
+		return digits.MatchString(val)
+	default:
+		return true
+	}
+}
+
+func safeURL(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,175 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+const nofollow = ` rel="nofollow noopener noreferrer"`
+
+func TestSanitizeURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"http", `<a href="http://example.com">x</a>`, `<a href="http://example.com"` + nofollow + `>x</a>`},
+		{"https", `<a href="https://example.com/a?b=c&amp;d=e">x</a>`, `<a href="https://example.com/a?b=c&amp;d=e"` + nofollow + `>x</a>`},
+		{"mailto", `<a href="mailto:a@example.com">x</a>`, `<a href="mailto:a@example.com"` + nofollow + `>x</a>`},
+		{"relative", `<a href="/posts/1">x</a>`, `<a href="/posts/1"` + nofollow + `>x</a>`},
+		{"javascript", `<a href="javascript:alert(1)">x</a>`, `<a` + nofollow + `>x</a>`},
+		{"mixed case", `<a href="JaVaScRiPt:alert(1)">x</a>`, `<a` + nofollow + `>x</a>`},
+		{"leading space", `<a href="  javascript:alert(1)">x</a>`, `<a` + nofollow + `>x</a>`},
+		{"decimal entities", `<a href="&#106;&#97;&#118;&#97;&#115;&#99;&#114;&#105;&#112;&#116;&#58;alert(1)">x</a>`, `<a` + nofollow + `>x</a>`},
+		{"hex entities", `<a href="&#x6A;avascript&#x3A;alert(1)">x</a>`, `<a` + nofollow + `>x</a>`},
+		{"named colon", `<a href="javascript&colon;alert(1)">x</a>`, `<a` + nofollow + `>x</a>`},
+		{"tab inside", "<a href=\"java\tscript:alert(1)\">x</a>", `<a` + nofollow + `>x</a>`},
+		{"encoded tab inside", `<a href="java&#x09;script:alert(1)">x</a>`, `<a` + nofollow + `>x</a>`},
+		{"newline inside", "<a href=\"java\nscript:alert(1)\">x</a>", `<a` + nofollow + `>x</a>`},
+		{"leading control", `<a href="&#x01;javascript:alert(1)">x</a>`, `<a` + nofollow + `>x</a>`},
+		{"vbscript", `<a href="vbscript:msgbox(1)">x</a>`, `<a` + nofollow + `>x</a>`},
+		{"data link", `<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`, `<a` + nofollow + `>x</a>`},
+		{"data image", `<img src="data:image/svg+xml,&lt;svg onload=alert(1)&gt;">`, `<img />`},
+		{"javascript image", `<img src="javascript:alert(1)" alt="a">`, `<img alt="a" />`},
+		{"unquoted", `<a href=javascript:alert(1)>x</a>`, `<a` + nofollow + `>x</a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sanitize(tt.in); got != tt.want {
+				t.Errorf("Sanitize(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeDropsDangerousElements(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"script", `a<script>alert(1)</script>b`, `ab`},
+		{"script with attributes", `a<script src="https://evil.example/x.js"></script>b`, `ab`},
+		{"uppercase script", `a<SCRIPT>alert(1)</SCRIPT>b`, `ab`},
+		{"style", `a<style>body{display:none}</style>b`, `ab`},
+		{"iframe", `a<iframe src="https://evil.example"></iframe>b`, `ab`},
+		{"svg", `a<svg onload="alert(1)"><script>alert(1)</script><text>t</text></svg>b`, `ab`},
+		{"nested svg", `a<svg><svg></svg><g>inner</g></svg>b`, `ab`},
+		{"object", `a<object data="x.swf"><param name="a"></object>b`, `ab`},
+		{"embed", `a<embed src="x.swf">b`, `ab`},
+		{"math", `a<math><mi>x</mi></math>b`, `ab`},
+		{"template", `a<template><img src=x onerror=alert(1)></template>b`, `ab`},
+		{"unclosed script", `a<script>alert(1)`, `a`},
+		{"form elements", `<form action="/x"><input value="v"><button>go</button></form>`, `go`},
+		{"textarea", `a<textarea><script>alert(1)</script></textarea>b`, `ab`},
+		{"comment", `a<!-- <script>alert(1)</script> -->b`, `ab`},
+		{"unknown element keeps text", `<span class="x">text</span>`, `text`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sanitize(tt.in); got != tt.want {
+				t.Errorf("Sanitize(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeAttributes(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"onclick", `<p onclick="alert(1)">x</p>`, `<p>x</p>`},
+		{"onerror", `<img src="/a.png" onerror="alert(1)">`, `<img src="/a.png" />`},
+		{"uppercase handler", `<b ONMOUSEOVER="alert(1)">x</b>`, `<b>x</b>`},
+		{"handler on link", `<a href="/x" onfocus="alert(1)" autofocus>x</a>`, `<a href="/x"` + nofollow + `>x</a>`},
+		{"style", `<p style="background:url(javascript:alert(1))">x</p>`, `<p>x</p>`},
+		{"target and rel replaced", `<a href="/x" target="_blank" rel="opener">x</a>`, `<a href="/x"` + nofollow + `>x</a>`},
+		{"id and class", `<p id="a" class="b">x</p>`, `<p>x</p>`},
+		{"code language", `<code class="language-go">x</code>`, `<code class="language-go">x</code>`},
+		{"code other class", `<code class="x y">x</code>`, `<code>x</code>`},
+		{"align", `<td align="center">x</td><td align="justify">y</td>`, `<td align="center">x</td><td>y</td>`},
+		{"start", `<ol start="3"></ol><ol start="3;x"></ol>`, `<ol start="3"></ol><ol></ol>`},
+		{"title escaped", `<a href="/x" title='"><script>alert(1)</script>'>x</a>`, `<a href="/x" title="&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;"` + nofollow + `>x</a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sanitize(tt.in); got != tt.want {
+				t.Errorf("Sanitize(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeStructure(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"unclosed", `<p><b>x`, `<p><b>x</b></p>`},
+		{"misnested", `<b><i>x</b>y</i>`, `<b><i>x</i></b>y`},
+		{"stray end tag", `x</p></b>y`, `xy`},
+		{"end tag of dropped element", `<b>x</span>y</b>`, `<b>xy</b>`},
+		{"nested lists", `<ul><li>a<ul><li>b</li></ul></li></ul>`, `<ul><li>a<ul><li>b</li></ul></li></ul>`},
+		{"self closing", `<b/>x`, `<b></b>x`},
+		{"void", `a<br>b<hr/>c`, `a<br />b<hr />c`},
+		{"text escaped", `1 < 2 & 3 > 2`, `1 &lt; 2 &amp; 3 &gt; 2`},
+		{"entities kept escaped", `&lt;script&gt;`, `&lt;script&gt;`},
+		{"broken tag", `<b <i>x</b>`, `<b>x</b>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sanitize(tt.in); got != tt.want {
+				t.Errorf("Sanitize(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "markdown",
+			in:   "# Title\n\nSome **bold** and `code`.",
+			want: []string{"<h1>Title</h1>", "<strong>bold</strong>", "<code>code</code>"},
+		},
+		{
+			name:    "javascript link",
+			in:      "[x](javascript:alert(1))",
+			notWant: []string{"javascript:"},
+		},
+		{
+			name:    "raw html",
+			in:      "hi <script>alert(1)</script> <img src=x onerror=alert(1)>",
+			want:    []string{"hi"},
+			notWant: []string{"<script", "alert(1)</", "onerror"},
+		},
+		{
+			name: "links are nofollow",
+			in:   "<https://example.com>",
+			want: []string{`href="https://example.com"`, "nofollow"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Render(tt.in)
+			for _, s := range tt.want {
+				if !strings.Contains(got, s) {
+					t.Errorf("Render(%q) = %q, missing %q", tt.in, got, s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(got, s) {
+					t.Errorf("Render(%q) = %q, contains %q", tt.in, got, s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"2026/10/a.jpg", true},
+		{"a.jpg", true},
+		{"a..b.jpg", true},
+		{".hidden", true},
+		{"", false},
+		{"..", false},
+		{"../a.jpg", false},
+		{"2026/../../a.jpg", false},
+		{"2026/10/..", false},
+		{"./a.jpg", false},
+		{"/etc/passwd", false},
+		{"2026//a.jpg", false},
+		{"2026/10/", false},
+		{`..\a.jpg`, false},
+		{`2026\..\..\a.jpg`, false},
+	}
+	for _, tt := range tests {
+		if got := validKey(tt.key); got != tt.valid {
+			t.Errorf("validKey(%q) = %v, want %v", tt.key, got, tt.valid)
+		}
+	}
+}
+
+func TestFSStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFSStore(filepath.Join(dir, "media"), "http://localhost/v1/media/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	key := "2026/10/a.png"
+	if err := s.Put(ctx, key, []byte("png"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "png" {
+		t.Errorf("got %q, want png", data)
+	}
+
+	// No temporary files are left behind
+	entries, _ := os.ReadDir(filepath.Join(dir, "media", "2026", "10"))
+	if len(entries) != 1 {
+		t.Errorf("got %d files, want only the blob", len(entries))
+	}
+
+	if got, want := s.URL(key), "http://localhost/v1/media/2026/10/a.png"; got != want {
+		t.Errorf("URL = %s, want %s", got, want)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, key); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Get after Delete: got %v, want ErrBlobNotFound", err)
+	}
+	// Deleting twice is fine
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("second Delete: %v", err)
+	}
+}
+
+func TestFSStoreRejectsPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "media")
+	s, err := NewFSStore(root, "/media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	secret := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"../secret.txt", "a/../../secret.txt", "/secret.txt", `..\secret.txt`} {
+		if err := s.Put(ctx, key, []byte("overwritten"), "text/plain"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put %q: got %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := s.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get %q: got %v, want ErrInvalidKey", key, err)
+		}
+		if err := s.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete %q: got %v, want ErrInvalidKey", key, err)
+		}
+	}
+
+	data, err := os.ReadFile(secret)
+	if err != nil || string(data) != "secret" {
+		t.Errorf("file outside the store was touched: %q %v", data, err)
+	}
+}
@@ -0,0 +1,143 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+
+	// decoders for image.Decode
+	_ "image/gif"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported media type")
+	ErrImageTooLarge   = errors.New("image dimensions are too large")
+)
+
+// AllowedTypes are the content types that can be uploaded.
+var AllowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+const (
+	// ThumbnailSize is the longest side of a thumbnail in pixels.
+	ThumbnailSize = 320
+	// maxPixels guards against decompression bombs: small files that decode
+	// into huge images.
+	maxPixels = 40_000_000
+)
+
+// Upload describes an uploaded file after sniffing and thumbnailing.
+type Upload struct {
+	ContentType string
+	Extension   string
+	Width       int
+	Height      int
+	// Thumbnail is nil for types that can't be decoded, e.g. WebP.
+	Thumbnail          []byte
+	ThumbnailType      string
+	ThumbnailExtension string
+}
+
+// Process detects the type of data from its content, never from what the
+// client claims, and renders a thumbnail for images it can decode.
+func Process(data []byte) (*Upload, error) {
+	mime := mimetype.Detect(data)
+
+	contentType := mime.String()
+	if !AllowedTypes[contentType] {
+		return nil, ErrUnsupportedType
+	}
+
+	upload := &Upload{
+		ContentType: contentType,
+		Extension:   mime.Extension(),
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			// Allowed but no decoder available, keep it without a thumbnail
+			return upload, nil
+		}
+		return nil, ErrUnsupportedType
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrImageTooLarge
+	}
+	upload.Width, upload.Height = cfg.Width, cfg.Height
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	thumb := new(bytes.Buffer)
+	small := resize(img, ThumbnailSize)
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(thumb, small, &jpeg.Options{Quality: 80})
+		upload.ThumbnailType, upload.ThumbnailExtension = "image/jpeg", ".jpg"
+	} else {
+		err = png.Encode(thumb, small)
+		upload.ThumbnailType, upload.ThumbnailExtension = "image/png", ".png"
+	}
+	if err != nil {
+		return nil, err
+	}
+	upload.Thumbnail = thumb.Bytes()
+
+	return upload, nil
+}
+
+// resize scales img down so that its longest side is at most size, averaging
+// the source pixels that fall into each target pixel. Smaller images are
+// returned as they are.
+func resize(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= size && sh <= size {
+		return img
+	}
+
+	w, h := size, sh*size/sw
+	if sh > sw {
+		w, h = sw*size/sh, size
+	}
+	w, h = max(w, 1), max(h, 1)
+
+	dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*sh/h
+		y1 := max(b.Min.Y+(y+1)*sh/h, y0+1)
+
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*sw/w
+			x1 := max(b.Min.X+(x+1)*sw/w, x0+1)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,195 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func encodeGIF(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := gif.Encode(&b, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// pngHeader returns the start of a PNG that claims the given dimensions, as
+// a decompression bomb would.
+func pngHeader(w, h uint32) []byte {
+	b := []byte("\x89PNG\r\n\x1a\n")
+
+	ihdr := []byte("IHDR")
+	ihdr = binary.BigEndian.AppendUint32(ihdr, w)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, h)
+	// 8 bits per channel, RGBA, default compression, filter and interlace
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+
+	b = binary.BigEndian.AppendUint32(b, uint32(len(ihdr)-4))
+	b = append(b, ihdr...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          []byte
+		contentType   string
+		extension     string
+		width, height int
+		thumbType     string
+		thumbW        int
+		thumbH        int
+	}{
+		{"png", encodePNG(t, testImage(40, 20)), "image/png", ".png", 40, 20, "image/png", 40, 20},
+		{"jpeg", encodeJPEG(t, testImage(40, 20)), "image/jpeg", ".jpg", 40, 20, "image/jpeg", 40, 20},
+		{"gif", encodeGIF(t, testImage(40, 20)), "image/gif", ".gif", 40, 20, "image/png", 40, 20},
+		{"wide", encodePNG(t, testImage(1000, 500)), "image/png", ".png", 1000, 500, "image/png", ThumbnailSize, ThumbnailSize / 2},
+		{"tall", encodeJPEG(t, testImage(200, 800)), "image/jpeg", ".jpg", 200, 800, "image/jpeg", 80, ThumbnailSize},
+		{"thin", encodePNG(t, testImage(2000, 1)), "image/png", ".png", 2000, 1, "image/png", ThumbnailSize, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := Process(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.ContentType != tt.contentType || u.Extension != tt.extension {
+				t.Errorf("got %s %s, want %s %s", u.ContentType, u.Extension, tt.contentType, tt.extension)
+			}
+			if u.Width != tt.width || u.Height != tt.height {
+				t.Errorf("got %dx%d, want %dx%d", u.Width, u.Height, tt.width, tt.height)
+			}
+			if u.ThumbnailType != tt.thumbType {
+				t.Errorf("got thumbnail type %s, want %s", u.ThumbnailType, tt.thumbType)
+			}
+
+			thumb, _, err := image.DecodeConfig(bytes.NewReader(u.Thumbnail))
+			if err != nil {
+				t.Fatalf("decoding thumbnail: %v", err)
+			}
+			if thumb.Width != tt.thumbW || thumb.Height != tt.thumbH {
+				t.Errorf("got a %dx%d thumbnail, want %dx%d", thumb.Width, thumb.Height, tt.thumbW, tt.thumbH)
+			}
+		})
+	}
+}
+
+func TestProcessWebP(t *testing.T) {
+	// Allowed, but there is no decoder for a thumbnail
+	data := []byte("RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00")
+	data = append(data, make([]byte, 24)...)
+
+	u, err := Process(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ContentType != "image/webp" || u.Extension != ".webp" {
+		t.Errorf("got %s %s, want image/webp .webp", u.ContentType, u.Extension)
+	}
+	if u.Thumbnail != nil {
+		t.Error("got a thumbnail for WebP")
+	}
+}
+
+func TestProcessSniffsContent(t *testing.T) {
+	tests := map[string][]byte{
+		"html":          []byte(`<!DOCTYPE html><html><script>alert(1)</script></html>`),
+		"svg":           []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"></svg>`),
+		"text":          []byte("just text"),
+		"pdf":           []byte("%PDF-1.4\n"),
+		"zip":           []byte("PK\x03\x04\x14\x00\x00\x00"),
+		"empty":         {},
+		"html with png": append([]byte("<html>"), encodePNG(t, testImage(2, 2))...),
+	}
+	for name, data := range tests {
+		if _, err := Process(data); !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("%s: got %v, want ErrUnsupportedType", name, err)
+		}
+	}
+}
+
+func TestProcessCorruptImage(t *testing.T) {
+	// A valid header followed by garbage
+	data := append(pngHeader(10, 10), []byte("garbage")...)
+	if _, err := Process(data); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("got %v, want ErrUnsupportedType", err)
+	}
+
+	jpg := encodeJPEG(t, testImage(40, 40))
+	if _, err := Process(jpg[:len(jpg)/2]); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("truncated JPEG: got %v, want ErrUnsupportedType", err)
+	}
+}
+
+func TestProcessRejectsHugeDimensions(t *testing.T) {
+	// Caught from the header, before anything is decoded
+	if _, err := Process(pngHeader(10000, 5000)); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("got %v, want ErrImageTooLarge", err)
+	}
+	if _, err := Process(pngHeader(50000, 50000)); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("got %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		c := color.RGBA{0, 0, 0, 255}
+		if x%2 == 0 {
+			c = color.RGBA{255, 255, 255, 255}
+		}
+		src.Set(x, 0, c)
+		src.Set(x, 1, c)
+	}
+
+	// Each target pixel averages a white and a black one
+	dst := resize(src, 2)
+	if b := dst.Bounds(); b.Dx() != 2 || b.Dy() != 1 {
+		t.Fatalf("got %dx%d, want 2x1", b.Dx(), b.Dy())
+	}
+	r, g, b, a := dst.At(0, 0).RGBA()
+	if r != 0x7fff || g != 0x7fff || b != 0x7fff || a != 0xffff {
+		t.Errorf("got %04x %04x %04x %04x, want grey", r, g, b, a)
+	}
+
+	if small := testImage(10, 10); resize(small, 20) != small {
+		t.Error("an image within the size was resized")
+	}
+}
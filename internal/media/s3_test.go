@@ -0,0 +1,311 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "eu-west-1"
+	testBucket    = "uploads"
+)
+
+type object struct {
+	data        []byte
+	contentType string
+}
+
+// fakeS3 is an S3 stand-in that keeps objects in memory and checks the
+// Signature Version 4 of every request the way S3 does.
+type fakeS3 struct {
+	t   *testing.T
+	now time.Time
+
+	mu      sync.Mutex
+	objects map[string]object
+}
+
+func newFakeS3(t *testing.T, now time.Time) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{t: t, now: now, objects: map[string]object{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if msg := f.verify(r, body); msg != "" {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>"+msg+"</Message></Error>")
+		return
+	}
+
+	prefix := "/" + testBucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[key] = object{data: body, contentType: r.Header.Get("Content-Type")}
+	case http.MethodGet:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Write(obj.data)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// verify recomputes the signature of the request as received and returns
+// what is wrong with it, if anything.
+func (f *fakeS3) verify(r *http.Request, body []byte) string {
+	auth := r.Header.Get("Authorization")
+	rest, ok := strings.CutPrefix(auth, "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return "not signed with AWS4-HMAC-SHA256"
+	}
+
+	fields := map[string]string{}
+	for _, part := range strings.Split(rest, ", ") {
+		k, v, _ := strings.Cut(part, "=")
+		fields[k] = v
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return "bad X-Amz-Date"
+	}
+	if d := signedAt.Sub(f.now); d > 15*time.Minute || d < -15*time.Minute {
+		return "request time too skewed"
+	}
+
+	date := amzDate[:8]
+	scope := date + "/" + testRegion + "/s3/aws4_request"
+	if fields["Credential"] != testAccessKey+"/"+scope {
+		return "bad credential " + fields["Credential"]
+	}
+
+	payloadHash := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(payloadHash[:]) {
+		return "payload hash does not match the body"
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(signed) {
+		return "signed headers are not sorted"
+	}
+	required := map[string]bool{"host": true, "x-amz-date": true, "x-amz-content-sha256": true}
+	if r.Header.Get("Content-Type") != "" {
+		required["content-type"] = true
+	}
+	for _, name := range signed {
+		delete(required, name)
+	}
+	if len(required) > 0 {
+		return "headers left unsigned"
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		v := r.Header.Get(name)
+		if name == "host" {
+			v = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(v) + "\n")
+	}
+
+	// The path as sent, not as the server decoded it
+	path := r.RequestURI
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		path,
+		r.URL.RawQuery,
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range []string{date, testRegion, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	if want := hex.EncodeToString(hmacSHA256(key, stringToSign)); fields["Signature"] != want {
+		return "signature does not match"
+	}
+	return ""
+}
+
+func newTestS3Store(srv *httptest.Server, now time.Time, secret string) *S3Store {
+	s := NewS3Store(S3Config{
+		Endpoint:  srv.URL + "/",
+		Region:    testRegion,
+		Bucket:    testBucket,
+		AccessKey: testAccessKey,
+		SecretKey: secret,
+	}, srv.Client())
+	s.Now = func() time.Time { return now }
+	return s
+}
+
+func TestSigningKey(t *testing.T) {
+	// The example of the AWS Signature Version 4 documentation
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	if got, want := hex.EncodeToString(key), "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	fake, srv := newFakeS3(t, now)
+	s := newTestS3Store(srv, now, testSecretKey)
+	ctx := context.Background()
+
+	keys := []string{
+		"2026/10/a.png",
+		// Needs encoding, which the signature has to agree with
+		"2026/10/a b+c=d&é~.png",
+	}
+	for _, key := range keys {
+		data := []byte("content of " + key)
+		if err := s.Put(ctx, key, data, "image/png"); err != nil {
+			t.Fatalf("Put %q: %v", key, err)
+		}
+
+		fake.mu.Lock()
+		obj, ok := fake.objects[key]
+		fake.mu.Unlock()
+		if !ok || !bytes.Equal(obj.data, data) || obj.contentType != "image/png" {
+			t.Fatalf("Put %q stored %+v", key, obj)
+		}
+
+		rc, err := s.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get %q: %v", key, err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if !bytes.Equal(got, data) {
+			t.Errorf("Get %q = %q, want %q", key, got, data)
+		}
+
+		if err := s.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete %q: %v", key, err)
+		}
+		if _, err := s.Get(ctx, key); !errors.Is(err, ErrBlobNotFound) {
+			t.Errorf("Get %q after Delete: got %v, want ErrBlobNotFound", key, err)
+		}
+	}
+}
+
+func TestS3StoreBadSignature(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	_, srv := newFakeS3(t, now)
+	ctx := context.Background()
+
+	wrongSecret := newTestS3Store(srv, now, "not the secret")
+	err := wrongSecret.Put(ctx, "a.png", []byte("x"), "image/png")
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("got %v, want a 403 with the error from the body", err)
+	}
+
+	stale := newTestS3Store(srv, now.Add(-time.Hour), testSecretKey)
+	if err := stale.Put(ctx, "a.png", []byte("x"), "image/png"); err == nil {
+		t.Error("a request signed an hour ago was accepted")
+	}
+}
+
+func TestS3StoreInvalidKeys(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request sent for an invalid key: %s %s", r.Method, r.URL)
+	}))
+	defer srv.Close()
+
+	s := newTestS3Store(srv, time.Now(), testSecretKey)
+	ctx := context.Background()
+
+	for _, key := range []string{"../other-bucket/x", "a/../../x", "/abs", ""} {
+		if err := s.Put(ctx, key, []byte("x"), "image/png"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put %q: got %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := s.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get %q: got %v, want ErrInvalidKey", key, err)
+		}
+		if err := s.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete %q: got %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestS3StoreURL(t *testing.T) {
+	s := NewS3Store(S3Config{Endpoint: "https://s3.example.com/", Bucket: "uploads"}, nil)
+	if got, want := s.URL("2026/10/a b.png"), "https://s3.example.com/uploads/2026/10/a%20b.png"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	s = NewS3Store(S3Config{Endpoint: "https://s3.example.com", Bucket: "uploads", PublicURL: "https://cdn.example.com/"}, nil)
+	if got, want := s.URL("2026/10/a.png"), "https://cdn.example.com/2026/10/a.png"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if _, err := url.Parse(s.URL("2026/10/ü?#.png")); err != nil {
+		t.Errorf("URL with special characters doesn't parse: %v", err)
+	}
+}
+
+func TestUriEncode(t *testing.T) {
+	tests := map[string]string{
+		"a/b.png":    "a/b.png",
+		"a b":        "a%20b",
+		"a+b=c&d":    "a%2Bb%3Dc%26d",
+		"-_.~":       "-_.~",
+		"é":          "%C3%A9",
+		"?#%":        "%3F%23%25",
+		"UPPER/case": "UPPER/case",
+	}
+	for in, want := range tests {
+		if got := uriEncode(in); got != want {
+			t.Errorf("uriEncode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
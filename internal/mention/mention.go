@@ -0,0 +1,44 @@
+// Package mention finds @username mentions in text.
+package mention
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxUsernameLength matches the longest username that can be registered.
+const maxUsernameLength = 100
+
+// pattern matches an @ that does not follow a word character, so email
+// addresses are not mentions, and the username after it.
+var pattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_.-]+)`)
+
+// Span is a mention in a text. Start and End are offsets in characters
+// (Unicode code points) covering the @ and the username.
+type Span struct {
+	Username string
+	Start    int
+	End      int
+}
+
+// Find returns the mentions in text in the order they appear.
+func Find(text string) []Span {
+	var spans []Span
+	for _, m := range pattern.FindAllStringSubmatchIndex(text, -1) {
+		// dots and dashes at the end are punctuation, not part of the name
+		username := strings.TrimRight(text[m[2]:m[3]], ".-")
+		if username == "" || utf8.RuneCountInString(username) > maxUsernameLength {
+			continue
+		}
+
+		at := m[2] - 1
+		start := utf8.RuneCountInString(text[:at])
+		spans = append(spans, Span{
+			Username: username,
+			Start:    start,
+			End:      start + 1 + utf8.RuneCountInString(username),
+		})
+	}
+	return spans
+}
@@ -0,0 +1,69 @@
+package mention
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	long := strings.Repeat("a", maxUsernameLength)
+
+	tests := []struct {
+		name string
+		text string
+		want []Span
+	}{
+		{"none", "no mentions here", nil},
+		{"empty", "", nil},
+		{"start of text", "@alice hi", []Span{{"alice", 0, 6}}},
+		{"after a space", "hi @alice", []Span{{"alice", 3, 9}}},
+		{"several", "@alice and @bob", []Span{{"alice", 0, 6}, {"bob", 11, 15}}},
+		{"next to each other", "@alice,@bob", []Span{{"alice", 0, 6}, {"bob", 7, 11}}},
+		{"repeated", "@bob @bob", []Span{{"bob", 0, 4}, {"bob", 5, 9}}},
+		{"newline", "hi\n@bob", []Span{{"bob", 3, 7}}},
+
+		// Emails and other @s inside words
+		{"email", "mail me@example.com", nil},
+		{"email with digits", "x1@example.com", nil},
+		{"underscore before", "a_@bob", nil},
+		{"accented letter before", "café@bob", nil},
+		{"double at", "@@bob", nil},
+		{"at after a username", "@alice@bob", []Span{{"alice", 0, 6}}},
+		{"bare at", "meet @ noon", nil},
+
+		// Punctuation around the name
+		{"parentheses", "(@bob)", []Span{{"bob", 1, 5}}},
+		{"comma", "@bob, hi", []Span{{"bob", 0, 4}}},
+		{"exclamation", "thanks @bob!", []Span{{"bob", 7, 11}}},
+		{"possessive", "@bob's post", []Span{{"bob", 0, 4}}},
+		{"hash before", "#@bob", []Span{{"bob", 1, 5}}},
+		{"underscore in name", "@bob_smith", []Span{{"bob_smith", 0, 10}}},
+		{"dot in name", "@bob.smith", []Span{{"bob.smith", 0, 10}}},
+		{"dash in name", "@bob-smith", []Span{{"bob-smith", 0, 10}}},
+
+		// Dots and dashes at the end end a sentence
+		{"trailing dot", "ask @bob.", []Span{{"bob", 4, 8}}},
+		{"trailing dots", "ask @bob...", []Span{{"bob", 4, 8}}},
+		{"trailing dash", "@bob- hi", []Span{{"bob", 0, 4}}},
+		{"trailing dot and dash", "@bob.-", []Span{{"bob", 0, 4}}},
+		{"only dots", "@... hi", nil},
+
+		// Offsets count code points, not bytes
+		{"accents before", "héllo @bob", []Span{{"bob", 6, 10}}},
+		{"cjk before", "日本 @bob", []Span{{"bob", 3, 7}}},
+		{"emoji before", "👋 @bob", []Span{{"bob", 2, 6}}},
+		{"multi-byte name", "hi @ユーザー", []Span{{"ユーザー", 3, 8}}},
+		{"multi-byte name trimmed", "@zoë.", []Span{{"zoë", 0, 4}}},
+
+		{"longest name", "@" + long, []Span{{long, 0, maxUsernameLength + 1}}},
+		{"name too long", "@" + long + "a", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Find(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Find(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
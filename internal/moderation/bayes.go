@@ -0,0 +1,143 @@
+package moderation
+
+import (
+	"context"
+	"math"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// minTrainingExamples of each class are needed before the classifier
+	// holds anything, a handful of decisions say little.
+	minTrainingExamples = 20
+	// spamThreshold is the probability of being spam at which content is
+	// held.
+	spamThreshold = 0.95
+)
+
+// Example is a text moderators judged.
+type Example struct {
+	Text string
+	Spam bool
+}
+
+// SpamClassifier is a naive Bayes classifier over the words of content,
+// trained from moderation decisions. It only ever holds content, a model
+// trained on a few hundred decisions shouldn't reject anything on its own.
+type SpamClassifier struct {
+	mu    sync.RWMutex
+	model *bayesModel
+}
+
+type bayesModel struct {
+	// docs and words count the examples and the words in them per class,
+	// counts the occurrences of each word per class.
+	docs   [2]int
+	words  [2]int
+	counts map[string]*[2]int
+}
+
+const (
+	ham = iota
+	spam
+)
+
+func NewSpamClassifier() *SpamClassifier {
+	return &SpamClassifier{}
+}
+
+// Train replaces what the classifier learned with the examples.
+func (f *SpamClassifier) Train(examples []Example) {
+	m := &bayesModel{counts: make(map[string]*[2]int)}
+	for _, e := range examples {
+		class := ham
+		if e.Spam {
+			class = spam
+		}
+		m.docs[class]++
+		for _, w := range features(e.Text) {
+			c, ok := m.counts[w]
+			if !ok {
+				c = &[2]int{}
+				m.counts[w] = c
+			}
+			c[class]++
+			m.words[class]++
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.model = m
+}
+
+// Trained reports how many spam and ham examples the classifier learned
+// from.
+func (f *SpamClassifier) Trained() (spamExamples, hamExamples int) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.model == nil {
+		return 0, 0
+	}
+	return f.model.docs[spam], f.model.docs[ham]
+}
+
+// SpamProbability is how likely the text is spam, or 0.5 if the classifier
+// hasn't learned enough to tell.
+func (f *SpamClassifier) SpamProbability(text string) float64 {
+	f.mu.RLock()
+	m := f.model
+	f.mu.RUnlock()
+
+	if m == nil || m.docs[spam] < minTrainingExamples || m.docs[ham] < minTrainingExamples {
+		return 0.5
+	}
+
+	// Log probabilities with add-one smoothing, so words never seen in a
+	// class don't rule it out
+	total := float64(m.docs[spam] + m.docs[ham])
+	vocabulary := float64(len(m.counts))
+	var score [2]float64
+	for class := range score {
+		score[class] = math.Log(float64(m.docs[class]) / total)
+	}
+	for _, w := range features(text) {
+		c, ok := m.counts[w]
+		if !ok {
+			continue
+		}
+		for class := range score {
+			score[class] += math.Log((float64(c[class]) + 1) / (float64(m.words[class]) + vocabulary))
+		}
+	}
+	return 1 / (1 + math.Exp(score[ham]-score[spam]))
+}
+
+func (f *SpamClassifier) Name() string {
+	return "spam"
+}
+
+func (f *SpamClassifier) Check(_ context.Context, c *Content) (Verdict, error) {
+	if f.SpamProbability(c.Text) < spamThreshold {
+		return Verdict{}, nil
+	}
+	return Verdict{
+		Decision: Hold,
+		Category: "spam",
+		Reason:   "looks like spam",
+	}, nil
+}
+
+// features are the words of text the classifier looks at.
+func features(text string) []string {
+	tokens := Tokens(text)
+	words := tokens[:0]
+	for _, t := range tokens {
+		if utf8.RuneCountInString(t) > 1 {
+			words = append(words, t)
+		}
+	}
+	return words
+}
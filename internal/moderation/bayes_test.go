@@ -0,0 +1,119 @@
+package moderation
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func trainingSet(spamN, hamN int) []Example {
+	spamTexts := []string{
+		"buy cheap pills now",
+		"cheap pills, best price now",
+		"click now for cheap pills",
+	}
+	hamTexts := []string{
+		"lovely walk in the park today",
+		"the park was lovely this morning",
+		"walked the dog in the park today",
+	}
+
+	var examples []Example
+	for i := 0; i < spamN; i++ {
+		examples = append(examples, Example{Text: spamTexts[i%len(spamTexts)], Spam: true})
+	}
+	for i := 0; i < hamN; i++ {
+		examples = append(examples, Example{Text: hamTexts[i%len(hamTexts)]})
+	}
+	return examples
+}
+
+func TestSpamClassifierUntrained(t *testing.T) {
+	f := NewSpamClassifier()
+	if p := f.SpamProbability("cheap pills"); p != 0.5 {
+		t.Errorf("got %v untrained, want 0.5", p)
+	}
+	if s, h := f.Trained(); s != 0 || h != 0 {
+		t.Errorf("got %d/%d examples untrained", s, h)
+	}
+}
+
+func TestSpamClassifierNeedsEnoughOfBoth(t *testing.T) {
+	for _, n := range [][2]int{
+		{minTrainingExamples - 1, 100},
+		{100, minTrainingExamples - 1},
+	} {
+		f := NewSpamClassifier()
+		f.Train(trainingSet(n[0], n[1]))
+		if p := f.SpamProbability("buy cheap pills now"); p != 0.5 {
+			t.Errorf("%d spam, %d ham: got %v, want 0.5", n[0], n[1], p)
+		}
+		v, _ := f.Check(context.Background(), &Content{Text: "buy cheap pills now"})
+		if v.Decision != Allow {
+			t.Errorf("%d spam, %d ham: got %s, want nothing held", n[0], n[1], v.Decision)
+		}
+	}
+}
+
+func TestSpamClassifier(t *testing.T) {
+	f := NewSpamClassifier()
+	f.Train(trainingSet(minTrainingExamples, minTrainingExamples))
+
+	if s, h := f.Trained(); s != minTrainingExamples || h != minTrainingExamples {
+		t.Errorf("got %d spam and %d ham examples", s, h)
+	}
+
+	tests := []struct {
+		text string
+		hold bool
+	}{
+		{"CHEAP PILLS now!", true},
+		{"a walk in the park", false},
+		// Some of each isn't sure enough to hold
+		{"now today", false},
+		// Words never seen say nothing
+		{"quantum chromodynamics", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		v, err := f.Check(context.Background(), &Content{Text: tt.text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := v.Decision == Hold; got != tt.hold {
+			t.Errorf("%q: got %s (p=%.3f), want held %v", tt.text, v.Decision, f.SpamProbability(tt.text), tt.hold)
+		}
+		if tt.hold && v.Category != "spam" {
+			t.Errorf("%q: got category %q", tt.text, v.Category)
+		}
+	}
+
+	if p := f.SpamProbability("cheap pills"); p < spamThreshold {
+		t.Errorf("spam scored %v", p)
+	}
+	if p := f.SpamProbability("walk in the park"); p > 1-spamThreshold {
+		t.Errorf("ham scored %v", p)
+	}
+	if p := f.SpamProbability("quantum chromodynamics"); p != 0.5 {
+		t.Errorf("unknown words scored %v, want the even prior", p)
+	}
+}
+
+func TestSpamClassifierRetrain(t *testing.T) {
+	f := NewSpamClassifier()
+	f.Train(trainingSet(minTrainingExamples, minTrainingExamples))
+
+	// Training replaces what was learned
+	f.Train(nil)
+	if p := f.SpamProbability("cheap pills"); p != 0.5 {
+		t.Errorf("got %v after training on nothing, want 0.5", p)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	got := features("I got a FREE iPhone, 2 of them!")
+	want := []string{"got", "free", "iphone", "of", "them"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
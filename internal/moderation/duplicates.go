@@ -0,0 +1,80 @@
+package moderation
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// duplicateMinWords is how long content has to be before it can be a
+	// duplicate. Short replies like "thanks!" are repeated all the time.
+	duplicateMinWords = 5
+	// duplicateSimilarity is the share of words two texts need in common to
+	// be duplicates.
+	duplicateSimilarity = 0.9
+)
+
+// RecentFunc returns the texts of the content of the same kind the user
+// wrote since the given time, except c itself.
+type RecentFunc func(ctx context.Context, c *Content, since time.Time) ([]string, error)
+
+// Duplicates rejects content that repeats, word for word or nearly, what the
+// user posted recently.
+type Duplicates struct {
+	recent RecentFunc
+	window time.Duration
+}
+
+func NewDuplicates(recent RecentFunc, window time.Duration) *Duplicates {
+	return &Duplicates{recent: recent, window: window}
+}
+
+func (f *Duplicates) Name() string {
+	return "duplicates"
+}
+
+func (f *Duplicates) Check(ctx context.Context, c *Content) (Verdict, error) {
+	words := wordSet(Tokens(c.Text))
+	if len(words) < duplicateMinWords {
+		return Verdict{}, nil
+	}
+
+	texts, err := f.recent(ctx, c, time.Now().Add(-f.window))
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	for _, text := range texts {
+		if jaccard(words, wordSet(Tokens(text))) >= duplicateSimilarity {
+			return Verdict{
+				Decision: Reject,
+				Category: "spam",
+				Reason:   "you recently posted the same " + c.Kind,
+			}, nil
+		}
+	}
+	return Verdict{}, nil
+}
+
+func wordSet(tokens []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(tokens))
+	for _, t := range tokens {
+		set[t] = struct{}{}
+	}
+	return set
+}
+
+// jaccard is the number of words two sets have in common over the number of
+// words in either.
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	common := 0
+	for w := range a {
+		if _, ok := b[w]; ok {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
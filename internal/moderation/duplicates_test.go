@@ -0,0 +1,100 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDuplicates(t *testing.T) {
+	recent := []string{"The quick brown fox jumps over the lazy dog"}
+
+	var since time.Time
+	var calls int
+	f := NewDuplicates(func(ctx context.Context, c *Content, s time.Time) ([]string, error) {
+		calls++
+		since = s
+		return recent, nil
+	}, time.Hour)
+
+	tests := []struct {
+		name   string
+		text   string
+		reject bool
+	}{
+		{"same text", "The quick brown fox jumps over the lazy dog", true},
+		{"case and punctuation", "the QUICK brown fox... jumps over the lazy dog!", true},
+		{"lookalike letters", "The quick brоwn fox jumps over the lazy dоg", true},
+		{"reordered", "the lazy dog jumps over the quick brown fox", true},
+		// 8 words of 9 in either
+		{"one more word", "The quick brown fox jumps over the very lazy dog", false},
+		{"one word changed", "The quick brown cat jumps over the lazy dog", false},
+		{"different", "A completely different sentence about something else", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := f.Check(context.Background(), &Content{Kind: KindPost, Text: tt.text})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := v.Decision == Reject; got != tt.reject {
+				t.Errorf("got %s, want rejected %v", v.Decision, tt.reject)
+			}
+		})
+	}
+
+	if d := time.Since(since); d < time.Hour || d > time.Hour+time.Minute {
+		t.Errorf("looked back %v, want the window", d)
+	}
+
+	// Short content is never a duplicate, nothing is looked up
+	calls = 0
+	v, _ := f.Check(context.Background(), &Content{Kind: KindComment, Text: "thanks! thanks! thanks!"})
+	if v.Decision != Allow || calls != 0 {
+		t.Errorf("got %s after %d lookups for a short reply", v.Decision, calls)
+	}
+}
+
+func TestDuplicatesSimilarityThreshold(t *testing.T) {
+	// 10 distinct words against the same 10 and 1 more: 10/11 in common
+	base := "one two three four five six seven eight nine ten"
+	f := NewDuplicates(func(context.Context, *Content, time.Time) ([]string, error) {
+		return []string{base + " eleven"}, nil
+	}, time.Hour)
+
+	v, _ := f.Check(context.Background(), &Content{Kind: KindPost, Text: base})
+	if v.Decision != Reject {
+		t.Errorf("got %s at %.3f similarity, want rejected", v.Decision, 10.0/11)
+	}
+}
+
+func TestDuplicatesLookupError(t *testing.T) {
+	f := NewDuplicates(func(context.Context, *Content, time.Time) ([]string, error) {
+		return nil, errors.New("database down")
+	}, time.Hour)
+
+	if _, err := f.Check(context.Background(), &Content{Text: "one two three four five six"}); err == nil {
+		t.Error("lookup error was swallowed")
+	}
+}
+
+func TestJaccard(t *testing.T) {
+	set := func(words ...string) map[string]struct{} { return wordSet(words) }
+
+	tests := []struct {
+		a, b map[string]struct{}
+		want float64
+	}{
+		{set(), set(), 1},
+		{set("a"), set(), 0},
+		{set("a", "b"), set("a", "b"), 1},
+		{set("a", "b"), set("b", "c"), 1.0 / 3},
+		{set("a", "b", "c", "d"), set("a", "b", "c"), 3.0 / 4},
+	}
+	for _, tt := range tests {
+		if got := jaccard(tt.a, tt.b); got != tt.want {
+			t.Errorf("jaccard(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nati3514/Social/internal/unfurl"
+)
+
+// LinkLimit rejects content with more distinct links than allowed for its
+// kind.
+type LinkLimit struct {
+	max map[string]int
+}
+
+func NewLinkLimit(maxPost, maxComment int) *LinkLimit {
+	return &LinkLimit{max: map[string]int{
+		KindPost:    maxPost,
+		KindComment: maxComment,
+	}}
+}
+
+func (f *LinkLimit) Name() string {
+	return "links"
+}
+
+func (f *LinkLimit) Check(_ context.Context, c *Content) (Verdict, error) {
+	max, ok := f.max[c.Kind]
+	if !ok {
+		return Verdict{}, nil
+	}
+
+	if n := len(unfurl.ExtractURLs(c.Text, max+1)); n > max {
+		return Verdict{
+			Decision: Reject,
+			Category: "spam",
+			Reason:   fmt.Sprintf("a %s can contain at most %d links", c.Kind, max),
+		}, nil
+	}
+	return Verdict{}, nil
+}
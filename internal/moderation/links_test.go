@@ -0,0 +1,48 @@
+package moderation
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLinkLimit(t *testing.T) {
+	f := NewLinkLimit(2, 1)
+
+	tests := []struct {
+		name   string
+		kind   string
+		text   string
+		reject bool
+	}{
+		{"no links", KindPost, "just text", false},
+		{"post at the limit", KindPost, "https://a.example and https://b.example", false},
+		{"post over the limit", KindPost, "https://a.example https://b.example https://c.example", true},
+		{"same link twice", KindPost, "https://a.example https://b.example https://A.example/#top", false},
+		{"tracking parameters don't make links distinct", KindComment, "https://a.example/?utm_source=x https://a.example/", false},
+		{"comment over the limit", KindComment, "https://a.example https://b.example", true},
+		{"other schemes aren't counted", KindComment, "https://a.example ftp://b.example", false},
+		{"unknown kind", "message", "https://a.example https://b.example https://c.example", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := f.Check(context.Background(), &Content{Kind: tt.kind, Text: tt.text})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := v.Decision == Reject; got != tt.reject {
+				t.Errorf("got %s, want rejected %v", v.Decision, tt.reject)
+			}
+			if tt.reject && v.Category != "spam" {
+				t.Errorf("got category %q, want spam", v.Category)
+			}
+		})
+	}
+}
+
+func TestLinkLimitZero(t *testing.T) {
+	f := NewLinkLimit(0, 0)
+	v, _ := f.Check(context.Background(), &Content{Kind: KindComment, Text: "see https://a.example"})
+	if v.Decision != Reject {
+		t.Errorf("got %s, want a link rejected when none are allowed", v.Decision)
+	}
+}
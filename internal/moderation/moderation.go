@@ -0,0 +1,89 @@
+// Package moderation screens posts and comments before they are saved. A
+// Pipeline runs a series of ContentFilters, each of which can let the content
+// through, reject it, or hold it for a moderator to review.
+package moderation
+
+import (
+	"context"
+	"log"
+)
+
+const (
+	KindPost    = "post"
+	KindComment = "comment"
+)
+
+// Decision is what a filter wants done with content. Higher decisions win.
+type Decision int
+
+const (
+	Allow Decision = iota
+	Hold
+	Reject
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Hold:
+		return "hold"
+	case Reject:
+		return "reject"
+	default:
+		return "allow"
+	}
+}
+
+// Content is a post or comment about to be saved.
+type Content struct {
+	Kind string
+	// ID is 0 for new content.
+	ID     int64
+	UserID int64
+	// Text is the comment, or the title and content of a post.
+	Text string
+}
+
+// Verdict is the decision of a filter and why it was made.
+type Verdict struct {
+	Decision Decision
+	Filter   string
+	// Category is the report reason held content is filed under.
+	Category string
+	Reason   string
+}
+
+type ContentFilter interface {
+	Name() string
+	Check(context.Context, *Content) (Verdict, error)
+}
+
+// Pipeline runs filters in order. The first rejection ends the run,
+// otherwise the first hold is the verdict.
+type Pipeline struct {
+	filters []ContentFilter
+}
+
+func NewPipeline(filters ...ContentFilter) *Pipeline {
+	return &Pipeline{filters: filters}
+}
+
+// Check returns the verdict on the content. Filters that fail are skipped,
+// so an outage of whatever they depend on doesn't stop people from posting.
+func (p *Pipeline) Check(ctx context.Context, c *Content) Verdict {
+	verdict := Verdict{Decision: Allow}
+	for _, f := range p.filters {
+		v, err := f.Check(ctx, c)
+		if err != nil {
+			log.Printf("moderation: %s filter: %v\n", f.Name(), err)
+			continue
+		}
+		if v.Decision > verdict.Decision {
+			v.Filter = f.Name()
+			verdict = v
+		}
+		if verdict.Decision == Reject {
+			break
+		}
+	}
+	return verdict
+}
@@ -0,0 +1,54 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type stubFilter struct {
+	name     string
+	decision Decision
+	err      error
+	calls    int
+}
+
+func (f *stubFilter) Name() string {
+	return f.name
+}
+
+func (f *stubFilter) Check(context.Context, *Content) (Verdict, error) {
+	f.calls++
+	return Verdict{Decision: f.decision, Reason: f.name}, f.err
+}
+
+func TestPipeline(t *testing.T) {
+	allow := &stubFilter{name: "allow"}
+	failing := &stubFilter{name: "failing", decision: Reject, err: errors.New("down")}
+	hold := &stubFilter{name: "hold", decision: Hold}
+	laterHold := &stubFilter{name: "later hold", decision: Hold}
+	reject := &stubFilter{name: "reject", decision: Reject}
+	after := &stubFilter{name: "after"}
+
+	v := NewPipeline(allow, failing, hold, laterHold, reject, after).Check(context.Background(), &Content{})
+	if v.Decision != Reject || v.Filter != "reject" {
+		t.Errorf("got %+v, want the rejection", v)
+	}
+	if after.calls != 0 {
+		t.Error("filters ran after a rejection")
+	}
+
+	v = NewPipeline(allow, failing, hold, laterHold).Check(context.Background(), &Content{})
+	if v.Decision != Hold || v.Filter != "hold" {
+		t.Errorf("got %+v, want the first hold", v)
+	}
+
+	v = NewPipeline(allow, failing).Check(context.Background(), &Content{})
+	if v.Decision != Allow {
+		t.Errorf("got %+v, want failing filters skipped", v)
+	}
+
+	if v := NewPipeline().Check(context.Background(), &Content{}); v.Decision != Allow {
+		t.Errorf("got %+v from an empty pipeline", v)
+	}
+}
@@ -0,0 +1,98 @@
+package moderation
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables maps letters that look like Latin ones to them. Compatibility
+// decomposition already takes care of fullwidth and styled letters.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i', 'ї': 'i',
+	'ј': 'j', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ɡ': 'g',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+	// Latin letters without a decomposition
+	'ı': 'i', 'ł': 'l', 'ø': 'o', 'đ': 'd', 'ħ': 'h', 'ŧ': 't', 'ß': 's',
+}
+
+// leet maps digits and symbols used in place of letters to them.
+var leet = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l',
+}
+
+// Normalize folds text to lowercase Latin letters where it can: accents,
+// invisible characters and compatibility forms are removed and lookalike
+// letters of other scripts replaced, so "Ｆｒｅе" (with a Cyrillic е) and
+// "free" read the same.
+func Normalize(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, r := range norm.NFKD.String(text) {
+		switch {
+		// combining accents and zero-width characters
+		case unicode.Is(unicode.Mn, r), unicode.Is(unicode.Cf, r):
+			continue
+		}
+		r = unicode.ToLower(r)
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Tokens splits normalized text into words.
+func Tokens(text string) []string {
+	return strings.FieldsFunc(Normalize(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// evasionTokens splits text into words the way someone dodging a word list
+// would write them: digits and symbols standing in for letters are read as
+// letters, as in "fr33" or "$pam", and letters spelled out one at a time, as
+// in "f.r.e.e" or "f r e e", are joined into a word.
+func evasionTokens(text string) []string {
+	runes := []rune(Normalize(text))
+	isWord := func(i int) bool {
+		return i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsNumber(runes[i]))
+	}
+
+	folded := make([]rune, len(runes))
+	for i, r := range runes {
+		folded[i] = r
+		// Symbols only stand for letters in front of one, so "wow!" stays
+		// "wow"
+		if l, ok := leet[r]; ok && (unicode.IsNumber(r) || isWord(i+1)) {
+			folded[i] = l
+		}
+	}
+
+	var tokens []string
+	var spelled []rune
+	for _, word := range strings.FieldsFunc(string(folded), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if len([]rune(word)) == 1 {
+			spelled = append(spelled, []rune(word)...)
+			continue
+		}
+		if len(spelled) > 0 {
+			tokens = append(tokens, string(spelled))
+			spelled = spelled[:0]
+		}
+		tokens = append(tokens, word)
+	}
+	if len(spelled) > 0 {
+		tokens = append(tokens, string(spelled))
+	}
+	return tokens
+}
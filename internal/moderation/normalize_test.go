@@ -0,0 +1,86 @@
+package moderation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"ascii", "Free", "free"},
+		{"fullwidth with a cyrillic e", "Ｆｒｅе", "free"},
+		{"fullwidth", "ＦＲＥＥ", "free"},
+		{"mathematical bold", "𝐟𝐫𝐞𝐞", "free"},
+		{"ligature", "ﬁne", "fine"},
+		{"accents", "Café crème", "cafe creme"},
+		{"precomposed and decomposed", "é é", "e e"},
+		{"zero width space", "fr​ee", "free"},
+		{"zero width joiner", "fr‍ee", "free"},
+		{"soft hyphen", "fr­ee", "free"},
+		{"cyrillic lookalikes", "рауpаl", "paypal"},
+		{"cyrillic dze", "Ѕрам", "spam"},
+		{"greek lookalikes", "οκ", "ok"},
+		{"letters without a decomposition", "Łódź ø đ ß", "lodz o d s"},
+		{"dotted capital i", "İ", "i"},
+		{"dotless i", "ı", "i"},
+		{"digits and symbols are kept", "fr33 $pam!", "fr33 $pam!"},
+		{"other scripts are kept", "日本語", "日本語"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.in); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokens(t *testing.T) {
+	got := Tokens("Hello, Wörld! It's 42…")
+	want := []string{"hello", "world", "it", "s", "42"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEvasionTokens(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"fr33", []string{"free"}},
+		{"h3ll0", []string{"hello"}},
+		{"$pam", []string{"spam"}},
+		{"@dmin", []string{"admin"}},
+		{"fr!end", []string{"friend"}},
+		// Symbols stand for letters only in front of one
+		{"wow!", []string{"wow"}},
+		{"wow !!", []string{"wow"}},
+		{"user @ home", []string{"user", "home"}},
+		{"cost $ 5", []string{"cost", "s"}},
+		// Digits always do
+		{"100", []string{"ioo"}},
+		{"route 66", []string{"route", "66"}},
+		// Letters spelled out are joined, whatever separates them
+		{"f.r.e.e", []string{"free"}},
+		{"f r e e", []string{"free"}},
+		{"f-r-e-e money", []string{"free", "money"}},
+		{"f r 3 3", []string{"free"}},
+		{"this is s p a m", []string{"this", "is", "spam"}},
+		// Words in between end a run of single letters
+		{"a spa meeting", []string{"a", "spa", "meeting"}},
+		{"a b big c d", []string{"ab", "big", "cd"}},
+		{"Ｆ Ｒ Ｅ Е", []string{"free"}},
+		{"", nil},
+		{"!!! ...", nil},
+	}
+	for _, tt := range tests {
+		if got := evasionTokens(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("evasionTokens(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package moderation
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// BannedWords matches content against a list of words and phrases, however
+// they are disguised with lookalike letters, accents, symbols or spacing.
+type BannedWords struct {
+	decision Decision
+	// phrases are the normalized entries, each a sequence of words.
+	phrases [][]string
+}
+
+// NewBannedWords returns a filter that takes the decision on content with
+// any of the entries. Entries of several words match those words in a row.
+func NewBannedWords(entries []string, decision Decision) *BannedWords {
+	f := &BannedWords{decision: decision}
+	for _, e := range entries {
+		if words := Tokens(e); len(words) > 0 {
+			f.phrases = append(f.phrases, words)
+		}
+	}
+	return f
+}
+
+func (f *BannedWords) Name() string {
+	return "banned_words"
+}
+
+func (f *BannedWords) Check(_ context.Context, c *Content) (Verdict, error) {
+	if len(f.phrases) == 0 {
+		return Verdict{}, nil
+	}
+
+	for _, tokens := range [][]string{Tokens(c.Text), evasionTokens(c.Text)} {
+		for _, phrase := range f.phrases {
+			if containsPhrase(tokens, phrase) {
+				return Verdict{
+					Decision: f.decision,
+					Category: "other",
+					Reason:   "contains a word that is not allowed: " + strings.Join(phrase, " "),
+				}, nil
+			}
+		}
+	}
+	return Verdict{}, nil
+}
+
+func containsPhrase(tokens, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		if slices.Equal(tokens[i:i+len(phrase)], phrase) {
+			return true
+		}
+	}
+	return false
+}
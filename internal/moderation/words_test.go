@@ -0,0 +1,66 @@
+package moderation
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestBannedWords(t *testing.T) {
+	f := NewBannedWords([]string{"free money", "Spam", "  !! "}, Reject)
+
+	tests := []struct {
+		text  string
+		match bool
+	}{
+		{"get FREE money now", true},
+		{"Free, money!", true},
+		{"fr33 m0ney", true},
+		{"f r e e money", true},
+		{"Ｆｒｅе money", true},
+		{"spam", true},
+		{"sp4m", true},
+		{"$pam", true},
+		{"s.p.a.m", true},
+		{"Ѕрам", true},
+		{"this is s p a m", true},
+		{"spa​m", true},
+
+		{"free time, money later", false},
+		{"money free", false},
+		{"spammer", false},
+		{"a spa meeting", false},
+		{"wow!", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		v, err := f.Check(context.Background(), &Content{Kind: KindPost, Text: tt.text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := v.Decision == Reject; got != tt.match {
+			t.Errorf("%q: got %s, want a match %v", tt.text, v.Decision, tt.match)
+		}
+		if tt.match && (v.Category != "other" || v.Reason == "") {
+			t.Errorf("%q: got %+v", tt.text, v)
+		}
+	}
+}
+
+func TestBannedWordsReason(t *testing.T) {
+	f := NewBannedWords([]string{"Free  Money"}, Hold)
+	v, err := f.Check(context.Background(), &Content{Text: "fr33 m0ney"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Decision != Hold || !strings.HasSuffix(v.Reason, ": free money") {
+		t.Errorf("got %+v, want a hold naming the normalized phrase", v)
+	}
+}
+
+func TestBannedWordsEmpty(t *testing.T) {
+	f := NewBannedWords(nil, Reject)
+	if v, _ := f.Check(context.Background(), &Content{Text: "anything"}); v.Decision != Allow {
+		t.Errorf("got %s with no words", v.Decision)
+	}
+}
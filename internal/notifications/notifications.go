@@ -0,0 +1,324 @@
+// Package notifications keeps the inbox of each user: who followed them,
+// commented on their posts or mentioned them, and how moderators handled
+// reports they made or that were made about them.
+//
+// Events of the same kind about the same thing are grouped while unread, so
+// five new followers make one "alice and 4 others followed you". A group that
+// gets a new actor moves to the top of the inbox with a new ID, which keeps
+// IDs in the order users see them and lets them mark everything up to an ID
+// as read.
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nati3514/Social/internal/realtime"
+)
+
+const (
+	TypeFollow  = "follow"
+	TypeComment = "comment"
+	TypeMention = "mention"
+	// TypeReport tells a reporter how their report was resolved.
+	TypeReport = "report"
+	// TypeWarning tells a user they were warned over a report.
+	TypeWarning = "warning"
+)
+
+// maxActors is how many of the actors of a group are listed by name.
+const maxActors = 3
+
+// QueryTimeout bounds every query of the package.
+var QueryTimeout = 5 * time.Second
+
+// Event is something that happened to UserID, done by ActorID. Moderation
+// events have no actor, so that moderators stay anonymous.
+type Event struct {
+	UserID    int64
+	ActorID   int64
+	Type      string
+	PostID    *int64
+	CommentID *int64
+	ReportID  *int64
+}
+
+// groupKey is what events that are grouped together share.
+func (e Event) groupKey() (string, error) {
+	switch {
+	case e.Type == TypeFollow:
+		return TypeFollow, nil
+	case e.Type == TypeComment && e.PostID != nil:
+		return fmt.Sprintf("comment:post:%d", *e.PostID), nil
+	case e.Type == TypeMention && e.CommentID != nil:
+		return fmt.Sprintf("mention:comment:%d", *e.CommentID), nil
+	case e.Type == TypeMention && e.PostID != nil:
+		return fmt.Sprintf("mention:post:%d", *e.PostID), nil
+	case (e.Type == TypeReport || e.Type == TypeWarning) && e.ReportID != nil:
+		return fmt.Sprintf("%s:%d", e.Type, *e.ReportID), nil
+	default:
+		return "", fmt.Errorf("notifications: invalid %s event", e.Type)
+	}
+}
+
+// Add records the event in the inbox of its user, within the transaction of
+// whatever caused it. Users are not notified of their own actions, nor of
+// those of users they muted.
+func Add(ctx context.Context, tx *sql.Tx, e Event) error {
+	if e.UserID == e.ActorID {
+		return nil
+	}
+
+	key, err := e.groupKey()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO notifications (user_id, type, group_key, post_id, comment_id, report_id, actor_ids)
+		SELECT $1::bigint, $2::varchar, $3::varchar, $4::bigint, $5::bigint, $7::bigint,
+		       CASE WHEN $6::bigint = 0 THEN '{}'::bigint[] ELSE ARRAY[$6::bigint] END
+		WHERE NOT EXISTS (SELECT 1 FROM mutes WHERE muter_id = $1 AND muted_id = $6)
+		ON CONFLICT (user_id, group_key) WHERE read_at IS NULL DO UPDATE
+		SET id = nextval('notifications_id_seq'),
+		    actor_ids = array_prepend($6::bigint, array_remove(notifications.actor_ids, $6::bigint)),
+		    comment_id = EXCLUDED.comment_id,
+		    updated_at = NOW()
+		RETURNING id
+	`
+
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	var id int64
+	err = tx.QueryRowContext(qctx, query, e.UserID, e.Type, key, e.PostID, e.CommentID, e.ActorID, e.ReportID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	// Connected clients get the notification as it now reads
+	rows, err := tx.QueryContext(qctx, selectNotifications+`WHERE n.id = $1`, id)
+	if err != nil {
+		return err
+	}
+	list, err := scanNotifications(rows)
+	if err != nil || len(list) == 0 {
+		return err
+	}
+
+	msg, err := realtime.NewMessage(realtime.EventNotification, list[0])
+	if err != nil {
+		return err
+	}
+	msg.UserID = e.UserID
+	return realtime.Publish(ctx, tx, msg)
+}
+
+type Actor struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type Notification struct {
+	ID        int64  `json:"id"`
+	Type      string `json:"type"`
+	PostID    *int64 `json:"post_id,omitempty"`
+	CommentID *int64 `json:"comment_id,omitempty"`
+	ReportID  *int64 `json:"report_id,omitempty"`
+	// Actors are the most recent actors of the group, ActorCount all of them.
+	Actors     []Actor    `json:"actors"`
+	ActorCount int        `json:"actor_count"`
+	Message    string     `json:"message"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ReadAt     *time.Time `json:"read_at,omitempty"`
+
+	// What the report of report and warning notifications was about, and
+	// how it was resolved
+	reportTarget string
+	reportReason string
+	reportAction string
+}
+
+// message describes the notification, like "alice and 3 others followed you".
+func (n *Notification) message() string {
+	who := "Someone"
+	if len(n.Actors) > 0 {
+		who = n.Actors[0].Username
+	}
+	switch others := n.ActorCount - 1; {
+	case others == 1:
+		who += " and 1 other"
+	case others > 1:
+		who += fmt.Sprintf(" and %d others", others)
+	}
+
+	switch n.Type {
+	case TypeFollow:
+		return who + " followed you"
+	case TypeComment:
+		return who + " commented on your post"
+	case TypeMention:
+		if n.CommentID != nil {
+			return who + " mentioned you in a comment"
+		}
+		return who + " mentioned you in a post"
+	case TypeReport:
+		author := "The author of the " + n.reportTarget + " you reported"
+		if n.reportTarget == "user" {
+			author = "The user you reported"
+		}
+		switch n.reportAction {
+		case "dismiss":
+			return fmt.Sprintf("We reviewed the %s you reported and found no violation", n.reportTarget)
+		case "hide":
+			return fmt.Sprintf("The %s you reported was removed", n.reportTarget)
+		case "warn":
+			return author + " was warned"
+		case "suspend":
+			return author + " was suspended"
+		default:
+			return "Your report was reviewed"
+		}
+	case TypeWarning:
+		if n.reportTarget == "user" {
+			return fmt.Sprintf("You were warned over your profile for %s", n.reportReason)
+		}
+		return fmt.Sprintf("You were warned over your %s for %s", n.reportTarget, n.reportReason)
+	default:
+		return who + " did something"
+	}
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// selectNotifications selects notifications with the names of their first
+// maxActors actors and the outcome of their report, for a WHERE clause to be
+// appended.
+var selectNotifications = `
+	SELECT n.id, n.type, n.post_id, n.comment_id, n.report_id, cardinality(n.actor_ids),
+	       n.created_at, n.updated_at, n.read_at,
+	       ARRAY(SELECT u.id FROM unnest(n.actor_ids[1:` + strconv.Itoa(maxActors) + `]) WITH ORDINALITY a(id, i)
+	             JOIN users u ON u.id = a.id ORDER BY a.i),
+	       ARRAY(SELECT u.username FROM unnest(n.actor_ids[1:` + strconv.Itoa(maxActors) + `]) WITH ORDINALITY a(id, i)
+	             JOIN users u ON u.id = a.id ORDER BY a.i),
+	       COALESCE(r.target_type, ''), COALESCE(r.reason, ''), COALESCE(r.action, '')
+	FROM notifications n
+	LEFT JOIN reports r ON r.id = n.report_id
+`
+
+// List returns up to limit notifications of the user older than the one with
+// ID before, newest first. A before of 0 starts at the newest. Groups made up
+// only of users muted since are left out.
+func (s *Store) List(ctx context.Context, userID, before int64, limit int) ([]Notification, error) {
+	query := selectNotifications + `
+		WHERE n.user_id = $1 AND ($2 = 0 OR n.id < $2)
+		  AND (cardinality(n.actor_ids) = 0 OR NOT n.actor_ids <@ ARRAY(SELECT muted_id FROM mutes WHERE muter_id = $1))
+		ORDER BY n.id DESC
+		LIMIT $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanNotifications(rows)
+}
+
+func scanNotifications(rows *sql.Rows) ([]Notification, error) {
+	defer rows.Close()
+
+	list := []Notification{}
+	for rows.Next() {
+		var n Notification
+		var ids []int64
+		var usernames []string
+		if err := rows.Scan(
+			&n.ID,
+			&n.Type,
+			&n.PostID,
+			&n.CommentID,
+			&n.ReportID,
+			&n.ActorCount,
+			&n.CreatedAt,
+			&n.UpdatedAt,
+			&n.ReadAt,
+			pq.Array(&ids),
+			pq.Array(&usernames),
+			&n.reportTarget,
+			&n.reportReason,
+			&n.reportAction,
+		); err != nil {
+			return nil, err
+		}
+
+		n.Actors = make([]Actor, len(ids))
+		for i := range ids {
+			n.Actors[i] = Actor{ID: ids[i], Username: usernames[i]}
+		}
+		n.Message = n.message()
+		list = append(list, n)
+	}
+	return list, rows.Err()
+}
+
+func (s *Store) UnreadCount(ctx context.Context, userID int64) (int, error) {
+	query := `SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// MarkRead marks the notifications of the user up to and including the one
+// with ID upTo as read, and returns how many there were.
+func (s *Store) MarkRead(ctx context.Context, userID, upTo int64) (int64, error) {
+	query := `
+		UPDATE notifications SET read_at = NOW()
+		WHERE user_id = $1 AND id <= $2 AND read_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, upTo)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteReadBefore removes notifications that were read before cutoff.
+func (s *Store) DeleteReadBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `DELETE FROM notifications WHERE read_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
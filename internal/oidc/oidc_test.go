@@ -0,0 +1,488 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "social-api"
+	testClientSecret = "s3cr:t/+&="
+	testRedirectURL  = "https://social.example/v1/authentication/oidc/test/callback"
+)
+
+var testNow = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+type signingKey struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+func newRSAKey(t *testing.T, kid string) signingKey {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKey{kid: kid, alg: "RS256", key: k}
+}
+
+func newECKey(t *testing.T, kid string) signingKey {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKey{kid: kid, alg: "ES256", key: k}
+}
+
+func (k signingKey) jwk() jwk {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch pub := k.key.Public().(type) {
+	case *rsa.PublicKey:
+		return jwk{Kty: "RSA", Kid: k.kid, Use: "sig", Alg: k.alg, N: enc(pub.N.Bytes()), E: enc(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return jwk{Kty: "EC", Kid: k.kid, Use: "sig", Alg: k.alg, Crv: "P-256", X: enc(pub.X.FillBytes(make([]byte, 32))), Y: enc(pub.Y.FillBytes(make([]byte, 32)))}
+	}
+	panic("unsupported key")
+}
+
+// sign returns a JWT with the given claims, signed by k under alg.
+func (k signingKey) sign(t *testing.T, alg string, claims any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": k.kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+type authRequest struct {
+	challenge string
+	nonce     string
+}
+
+// testIssuer is a minimal OpenID provider serving discovery, a JWKS and a
+// token endpoint that enforces client authentication and PKCE.
+type testIssuer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	issuer      string
+	keys        []signingKey
+	signWith    signingKey
+	codes       map[string]authRequest
+	jwksFetches int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	iss := &testIssuer{t: t, codes: map[string]authRequest{}}
+	iss.signWith = newRSAKey(t, "rsa-1")
+	iss.keys = []signingKey{iss.signWith, newECKey(t, "ec-1")}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", iss.discovery)
+	mux.HandleFunc("/jwks", iss.jwks)
+	mux.HandleFunc("/token", iss.token)
+	iss.srv = httptest.NewServer(mux)
+	t.Cleanup(iss.srv.Close)
+
+	iss.issuer = iss.srv.URL
+	return iss
+}
+
+func (iss *testIssuer) provider() *Provider {
+	p := NewProvider(Config{
+		Name:         "test",
+		Issuer:       iss.srv.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	}, iss.srv.Client())
+	p.Now = func() time.Time { return testNow }
+	return p
+}
+
+func (iss *testIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	json.NewEncoder(w).Encode(metadata{
+		Issuer:                iss.issuer,
+		AuthorizationEndpoint: iss.srv.URL + "/authorize?prompt=login",
+		TokenEndpoint:         iss.srv.URL + "/token",
+		JWKSURI:               iss.srv.URL + "/jwks",
+	})
+}
+
+func (iss *testIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.jwksFetches++
+
+	keys := []jwk{
+		// Ignored, encryption keys can't verify tokens
+		{Kty: "RSA", Kid: "enc-1", Use: "enc", N: "AQAB", E: "AQAB"},
+	}
+	for _, k := range iss.keys {
+		keys = append(keys, k.jwk())
+	}
+	json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+}
+
+// authorize plays the user's visit to the authorization endpoint and returns
+// the code the provider would redirect back with.
+func (iss *testIssuer) authorize(authURL string) string {
+	iss.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		iss.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != testClientID || q.Get("redirect_uri") != testRedirectURL || q.Get("response_type") != "code" {
+		iss.t.Fatalf("bad authorization request %s", authURL)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		iss.t.Fatalf("authorization request without PKCE: %s", authURL)
+	}
+
+	code, _ := RandomString()
+	iss.mu.Lock()
+	iss.codes[code] = authRequest{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	iss.mu.Unlock()
+	return code
+}
+
+func (iss *testIssuer) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(status int, code string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	if r.Method != http.MethodPost {
+		tokenError(http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+
+	// Client credentials are form-encoded before being put in basic auth
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	}
+	if !ok || id != testClientID || secret != testClientSecret {
+		tokenError(http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != testRedirectURL {
+		tokenError(http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	iss.mu.Lock()
+	req, ok := iss.codes[r.PostForm.Get("code")]
+	delete(iss.codes, r.PostForm.Get("code"))
+	key := iss.signWith
+	iss.mu.Unlock()
+
+	if !ok || CodeChallenge(r.PostForm.Get("code_verifier")) != req.challenge {
+		tokenError(http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	idToken := key.sign(iss.t, key.alg, iss.claims(req.nonce))
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "at",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func (iss *testIssuer) claims(nonce string) map[string]any {
+	return map[string]any{
+		"iss":                iss.srv.URL,
+		"sub":                "user-1",
+		"aud":                testClientID,
+		"exp":                testNow.Add(time.Hour).Unix(),
+		"iat":                testNow.Unix(),
+		"nonce":              nonce,
+		"email":              "ada@example.com",
+		"email_verified":     true,
+		"preferred_username": "ada",
+	}
+}
+
+func TestLoginFlow(t *testing.T) {
+	iss := newTestIssuer(t)
+	p := iss.provider()
+	ctx := context.Background()
+
+	nonce, _ := RandomString()
+	verifier, _ := RandomString()
+
+	authURL, err := p.AuthCodeURL(ctx, "state-1", nonce, CodeChallenge(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	if u.Path != "/authorize" || u.Query().Get("prompt") != "login" {
+		t.Errorf("existing query of the authorization endpoint was lost: %s", authURL)
+	}
+	q := u.Query()
+	if q.Get("state") != "state-1" || q.Get("nonce") != nonce || q.Get("scope") != "openid email profile" {
+		t.Errorf("unexpected parameters %v", q)
+	}
+	if q.Get("code_challenge") == verifier {
+		t.Error("the verifier was sent in the authorization request")
+	}
+
+	code := iss.authorize(authURL)
+
+	raw, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.Verify(ctx, raw, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "ada@example.com" || !claims.EmailVerified || claims.Username != "ada" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	// Codes are single use
+	if _, err := p.Exchange(ctx, code, verifier); err == nil {
+		t.Error("a used code was exchanged again")
+	}
+}
+
+func TestExchangeRequiresVerifier(t *testing.T) {
+	iss := newTestIssuer(t)
+	p := iss.provider()
+	ctx := context.Background()
+
+	verifier, _ := RandomString()
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", CodeChallenge(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An intercepted code is useless without the verifier
+	code := iss.authorize(authURL)
+	_, err = p.Exchange(ctx, code, "someone-elses-verifier")
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("got %v, want invalid_grant", err)
+	}
+}
+
+func TestExchangeClientAuthentication(t *testing.T) {
+	iss := newTestIssuer(t)
+	p := iss.provider()
+	p.config.ClientSecret = "wrong"
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", CodeChallenge("verifier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := iss.authorize(authURL)
+	_, err = p.Exchange(ctx, code, "verifier")
+	if err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("got %v, want invalid_client", err)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	iss := newTestIssuer(t)
+	iss.issuer = "https://evil.example"
+	p := iss.provider()
+
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "challenge"); err == nil {
+		t.Error("discovery accepted a document for another issuer")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	iss := newTestIssuer(t)
+	rsaKey, ecKey := iss.keys[0], iss.keys[1]
+	other := newRSAKey(t, rsaKey.kid)
+
+	with := func(changes map[string]any) map[string]any {
+		c := iss.claims("nonce-1")
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tampered := func() string {
+		parts := strings.Split(rsaKey.sign(t, "RS256", with(nil)), ".")
+		payload, _ := json.Marshal(with(map[string]any{"sub": "admin"}))
+		parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+		return strings.Join(parts, ".")
+	}
+
+	unsigned := func() string {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rsa-1"}`))
+		payload, _ := json.Marshal(with(nil))
+		return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"rsa", rsaKey.sign(t, "RS256", with(nil)), true},
+		{"ec", ecKey.sign(t, "ES256", with(nil)), true},
+		{"audience array", rsaKey.sign(t, "RS256", with(map[string]any{"aud": []string{testClientID}})), true},
+		{"several audiences with azp", rsaKey.sign(t, "RS256", with(map[string]any{"aud": []string{"other", testClientID}, "azp": testClientID})), true},
+		{"expired within skew", rsaKey.sign(t, "RS256", with(map[string]any{"exp": testNow.Add(-30 * time.Second).Unix()})), true},
+		{"email_verified string", rsaKey.sign(t, "RS256", with(map[string]any{"email_verified": "true"})), true},
+
+		{"signed by another key", other.sign(t, "RS256", with(nil)), false},
+		{"tampered payload", tampered(), false},
+		{"alg none", unsigned(), false},
+		{"rsa alg with ec key", ecKey.sign(t, "RS256", with(nil)), false},
+		{"hmac alg", rsaKey.sign(t, "HS256", with(nil)), false},
+		{"wrong audience", rsaKey.sign(t, "RS256", with(map[string]any{"aud": "other-client"})), false},
+		{"several audiences without azp", rsaKey.sign(t, "RS256", with(map[string]any{"aud": []string{"other", testClientID}})), false},
+		{"wrong issuer", rsaKey.sign(t, "RS256", with(map[string]any{"iss": "https://evil.example"})), false},
+		{"no subject", rsaKey.sign(t, "RS256", with(map[string]any{"sub": nil})), false},
+		{"expired", rsaKey.sign(t, "RS256", with(map[string]any{"exp": testNow.Add(-2 * time.Minute).Unix()})), false},
+		{"no expiry", rsaKey.sign(t, "RS256", with(map[string]any{"exp": nil})), false},
+		{"issued in the future", rsaKey.sign(t, "RS256", with(map[string]any{"iat": testNow.Add(10 * time.Minute).Unix()})), false},
+		{"nonce mismatch", rsaKey.sign(t, "RS256", with(map[string]any{"nonce": "nonce-2"})), false},
+		{"no nonce", rsaKey.sign(t, "RS256", with(map[string]any{"nonce": nil})), false},
+		{"malformed", "not.a.jwt", false},
+		{"two parts", "a.b", false},
+	}
+
+	p := iss.provider()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := p.Verify(context.Background(), tt.token, "nonce-1")
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("got %v, want a valid token", err)
+				}
+				if claims.Subject != "user-1" {
+					t.Errorf("got subject %q", claims.Subject)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifyEmptyNonce(t *testing.T) {
+	iss := newTestIssuer(t)
+	token := iss.keys[0].sign(t, "RS256", iss.claims(""))
+
+	// A token without a nonce can't be matched to a login request
+	if _, err := iss.provider().Verify(context.Background(), token, ""); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	iss := newTestIssuer(t)
+	p := iss.provider()
+	ctx := context.Background()
+
+	old := iss.keys[0]
+	if _, err := p.Verify(ctx, old.sign(t, "RS256", iss.claims("n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := newRSAKey(t, "rsa-2")
+	iss.mu.Lock()
+	iss.keys = []signingKey{rotated}
+	iss.mu.Unlock()
+	token := rotated.sign(t, "RS256", iss.claims("n"))
+
+	// Unknown key IDs don't refetch the set more than once a minute
+	if _, err := p.Verify(ctx, token, "n"); err == nil {
+		t.Fatal("a token with an unknown key was accepted")
+	}
+	if iss.jwksFetches != 1 {
+		t.Errorf("got %d JWKS fetches, want 1", iss.jwksFetches)
+	}
+
+	p.keys.mu.Lock()
+	p.keys.lastRefresh = p.keys.lastRefresh.Add(-minRefreshInterval)
+	p.keys.mu.Unlock()
+
+	if _, err := p.Verify(ctx, token, "n"); err != nil {
+		t.Fatalf("rotated key was not picked up: %v", err)
+	}
+	if iss.jwksFetches != 2 {
+		t.Errorf("got %d JWKS fetches, want 2", iss.jwksFetches)
+	}
+
+	// The retired key went with the refresh
+	if _, err := p.Verify(ctx, old.sign(t, "RS256", iss.claims("n")), "n"); err == nil {
+		t.Error("a token signed with a retired key was accepted")
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// BASE64URL(SHA256(verifier)) without padding
+	if got, want := CodeChallenge("dBjftJeZ4CVP-mB92K1uh9bQvBY8Y3Gr7nNcPzXgULA"), "ryDzp7Dtx0xFQg1dm8CNYylrICmH_NutpCsByyrBm1s"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	a, _ := RandomString()
+	b, _ := RandomString()
+	if a == b || len(a) != 43 {
+		t.Errorf("RandomString returned %q and %q", a, b)
+	}
+}
@@ -0,0 +1,65 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// FixedWindowRateLimiter allows limit requests per key in each window. State
+// is kept in memory, so every API instance enforces its own limit.
+type FixedWindowRateLimiter struct {
+	sync.Mutex
+	clients map[string]*window
+	limit   int
+	window  time.Duration
+}
+
+func NewFixedWindowLimiter(limit int, w time.Duration) *FixedWindowRateLimiter {
+	rl := &FixedWindowRateLimiter{
+		clients: make(map[string]*window),
+		limit:   limit,
+		window:  w,
+	}
+	go rl.cleanup()
+	return rl
+}
+
+func (rl *FixedWindowRateLimiter) Allow(key string) (bool, time.Duration) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	now := time.Now()
+	c, ok := rl.clients[key]
+	if !ok || now.Sub(c.start) >= rl.window {
+		rl.clients[key] = &window{start: now, count: 1}
+		return true, 0
+	}
+
+	if c.count < rl.limit {
+		c.count++
+		return true, 0
+	}
+
+	return false, c.start.Add(rl.window).Sub(now)
+}
+
+func (rl *FixedWindowRateLimiter) cleanup() {
+	ticker := time.NewTicker(rl.window)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		rl.Lock()
+		now := time.Now()
+		for key, c := range rl.clients {
+			if now.Sub(c.start) >= rl.window {
+				delete(rl.clients, key)
+			}
+		}
+		rl.Unlock()
+	}
+}
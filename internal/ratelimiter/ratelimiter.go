@@ -0,0 +1,13 @@
+package ratelimiter
+
+import "time"
+
+type Limiter interface {
+	Allow(key string) (bool, time.Duration)
+}
+
+type Config struct {
+	RequestsPerTimeFrame int
+	TimeFrame            time.Duration
+	Enabled              bool
+}
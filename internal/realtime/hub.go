@@ -0,0 +1,254 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// sendBufferSize is how many events may wait for a client. A client that
+	// falls further behind is disconnected instead of slowing others down.
+	sendBufferSize = 64
+
+	listenerPingInterval = 90 * time.Second
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+)
+
+// FollowerFilter returns which of candidates follow the user.
+type FollowerFilter func(ctx context.Context, userID int64, candidates []int64) ([]int64, error)
+
+// Hub tracks the connections of the users on this instance and delivers
+// events to them.
+type Hub struct {
+	followers FollowerFilter
+
+	mu      sync.RWMutex
+	clients map[int64]map[*Client]struct{}
+	closed  bool
+}
+
+func NewHub(followers FollowerFilter) *Hub {
+	return &Hub{
+		followers: followers,
+		clients:   make(map[int64]map[*Client]struct{}),
+	}
+}
+
+// Client is one connection of a user. Events for it arrive on Send; Done is
+// closed when the hub drops it, with the reason in Err.
+type Client struct {
+	UserID int64
+
+	send chan []byte
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+func (c *Client) Send() <-chan []byte {
+	return c.send
+}
+
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err is why the hub dropped the client, once Done is closed.
+func (c *Client) Err() error {
+	return c.err
+}
+
+func (c *Client) drop(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+	})
+}
+
+// Register adds a connection of the user. Once the hub is shut down the
+// client is dropped straight away.
+func (h *Hub) Register(userID int64) *Client {
+	c := &Client{
+		UserID: userID,
+		send:   make(chan []byte, sendBufferSize),
+		done:   make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		c.drop(ErrShuttingDown)
+		return c
+	}
+	if h.clients[userID] == nil {
+		h.clients[userID] = make(map[*Client]struct{})
+	}
+	h.clients[userID][c] = struct{}{}
+	return c
+}
+
+// Unregister removes the connection. It is safe to call more than once.
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(c)
+}
+
+func (h *Hub) remove(c *Client) {
+	conns := h.clients[c.UserID]
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(h.clients, c.UserID)
+	}
+}
+
+// Run listens for messages published by any instance and delivers them until
+// ctx is cancelled, when the hub is shut down. The listener reconnects by
+// itself; events published while it is disconnected are lost.
+func (h *Hub) Run(ctx context.Context, dsn string) error {
+	listener := pq.NewListener(dsn, minReconnectInterval, maxReconnectInterval, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("realtime: listener: %v\n", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(Channel); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.Shutdown()
+			return nil
+		case n := <-listener.Notify:
+			// nil after a reconnect
+			if n == nil {
+				continue
+			}
+			var msg Message
+			if err := json.Unmarshal([]byte(n.Extra), &msg); err != nil {
+				log.Printf("realtime: decoding message: %v\n", err)
+				continue
+			}
+			h.Deliver(ctx, msg)
+		case <-ticker.C:
+			// Pinging notices a dead connection sooner than waiting for
+			// notifications that never come
+			go func() {
+				if err := listener.Ping(); err != nil {
+					log.Printf("realtime: pinging listener: %v\n", err)
+				}
+			}()
+		}
+	}
+}
+
+// Deliver hands the message to the recipients' connections on this instance.
+func (h *Hub) Deliver(ctx context.Context, msg Message) {
+	if msg.Disconnect {
+		h.Disconnect(msg.UserID)
+		return
+	}
+
+	payload, err := json.Marshal(msg.Event)
+	if err != nil {
+		log.Printf("realtime: encoding %s event: %v\n", msg.Event.Type, err)
+		return
+	}
+
+	var recipients []int64
+	switch {
+	case msg.UserID != 0:
+		recipients = []int64{msg.UserID}
+	case msg.FollowersOf != 0:
+		recipients, err = h.connectedFollowers(ctx, msg.FollowersOf)
+		if err != nil {
+			log.Printf("realtime: finding followers of %d: %v\n", msg.FollowersOf, err)
+			return
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, userID := range recipients {
+		for c := range h.clients[userID] {
+			select {
+			case c.send <- payload:
+			default:
+				c.drop(ErrSlowClient)
+				h.remove(c)
+			}
+		}
+	}
+}
+
+// Disconnect drops the user's connections on this instance with
+// ErrSignedOut.
+func (h *Hub) Disconnect(userID int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.clients[userID] {
+		c.drop(ErrSignedOut)
+		h.remove(c)
+	}
+}
+
+// connectedFollowers returns the followers of the user connected to this
+// instance, and the user themselves if they are.
+func (h *Hub) connectedFollowers(ctx context.Context, userID int64) ([]int64, error) {
+	h.mu.RLock()
+	candidates := make([]int64, 0, len(h.clients))
+	for id := range h.clients {
+		if id != userID {
+			candidates = append(candidates, id)
+		}
+	}
+	_, self := h.clients[userID]
+	h.mu.RUnlock()
+
+	var recipients []int64
+	if len(candidates) > 0 {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		var err error
+		recipients, err = h.followers(ctx, userID, candidates)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if self {
+		recipients = append(recipients, userID)
+	}
+	return recipients, nil
+}
+
+// Shutdown drops every client with ErrShuttingDown, and any that register
+// later. It is safe to call more than once.
+func (h *Hub) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, conns := range h.clients {
+		for c := range conns {
+			c.drop(ErrShuttingDown)
+			h.remove(c)
+		}
+	}
+}
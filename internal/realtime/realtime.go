@@ -0,0 +1,91 @@
+// Package realtime delivers events to connected clients as they happen.
+//
+// Events are published with Postgres NOTIFY from within the transaction that
+// caused them, so they are only sent once it commits. Every API instance
+// LISTENs on the channel and hands each event to the connections it holds
+// for the recipient, wherever the event was published.
+package realtime
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// Channel is the Postgres channel events are published on.
+const Channel = "realtime"
+
+// maxPayload is the most NOTIFY accepts, less a little for safety.
+const maxPayload = 7900
+
+// Event types.
+const (
+	EventNotification = "notification"
+	EventFeedPost     = "feed.post"
+	EventMessage      = "message"
+	EventMessageRead  = "message.read"
+)
+
+var (
+	// QueryTimeout bounds every query of the package.
+	QueryTimeout = 5 * time.Second
+
+	ErrSlowClient   = errors.New("realtime: client too slow")
+	ErrShuttingDown = errors.New("realtime: shutting down")
+	ErrSignedOut    = errors.New("realtime: signed out")
+)
+
+// Event is what clients receive, a type and its data.
+type Event struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Message is an event and who it is for: either the user UserID, or the
+// followers of FollowersOf and that user themselves, who see their own posts
+// in their feed too. A Disconnect message drops the connections of UserID
+// instead.
+type Message struct {
+	UserID      int64 `json:"user_id,omitempty"`
+	FollowersOf int64 `json:"followers_of,omitempty"`
+	Event       Event `json:"event"`
+	Disconnect  bool  `json:"disconnect,omitempty"`
+}
+
+// NewMessage builds a message of the given type with data encoded as JSON.
+func NewMessage(eventType string, data any) (Message, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{Event: Event{Type: eventType, Data: b}}, nil
+}
+
+// Publish sends the message when tx commits. Delivery is best effort: a
+// message too large for NOTIFY is logged and dropped rather than failing the
+// transaction, since clients can always catch up over the REST API.
+func Publish(ctx context.Context, tx *sql.Tx, msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxPayload {
+		log.Printf("realtime: dropping %s event of %d bytes\n", msg.Event.Type, len(payload))
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, Channel, string(payload))
+	return err
+}
+
+// PublishDisconnect drops every connection of the user, on any instance, when
+// tx commits. It is for when the user's sessions end.
+func PublishDisconnect(ctx context.Context, tx *sql.Tx, userID int64) error {
+	return Publish(ctx, tx, Message{UserID: userID, Disconnect: true})
+}
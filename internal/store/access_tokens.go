@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type AccessToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type AccessTokenStore struct {
+	db *sql.DB
+}
+
+func (s *AccessTokenStore) Create(ctx context.Context, t *AccessToken, token string) error {
+	query := `
+		INSERT INTO personal_access_tokens (user_id, name, token, scopes, expiry)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		t.UserID,
+		t.Name,
+		token,
+		pq.Array(t.Scopes),
+		t.ExpiresAt,
+	).Scan(
+		&t.ID,
+		&t.CreatedAt,
+	)
+}
+
+func (s *AccessTokenStore) GetByUser(ctx context.Context, userID int64) ([]AccessToken, error) {
+	query := `
+		SELECT id, user_id, name, scopes, expiry, last_used_at, revoked_at, created_at
+		FROM personal_access_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []AccessToken{}
+	for rows.Next() {
+		var t AccessToken
+		if err := rows.Scan(
+			&t.ID,
+			&t.UserID,
+			&t.Name,
+			pq.Array(&t.Scopes),
+			&t.ExpiresAt,
+			&t.LastUsedAt,
+			&t.RevokedAt,
+			&t.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *AccessTokenStore) Revoke(ctx context.Context, userID, tokenID int64) error {
+	query := `
+		UPDATE personal_access_tokens SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetUserByToken returns the owner and scopes of a valid token and records
+// that it was used.
+func (s *AccessTokenStore) GetUserByToken(ctx context.Context, token string) (*User, []string, error) {
+	query := `
+		WITH t AS (
+			UPDATE personal_access_tokens SET last_used_at = NOW()
+			WHERE token = $1 AND revoked_at IS NULL AND (expiry IS NULL OR expiry > $2)
+			RETURNING user_id, scopes
+		)
+		SELECT u.id, u.username, u.email, u.created_at, u.activated, u.totp_enabled, u.role, t.scopes
+		FROM t
+		JOIN users u ON u.id = t.user_id
+		WHERE u.suspended_at IS NULL
+	`
+	hash := sha256.Sum256([]byte(token))
+	hashToken := hex.EncodeToString(hash[:])
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	user := &User{}
+	var scopes []string
+	err := s.db.QueryRowContext(ctx, query, hashToken, time.Now()).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.CreatedAt,
+		&user.IsActive,
+		&user.TwoFactorEnabled,
+		&user.Role,
+		pq.Array(&scopes),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	return user, scopes, nil
+}
@@ -0,0 +1,191 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrBlocked is returned for interactions between users when one of them has
+// blocked the other.
+var ErrBlocked = errors.New("user is blocked")
+
+// RelatedUser is a user someone blocked or muted, and since when.
+type RelatedUser struct {
+	UserID   int64     `json:"user_id"`
+	Username string    `json:"username"`
+	Since    time.Time `json:"since"`
+}
+
+type BlockStore struct {
+	db *sql.DB
+}
+
+// Block blocks the user and removes the follows between the two of them in
+// both directions.
+func (s *BlockStore) Block(ctx context.Context, blockerID, blockedID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		query := `INSERT INTO blocks (blocker_id, blocked_id) VALUES ($1, $2)`
+		if _, err := tx.ExecContext(qctx, query, blockerID, blockedID); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrConflict
+			}
+			return err
+		}
+
+		query = `
+			DELETE FROM followers
+			WHERE (user_id = $1 AND follower_id = $2) OR (user_id = $2 AND follower_id = $1)
+		`
+		_, err := tx.ExecContext(qctx, query, blockerID, blockedID)
+		return err
+	})
+}
+
+func (s *BlockStore) Unblock(ctx context.Context, blockerID, blockedID int64) error {
+	query := `DELETE FROM blocks WHERE blocker_id = $1 AND blocked_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, blockerID, blockedID)
+	return err
+}
+
+// IsBlocked reports whether either of the users blocked the other.
+func (s *BlockStore) IsBlocked(ctx context.Context, userID, otherID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	var blocked bool
+	err := s.db.QueryRowContext(ctx, query, userID, otherID).Scan(&blocked)
+	return blocked, err
+}
+
+// BlockedByAny reports whether any of the others blocked the user.
+func (s *BlockStore) BlockedByAny(ctx context.Context, userID int64, otherIDs []int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE blocker_id = ANY($2) AND blocked_id = $1
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	var blocked bool
+	err := s.db.QueryRowContext(ctx, query, userID, pq.Array(otherIDs)).Scan(&blocked)
+	return blocked, err
+}
+
+// GetBlockers returns the set of users who blocked the user.
+func (s *BlockStore) GetBlockers(ctx context.Context, userID int64) (map[int64]bool, error) {
+	query := `SELECT blocker_id FROM blocks WHERE blocked_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blockers := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		blockers[id] = true
+	}
+	return blockers, rows.Err()
+}
+
+// GetBlocked returns the users the user blocked, the most recent first.
+func (s *BlockStore) GetBlocked(ctx context.Context, userID int64) ([]RelatedUser, error) {
+	return getRelated(ctx, s.db, `
+		SELECT u.id, u.username, b.created_at
+		FROM blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = $1
+		ORDER BY b.created_at DESC
+	`, userID)
+}
+
+type MuteStore struct {
+	db *sql.DB
+}
+
+// Mute hides the posts of the muted user from the muter's feed and stops
+// their notifications. The muted user can't tell.
+func (s *MuteStore) Mute(ctx context.Context, muterID, mutedID int64) error {
+	query := `INSERT INTO mutes (muter_id, muted_id) VALUES ($1, $2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, muterID, mutedID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+	}
+	return err
+}
+
+func (s *MuteStore) Unmute(ctx context.Context, muterID, mutedID int64) error {
+	query := `DELETE FROM mutes WHERE muter_id = $1 AND muted_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, muterID, mutedID)
+	return err
+}
+
+// GetMuted returns the users the user muted, the most recent first.
+func (s *MuteStore) GetMuted(ctx context.Context, userID int64) ([]RelatedUser, error) {
+	return getRelated(ctx, s.db, `
+		SELECT u.id, u.username, m.created_at
+		FROM mutes m
+		JOIN users u ON u.id = m.muted_id
+		WHERE m.muter_id = $1
+		ORDER BY m.created_at DESC
+	`, userID)
+}
+
+func getRelated(ctx context.Context, db *sql.DB, query string, userID int64) ([]RelatedUser, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []RelatedUser{}
+	for rows.Next() {
+		var u RelatedUser
+		if err := rows.Scan(&u.UserID, &u.Username, &u.Since); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
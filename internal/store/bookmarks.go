@@ -0,0 +1,268 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Bookmark is a post a user saved, privately, optionally into one of their
+// collections.
+type Bookmark struct {
+	ID           int64            `json:"id"`
+	CollectionID *int64           `json:"collection_id"`
+	CreatedAt    time.Time        `json:"created_at"`
+	Post         PostWithMetadata `json:"post"`
+}
+
+// BookmarkCollection is a named group of a user's bookmarks.
+type BookmarkCollection struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	Name      string    `json:"name"`
+	Bookmarks int       `json:"bookmarks"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BookmarkStore struct {
+	db       *sql.DB
+	rendered *renderCache
+}
+
+// Save bookmarks the post into the collection, or none if it is nil. A post
+// already bookmarked is moved to the collection. It returns ErrNotFound if the
+// collection isn't one of the user's.
+func (s *BookmarkStore) Save(ctx context.Context, userID, postID int64, collectionID *int64) error {
+	query := `
+		INSERT INTO bookmarks (user_id, post_id, collection_id)
+		SELECT $1::bigint, $2::bigint, $3::bigint
+		WHERE $3::bigint IS NULL
+		   OR EXISTS (SELECT 1 FROM bookmark_collections WHERE id = $3 AND user_id = $1)
+		ON CONFLICT (user_id, post_id) DO UPDATE SET collection_id = EXCLUDED.collection_id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, postID, collectionID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *BookmarkStore) Delete(ctx context.Context, userID, postID int64) error {
+	query := `DELETE FROM bookmarks WHERE user_id = $1 AND post_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID, postID)
+	return err
+}
+
+func (s *BookmarkStore) IsBookmarked(ctx context.Context, userID, postID int64) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM bookmarks WHERE user_id = $1 AND post_id = $2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	var bookmarked bool
+	err := s.db.QueryRowContext(ctx, query, userID, postID).Scan(&bookmarked)
+	return bookmarked, err
+}
+
+// List returns up to limit of the user's bookmarks, newest first, with an ID
+// below before unless it is 0, and only those in the collection unless it is
+// nil. Bookmarks of posts that were deleted or whose author blocked the user
+// are left out while that lasts.
+func (s *BookmarkStore) List(ctx context.Context, userID int64, collectionID *int64, before int64, limit int) ([]Bookmark, error) {
+	query := `
+		SELECT b.id, b.collection_id, b.created_at,
+		       p.id, p.content, p.title, p.user_id, p.tags, p.status, p.published_at,
+		       p.created_at, p.updated_at, p.version,
+		       (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND c.deleted_at IS NULL),
+		       u.username
+		FROM bookmarks b
+		JOIN posts p ON p.id = b.post_id
+		JOIN users u ON u.id = p.user_id
+		WHERE b.user_id = $1
+		  AND ($2::bigint IS NULL OR b.collection_id = $2)
+		  AND ($3::bigint = 0 OR b.id < $3)
+		  AND p.status = 'published' AND p.deleted_at IS NULL
+		  AND NOT EXISTS (
+		      SELECT 1 FROM blocks
+		      WHERE blocker_id = p.user_id AND blocked_id = $1
+		  )
+		ORDER BY b.id DESC
+		LIMIT $4
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, collectionID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookmarks := []Bookmark{}
+	for rows.Next() {
+		var b Bookmark
+		p := &b.Post
+		if err := rows.Scan(
+			&b.ID,
+			&b.CollectionID,
+			&b.CreatedAt,
+			&p.ID,
+			&p.Content,
+			&p.Title,
+			&p.UserID,
+			pq.Array(&p.Tags),
+			&p.Status,
+			&p.PublishedAt,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+			&p.Version,
+			&p.CommentCount,
+			&p.User.Username,
+		); err != nil {
+			return nil, err
+		}
+		p.Bookmarked = true
+		s.rendered.render(&p.Post)
+		bookmarks = append(bookmarks, b)
+	}
+	return bookmarks, rows.Err()
+}
+
+// CreateCollection returns ErrConflict if the user already has a collection
+// with the name, in any case.
+func (s *BookmarkStore) CreateCollection(ctx context.Context, c *BookmarkCollection) error {
+	query := `
+		INSERT INTO bookmark_collections (user_id, name) VALUES ($1, $2)
+		RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, c.UserID, c.Name).Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+// GetCollections returns the user's collections by name, with how many
+// bookmarks are in each. Bookmarks List leaves out aren't counted.
+func (s *BookmarkStore) GetCollections(ctx context.Context, userID int64) ([]BookmarkCollection, error) {
+	query := `
+		SELECT bc.id, bc.user_id, bc.name, bc.created_at, (
+			SELECT COUNT(*)
+			FROM bookmarks b
+			JOIN posts p ON p.id = b.post_id
+			WHERE b.collection_id = bc.id
+			  AND p.status = 'published' AND p.deleted_at IS NULL
+			  AND NOT EXISTS (
+			      SELECT 1 FROM blocks
+			      WHERE blocker_id = p.user_id AND blocked_id = $1
+			  )
+		)
+		FROM bookmark_collections bc
+		WHERE bc.user_id = $1
+		ORDER BY lower(bc.name)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []BookmarkCollection{}
+	for rows.Next() {
+		var c BookmarkCollection
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Name, &c.CreatedAt, &c.Bookmarks); err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+// RenameCollection saves the name of one of the user's collections. It
+// returns ErrConflict if another collection has the name.
+func (s *BookmarkStore) RenameCollection(ctx context.Context, c *BookmarkCollection) error {
+	query := `
+		UPDATE bookmark_collections SET name = $1
+		WHERE id = $2 AND user_id = $3
+		RETURNING created_at, (
+			SELECT COUNT(*)
+			FROM bookmarks b
+			JOIN posts p ON p.id = b.post_id
+			WHERE b.collection_id = $2
+			  AND p.status = 'published' AND p.deleted_at IS NULL
+			  AND NOT EXISTS (
+			      SELECT 1 FROM blocks
+			      WHERE blocker_id = p.user_id AND blocked_id = $3
+			  )
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, c.Name, c.ID, c.UserID).Scan(&c.CreatedAt, &c.Bookmarks)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteCollection deletes one of the user's collections. Its bookmarks are
+// kept, outside of any collection.
+func (s *BookmarkStore) DeleteCollection(ctx context.Context, userID, id int64) error {
+	query := `DELETE FROM bookmark_collections WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
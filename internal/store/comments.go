@@ -3,16 +3,28 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
+
+	"github.com/nati3514/Social/internal/notifications"
 )
 
 type Comment struct {
-	ID        int64  `json:"id"`
-	PostID    int64  `json:"post_id"`
-	UserID    int64  `json:"user_id"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"created_at"`
-	User      User   `json:"user"`
+	ID        int64      `json:"id"`
+	PostID    int64      `json:"post_id"`
+	UserID    int64      `json:"user_id"`
+	Content   string     `json:"content"`
+	CreatedAt string     `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Mentions  []Mention  `json:"mentions,omitempty"`
+	User      User       `json:"user"`
+	// Hold is set when the content filters held the comment for review. It
+	// is saved hidden until a moderator lets it through.
+	Hold *Hold `json:"hold,omitempty"`
+	// Hidden is set when a moderator or the content filters took the
+	// comment down. Only a moderator can bring it back.
+	Hidden bool `json:"-"`
 }
 
 type CommentStore struct {
@@ -20,6 +32,15 @@ type CommentStore struct {
 }
 
 func (s *CommentStore) GetByPostsID(ctx context.Context, postID int64) ([]Comment, error) {
+	return s.getByPostsID(ctx, postID, false)
+}
+
+// GetByPostsIDWithDeleted is GetByPostsID including comments in the trash.
+func (s *CommentStore) GetByPostsIDWithDeleted(ctx context.Context, postID int64) ([]Comment, error) {
+	return s.getByPostsID(ctx, postID, true)
+}
+
+func (s *CommentStore) getByPostsID(ctx context.Context, postID int64, withDeleted bool) ([]Comment, error) {
 	query := `
         SELECT 
             c.id, 
@@ -27,14 +48,15 @@ func (s *CommentStore) GetByPostsID(ctx context.Context, postID int64) ([]Commen
             c.user_id, 
             c.content, 
             c.created_at, 
+            c.deleted_at,
             u.username as user_username 
         FROM comments c
         JOIN users u ON u.id = c.user_id
-        WHERE c.post_id = $1
+        WHERE c.post_id = $1 AND ($2 OR c.deleted_at IS NULL)
         ORDER BY c.created_at DESC;
     `
 
-	rows, err := s.db.QueryContext(ctx, query, postID)
+	rows, err := s.db.QueryContext(ctx, query, postID, withDeleted)
 	if err != nil {
 		return nil, fmt.Errorf("querying comments: %w", err)
 	}
@@ -50,6 +72,7 @@ func (s *CommentStore) GetByPostsID(ctx context.Context, postID int64) ([]Commen
 			&c.UserID,
 			&c.Content,
 			&c.CreatedAt,
+			&c.DeletedAt,
 			&c.User.Username,
 		)
 		if err != nil {
@@ -65,26 +88,204 @@ func (s *CommentStore) GetByPostsID(ctx context.Context, postID int64) ([]Commen
 	return comments, nil
 }
 
-func (s * CommentStore) Create(ctx context.Context, comment *Comment) error {
-	query := `
+func (s *CommentStore) Create(ctx context.Context, comment *Comment) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
 		INSERT INTO comments (post_id, user_id, content) 
 		VALUES ($1, $2, $3) RETURNING id, created_at
+		`
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		err := tx.QueryRowContext(
+			qctx,
+			query,
+			comment.PostID,
+			comment.UserID,
+			comment.Content,
+		).Scan(
+			&comment.ID,
+			&comment.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		comment.Mentions, err = saveMentions(ctx, tx, "comment_id", comment.ID, comment.UserID, comment.Content)
+		if err != nil {
+			return err
+		}
+
+		if comment.Hold != nil {
+			return holdForReview(ctx, tx, ReportTargetComment, comment.ID, comment.UserID, comment.Content, comment.Hold)
+		}
+
+		return notifyComment(ctx, tx, comment)
+	})
+}
+
+// notifyComment notifies the users mentioned in a comment and the author of
+// the post it is on.
+func notifyComment(ctx context.Context, tx *sql.Tx, comment *Comment) error {
+	event := notifications.Event{
+		ActorID:   comment.UserID,
+		PostID:    &comment.PostID,
+		CommentID: &comment.ID,
+	}
+	if err := notifyMentions(ctx, tx, "comment_id", comment.ID, event); err != nil {
+		return err
+	}
+
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	// The author of the post hears about comments on it
+	event.Type = notifications.TypeComment
+	err := tx.QueryRowContext(qctx, `SELECT user_id FROM posts WHERE id = $1`, comment.PostID).Scan(&event.UserID)
+	if err != nil {
+		return err
+	}
+	return notifications.Add(ctx, tx, event)
+}
+
+func (s *CommentStore) GetByID(ctx context.Context, id int64) (*Comment, error) {
+	query := `
+		SELECT id, post_id, user_id, content, created_at, deleted_at, hidden
+		FROM comments
+		WHERE id = $1
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
-	err := s.db.QueryRowContext(
-		ctx,
-		query,
-		comment.PostID,
-		comment.UserID,
-		comment.Content,
-	).Scan(
-		&comment.ID,
-		&comment.CreatedAt,
+	c := &Comment{}
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&c.ID,
+		&c.PostID,
+		&c.UserID,
+		&c.Content,
+		&c.CreatedAt,
+		&c.DeletedAt,
+		&c.Hidden,
 	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Delete moves the comment to the trash.
+func (s *CommentStore) Delete(ctx context.Context, id int64) error {
+	query := `UPDATE comments SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if rows == 0 {
+		return ErrNotFound
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// Restore takes the comment out of the trash if it was deleted at or after
+// deletedSince, unless a moderator hid it.
+func (s *CommentStore) Restore(ctx context.Context, id int64, deletedSince time.Time) error {
+	query := `UPDATE comments SET deleted_at = NULL WHERE id = $1 AND deleted_at >= $2 AND NOT hidden`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, deletedSince)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetDeleted returns the comments of the user deleted at or after
+// deletedSince and not hidden by a moderator, most recently deleted first.
+func (s *CommentStore) GetDeleted(ctx context.Context, userID int64, deletedSince time.Time) ([]Comment, error) {
+	query := `
+		SELECT id, post_id, user_id, content, created_at, deleted_at
+		FROM comments
+		WHERE user_id = $1 AND deleted_at >= $2 AND NOT hidden
+		ORDER BY deleted_at DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, deletedSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(
+			&c.ID,
+			&c.PostID,
+			&c.UserID,
+			&c.Content,
+			&c.CreatedAt,
+			&c.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// GetRecentTexts returns the comments the user wrote since the given time,
+// except the one with ID exceptID.
+func (s *CommentStore) GetRecentTexts(ctx context.Context, userID int64, since time.Time, exceptID int64) ([]string, error) {
+	query := `
+		SELECT content FROM comments
+		WHERE user_id = $1 AND created_at >= $2 AND id <> $3
+	`
+	return getTexts(ctx, s.db, query, userID, since, exceptID)
+}
+
+// PurgeDeleted removes comments that were deleted before cutoff for good. Comments
+// with an unresolved report, such as those held for review, are kept until
+// it is resolved.
+func (s *CommentStore) PurgeDeleted(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `
+		DELETE FROM comments t
+		WHERE t.deleted_at < $1
+		  AND NOT EXISTS (
+		      SELECT 1 FROM reports r
+		      WHERE r.target_type = 'comment' AND r.target_id = t.id AND r.status <> 'resolved'
+		  )
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
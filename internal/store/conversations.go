@@ -0,0 +1,438 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nati3514/Social/internal/realtime"
+)
+
+// Conversation is a one-to-one or group conversation, as seen by one of its
+// members.
+type Conversation struct {
+	ID            int64                `json:"id"`
+	Title         *string              `json:"title"`
+	IsGroup       bool                 `json:"is_group"`
+	CreatedBy     *int64               `json:"created_by"`
+	CreatedAt     time.Time            `json:"created_at"`
+	LastMessageAt time.Time            `json:"last_message_at"`
+	Members       []ConversationMember `json:"members"`
+	LastMessage   *Message             `json:"last_message"`
+	// UnreadCount is how many messages of others the member hasn't read.
+	UnreadCount int `json:"unread_count"`
+}
+
+// ConversationMember is a member of a conversation. LastReadMessageID is
+// their read receipt: every message up to it has been read.
+type ConversationMember struct {
+	UserID            int64      `json:"user_id"`
+	Username          string     `json:"username"`
+	JoinedAt          time.Time  `json:"joined_at"`
+	LastReadMessageID int64      `json:"last_read_message_id"`
+	LastReadAt        *time.Time `json:"last_read_at"`
+}
+
+type Message struct {
+	ID             int64     `json:"id"`
+	ConversationID int64     `json:"conversation_id"`
+	UserID         int64     `json:"user_id"`
+	Username       string    `json:"username"`
+	Content        string    `json:"content"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ReadReceipt is what the other members of a conversation are told when a
+// member reads it.
+type ReadReceipt struct {
+	ConversationID    int64 `json:"conversation_id"`
+	UserID            int64 `json:"user_id"`
+	LastReadMessageID int64 `json:"last_read_message_id"`
+}
+
+type ConversationStore struct {
+	db *sql.DB
+}
+
+// CanMessage returns, for each of the users that exists, whether sender may
+// start a conversation with them: they have to follow the sender or have
+// opted into open DMs, and neither may have blocked the other.
+func (s *ConversationStore) CanMessage(ctx context.Context, senderID int64, userIDs []int64) (map[int64]bool, error) {
+	query := `
+		SELECT u.id, (u.open_dms OR EXISTS (
+			SELECT 1 FROM followers f WHERE f.user_id = $1 AND f.follower_id = u.id
+		)) AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker_id = $1 AND b.blocked_id = u.id) OR (b.blocker_id = u.id AND b.blocked_id = $1)
+		)
+		FROM users u
+		WHERE u.id = ANY($2) AND u.activated
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, senderID, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allowed := make(map[int64]bool, len(userIDs))
+	for rows.Next() {
+		var id int64
+		var ok bool
+		if err := rows.Scan(&id, &ok); err != nil {
+			return nil, err
+		}
+		allowed[id] = ok
+	}
+	return allowed, rows.Err()
+}
+
+// Create starts a conversation of its creator with the given users. A
+// conversation with a single other user and no title is one-to-one, and if
+// the two already have one it is returned instead, with created false.
+func (s *ConversationStore) Create(ctx context.Context, c *Conversation, memberIDs []int64) (created bool, err error) {
+	var directKey *string
+	if len(memberIDs) == 1 && c.Title == nil {
+		a, b := min(*c.CreatedBy, memberIDs[0]), max(*c.CreatedBy, memberIDs[0])
+		key := fmt.Sprintf("%d:%d", a, b)
+		directKey = &key
+	}
+	c.IsGroup = directKey == nil
+
+	err = withTx(s.db, ctx, func(tx *sql.Tx) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		query := `
+			INSERT INTO conversations (title, direct_key, created_by)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (direct_key) DO NOTHING
+			RETURNING id, created_at, last_message_at
+		`
+		err := tx.QueryRowContext(qctx, query, c.Title, directKey, c.CreatedBy).Scan(
+			&c.ID,
+			&c.CreatedAt,
+			&c.LastMessageAt,
+		)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// The two already talk to each other
+			query = `
+				SELECT id, created_by, created_at, last_message_at
+				FROM conversations WHERE direct_key = $1
+			`
+			return tx.QueryRowContext(qctx, query, directKey).Scan(
+				&c.ID,
+				&c.CreatedBy,
+				&c.CreatedAt,
+				&c.LastMessageAt,
+			)
+		case err != nil:
+			return err
+		}
+		created = true
+
+		query = `
+			INSERT INTO conversation_members (conversation_id, user_id)
+			SELECT $1, unnest($2::bigint[])
+			ON CONFLICT DO NOTHING
+		`
+		_, err = tx.ExecContext(qctx, query, c.ID, pq.Array(append([]int64{*c.CreatedBy}, memberIDs...)))
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	members, err := s.getMembers(ctx, []int64{c.ID})
+	if err != nil {
+		return false, err
+	}
+	c.Members = members[c.ID]
+	return created, nil
+}
+
+// selectConversations selects the conversations of the member $1, with their
+// last message and unread count, for conditions to be appended.
+const selectConversations = `
+	SELECT c.id, c.title, c.direct_key IS NULL, c.created_by, c.created_at, c.last_message_at,
+	       (SELECT COUNT(*) FROM messages msg
+	        WHERE msg.conversation_id = c.id AND msg.id > m.last_read_message_id AND msg.user_id <> m.user_id),
+	       lm.id, lm.user_id, lu.username, lm.content, lm.created_at
+	FROM conversation_members m
+	JOIN conversations c ON c.id = m.conversation_id
+	LEFT JOIN LATERAL (
+		SELECT id, user_id, content, created_at FROM messages
+		WHERE conversation_id = c.id
+		ORDER BY id DESC
+		LIMIT 1
+	) lm ON true
+	LEFT JOIN users lu ON lu.id = lm.user_id
+	WHERE m.user_id = $1
+`
+
+// GetForUser returns the conversations of the user, the most recently
+// active first.
+func (s *ConversationStore) GetForUser(ctx context.Context, userID int64, limit, offset int) ([]Conversation, error) {
+	query := selectConversations + `
+		ORDER BY c.last_message_at DESC, c.id DESC
+		LIMIT $2 OFFSET $3
+	`
+	return s.query(ctx, query, userID, limit, offset)
+}
+
+// Get returns the conversation if the user is a member of it.
+func (s *ConversationStore) Get(ctx context.Context, id, userID int64) (*Conversation, error) {
+	list, err := s.query(ctx, selectConversations+` AND c.id = $2`, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+	return &list[0], nil
+}
+
+func (s *ConversationStore) query(ctx context.Context, query string, args ...any) ([]Conversation, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Conversation{}
+	var ids []int64
+	for rows.Next() {
+		var c Conversation
+		var lastID, lastUserID sql.NullInt64
+		var lastUsername, lastContent sql.NullString
+		var lastAt sql.NullTime
+		if err := rows.Scan(
+			&c.ID,
+			&c.Title,
+			&c.IsGroup,
+			&c.CreatedBy,
+			&c.CreatedAt,
+			&c.LastMessageAt,
+			&c.UnreadCount,
+			&lastID,
+			&lastUserID,
+			&lastUsername,
+			&lastContent,
+			&lastAt,
+		); err != nil {
+			return nil, err
+		}
+		if lastID.Valid {
+			c.LastMessage = &Message{
+				ID:             lastID.Int64,
+				ConversationID: c.ID,
+				UserID:         lastUserID.Int64,
+				Username:       lastUsername.String,
+				Content:        lastContent.String,
+				CreatedAt:      lastAt.Time,
+			}
+		}
+		list = append(list, c)
+		ids = append(ids, c.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	members, err := s.getMembers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		list[i].Members = members[list[i].ID]
+	}
+	return list, nil
+}
+
+func (s *ConversationStore) getMembers(ctx context.Context, conversationIDs []int64) (map[int64][]ConversationMember, error) {
+	members := map[int64][]ConversationMember{}
+	if len(conversationIDs) == 0 {
+		return members, nil
+	}
+
+	query := `
+		SELECT m.conversation_id, m.user_id, u.username, m.joined_at, m.last_read_message_id, m.last_read_at
+		FROM conversation_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.conversation_id = ANY($1)
+		ORDER BY m.joined_at, m.user_id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(conversationIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var m ConversationMember
+		if err := rows.Scan(&id, &m.UserID, &m.Username, &m.JoinedAt, &m.LastReadMessageID, &m.LastReadAt); err != nil {
+			return nil, err
+		}
+		members[id] = append(members[id], m)
+	}
+	return members, rows.Err()
+}
+
+// GetMessages returns up to limit messages of the conversation older than the
+// one with ID before, newest first. A before of 0 starts at the newest.
+func (s *ConversationStore) GetMessages(ctx context.Context, conversationID, before int64, limit int) ([]Message, error) {
+	query := `
+		SELECT m.id, m.conversation_id, m.user_id, u.username, m.content, m.created_at
+		FROM messages m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.conversation_id = $1 AND ($2 = 0 OR m.id < $2)
+		ORDER BY m.id DESC
+		LIMIT $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, conversationID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.UserID, &m.Username, &m.Content, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// CreateMessage sends the message to the conversation, which counts as the
+// sender having read it, and pushes it to the members.
+func (s *ConversationStore) CreateMessage(ctx context.Context, m *Message) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		query := `
+			INSERT INTO messages (conversation_id, user_id, content)
+			VALUES ($1, $2, $3)
+			RETURNING id, created_at
+		`
+		if err := tx.QueryRowContext(qctx, query, m.ConversationID, m.UserID, m.Content).Scan(&m.ID, &m.CreatedAt); err != nil {
+			return err
+		}
+
+		query = `UPDATE conversations SET last_message_at = $2 WHERE id = $1`
+		if _, err := tx.ExecContext(qctx, query, m.ConversationID, m.CreatedAt); err != nil {
+			return err
+		}
+
+		query = `
+			UPDATE conversation_members SET last_read_message_id = $3, last_read_at = NOW()
+			WHERE conversation_id = $1 AND user_id = $2
+		`
+		if _, err := tx.ExecContext(qctx, query, m.ConversationID, m.UserID, m.ID); err != nil {
+			return err
+		}
+
+		return publishToMembers(ctx, tx, m.ConversationID, 0, realtime.EventMessage, m)
+	})
+}
+
+// MarkRead moves the read receipt of the member up to the message with ID
+// upTo, and returns where it is now. Receipts never move back.
+func (s *ConversationStore) MarkRead(ctx context.Context, conversationID, userID, upTo int64) (int64, error) {
+	var lastRead int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE conversation_members
+			SET last_read_message_id = GREATEST(last_read_message_id, LEAST($3, (
+			        SELECT COALESCE(MAX(id), 0) FROM messages WHERE conversation_id = $1
+			    ))),
+			    last_read_at = NOW()
+			WHERE conversation_id = $1 AND user_id = $2
+			RETURNING last_read_message_id
+		`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		err := tx.QueryRowContext(qctx, query, conversationID, userID, upTo).Scan(&lastRead)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		return publishToMembers(ctx, tx, conversationID, userID, realtime.EventMessageRead, ReadReceipt{
+			ConversationID:    conversationID,
+			UserID:            userID,
+			LastReadMessageID: lastRead,
+		})
+	})
+	return lastRead, err
+}
+
+// publishToMembers pushes an event to the members of the conversation, but
+// for the one with ID except.
+func publishToMembers(ctx context.Context, tx *sql.Tx, conversationID, except int64, eventType string, data any) error {
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(qctx, `SELECT user_id FROM conversation_members WHERE conversation_id = $1`, conversationID)
+	if err != nil {
+		return err
+	}
+
+	var userIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		if id != except {
+			userIDs = append(userIDs, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	msg, err := realtime.NewMessage(eventType, data)
+	if err != nil {
+		return err
+	}
+	for _, id := range userIDs {
+		msg.UserID = id
+		if err := realtime.Publish(ctx, tx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,571 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nati3514/Social/internal/markdown"
+)
+
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+type DataExport struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"-"`
+	Status      string     `json:"status"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// UserData is everything a user has put into the service, as it goes into a
+// data export.
+type UserData struct {
+	Profile   *User          `json:"profile"`
+	Posts     []Post         `json:"posts"`
+	Comments  []Comment      `json:"comments"`
+	Followers []Relationship `json:"followers"`
+	Following []Relationship `json:"following"`
+	// Conversations are the ones the user is a member of, with everyone
+	// in them, and Messages everything said in them.
+	Conversations []Conversation       `json:"conversations"`
+	Messages      []Message            `json:"messages"`
+	Bookmarks     []ExportedBookmark   `json:"bookmarks"`
+	Collections   []BookmarkCollection `json:"collections"`
+	Media         []Media              `json:"media"`
+}
+
+// ExportedBookmark is a post the user bookmarked, as it goes into a data
+// export.
+type ExportedBookmark struct {
+	PostID       int64     `json:"post_id"`
+	CollectionID *int64    `json:"collection_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Relationship is the other side of a follow.
+type Relationship struct {
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type DataExportStore struct {
+	db *sql.DB
+}
+
+func (s *DataExportStore) Create(ctx context.Context, userID int64) (*DataExport, error) {
+	query := `
+		INSERT INTO data_exports (user_id, status) VALUES ($1, $2)
+		RETURNING id, user_id, status, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	export := &DataExport{}
+	err := s.db.QueryRowContext(ctx, query, userID, ExportPending).Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// GetLatest returns the most recently requested export of the user.
+func (s *DataExportStore) GetLatest(ctx context.Context, userID int64) (*DataExport, error) {
+	query := `
+		SELECT id, user_id, status, expiry, created_at, completed_at
+		FROM data_exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	export := &DataExport{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.ExpiresAt,
+		&export.CreatedAt,
+		&export.CompletedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return export, nil
+}
+
+// Complete stores the finished archive, downloadable with token until exp.
+func (s *DataExportStore) Complete(ctx context.Context, export *DataExport, archive []byte, token string, exp time.Duration) error {
+	query := `
+		UPDATE data_exports
+		SET status = $1, archive = $2, token = $3, expiry = $4, completed_at = NOW()
+		WHERE id = $5
+		RETURNING status, expiry, completed_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		ExportReady,
+		archive,
+		token,
+		time.Now().Add(exp),
+		export.ID,
+	).Scan(
+		&export.Status,
+		&export.ExpiresAt,
+		&export.CompletedAt,
+	)
+}
+
+func (s *DataExportStore) Fail(ctx context.Context, exportID int64) error {
+	query := `UPDATE data_exports SET status = $1, completed_at = NOW() WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, ExportFailed, exportID)
+	return err
+}
+
+// GetArchive returns the ZIP of the export the download token belongs to.
+func (s *DataExportStore) GetArchive(ctx context.Context, token string) ([]byte, error) {
+	query := `
+		SELECT archive
+		FROM data_exports
+		WHERE token = $1 AND status = $2 AND expiry > $3
+	`
+	hash := sha256.Sum256([]byte(token))
+	hashToken := hex.EncodeToString(hash[:])
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	var archive []byte
+	err := s.db.QueryRowContext(ctx, query, hashToken, ExportReady, time.Now()).Scan(&archive)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return archive, nil
+}
+
+// DeleteExpired removes downloads that expired and exports that never
+// finished, e.g. because the server restarted while building them.
+func (s *DataExportStore) DeleteExpired(ctx context.Context, staleBefore time.Time) (int64, error) {
+	query := `
+		DELETE FROM data_exports
+		WHERE expiry <= $1
+		   OR (status <> $2 AND created_at < $3)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, time.Now(), ExportReady, staleBefore)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Collect reads everything that belongs in the export of the user, from a
+// single snapshot of the database.
+func (s *DataExportStore) Collect(ctx context.Context, userID int64) (*UserData, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	data := &UserData{}
+
+	if data.Profile, err = s.collectProfile(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	if data.Posts, err = s.collectPosts(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	if data.Comments, err = s.collectComments(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	followers := `
+		SELECT u.id, u.username, f.created_at
+		FROM followers f
+		JOIN users u ON u.id = f.follower_id
+		WHERE f.user_id = $1
+		ORDER BY f.created_at
+	`
+	if data.Followers, err = s.collectRelationships(ctx, tx, followers, userID); err != nil {
+		return nil, err
+	}
+
+	following := `
+		SELECT u.id, u.username, f.created_at
+		FROM followers f
+		JOIN users u ON u.id = f.user_id
+		WHERE f.follower_id = $1
+		ORDER BY f.created_at
+	`
+	if data.Following, err = s.collectRelationships(ctx, tx, following, userID); err != nil {
+		return nil, err
+	}
+	if data.Conversations, err = s.collectConversations(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	if data.Messages, err = s.collectMessages(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	if data.Bookmarks, err = s.collectBookmarks(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	if data.Collections, err = s.collectCollections(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	if data.Media, err = s.collectMedia(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	return data, tx.Commit()
+}
+
+func (s *DataExportStore) collectProfile(ctx context.Context, tx *sql.Tx, userID int64) (*User, error) {
+	query := `
+		SELECT id, username, email, display_name, bio, location, website, avatar_url,
+		       created_at, updated_at, activated, totp_enabled
+		FROM users
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	user := &User{}
+	err := tx.QueryRowContext(ctx, query, userID).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.DisplayName,
+		&user.Bio,
+		&user.Location,
+		&user.Website,
+		&user.AvatarURL,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.IsActive,
+		&user.TwoFactorEnabled,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func (s *DataExportStore) collectPosts(ctx context.Context, tx *sql.Tx, userID int64) ([]Post, error) {
+	query := `
+		SELECT id, title, content, user_id, tags, created_at, updated_at, version
+		FROM posts
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var p Post
+		if err := rows.Scan(
+			&p.ID,
+			&p.Title,
+			&p.Content,
+			&p.UserID,
+			pq.Array(&p.Tags),
+			&p.CreatedAt,
+			&p.UpdatedAt,
+			&p.Version,
+		); err != nil {
+			return nil, err
+		}
+		p.ContentHTML = markdown.Render(p.Content)
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+func (s *DataExportStore) collectComments(ctx context.Context, tx *sql.Tx, userID int64) ([]Comment, error) {
+	query := `
+		SELECT id, post_id, user_id, content, created_at
+		FROM comments
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(
+			&c.ID,
+			&c.PostID,
+			&c.UserID,
+			&c.Content,
+			&c.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+func (s *DataExportStore) collectRelationships(ctx context.Context, tx *sql.Tx, query string, userID int64) ([]Relationship, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	relationships := []Relationship{}
+	for rows.Next() {
+		var r Relationship
+		if err := rows.Scan(&r.UserID, &r.Username, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		relationships = append(relationships, r)
+	}
+	return relationships, rows.Err()
+}
+
+func (s *DataExportStore) collectConversations(ctx context.Context, tx *sql.Tx, userID int64) ([]Conversation, error) {
+	query := `
+		SELECT c.id, c.title, c.direct_key IS NULL, c.created_by, c.created_at, c.last_message_at
+		FROM conversation_members m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.user_id = $1
+		ORDER BY c.created_at, c.id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	index := map[int64]int{}
+	for rows.Next() {
+		var c Conversation
+		if err := rows.Scan(
+			&c.ID,
+			&c.Title,
+			&c.IsGroup,
+			&c.CreatedBy,
+			&c.CreatedAt,
+			&c.LastMessageAt,
+		); err != nil {
+			return nil, err
+		}
+		index[c.ID] = len(conversations)
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	members := `
+		SELECT m.conversation_id, m.user_id, u.username, m.joined_at, m.last_read_message_id, m.last_read_at
+		FROM conversation_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.conversation_id IN (SELECT conversation_id FROM conversation_members WHERE user_id = $1)
+		ORDER BY m.joined_at, m.user_id
+	`
+
+	rows, err = tx.QueryContext(ctx, members, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var m ConversationMember
+		if err := rows.Scan(&id, &m.UserID, &m.Username, &m.JoinedAt, &m.LastReadMessageID, &m.LastReadAt); err != nil {
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			conversations[i].Members = append(conversations[i].Members, m)
+		}
+	}
+	return conversations, rows.Err()
+}
+
+func (s *DataExportStore) collectMessages(ctx context.Context, tx *sql.Tx, userID int64) ([]Message, error) {
+	query := `
+		SELECT msg.id, msg.conversation_id, msg.user_id, u.username, msg.content, msg.created_at
+		FROM messages msg
+		JOIN users u ON u.id = msg.user_id
+		WHERE msg.conversation_id IN (SELECT conversation_id FROM conversation_members WHERE user_id = $1)
+		ORDER BY msg.conversation_id, msg.id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(
+			&m.ID,
+			&m.ConversationID,
+			&m.UserID,
+			&m.Username,
+			&m.Content,
+			&m.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+func (s *DataExportStore) collectBookmarks(ctx context.Context, tx *sql.Tx, userID int64) ([]ExportedBookmark, error) {
+	query := `
+		SELECT post_id, collection_id, created_at
+		FROM bookmarks
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookmarks := []ExportedBookmark{}
+	for rows.Next() {
+		var b ExportedBookmark
+		if err := rows.Scan(&b.PostID, &b.CollectionID, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	return bookmarks, rows.Err()
+}
+
+func (s *DataExportStore) collectCollections(ctx context.Context, tx *sql.Tx, userID int64) ([]BookmarkCollection, error) {
+	query := `
+		SELECT bc.id, bc.user_id, bc.name, bc.created_at,
+		       (SELECT COUNT(*) FROM bookmarks b WHERE b.collection_id = bc.id)
+		FROM bookmark_collections bc
+		WHERE bc.user_id = $1
+		ORDER BY bc.created_at, bc.id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []BookmarkCollection{}
+	for rows.Next() {
+		var c BookmarkCollection
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Name, &c.CreatedAt, &c.Bookmarks); err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+func (s *DataExportStore) collectMedia(ctx context.Context, tx *sql.Tx, userID int64) ([]Media, error) {
+	query := `
+		SELECT ` + mediaColumns + `
+		FROM media
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return collectMedia(rows)
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 
 	"github.com/lib/pq"
+
+	"github.com/nati3514/Social/internal/notifications"
 )
 
 type Follower struct {
@@ -20,18 +22,37 @@ type FollowerStore struct {
 func (s *FollowerStore) Follow(ctx context.Context, followerID, userID int64) error {
 	query := `
 	   INSERT INTO followers (user_id, follower_id)
-	   VALUES ($1, $2)
+	   SELECT $1::bigint, $2::bigint
+	   WHERE NOT EXISTS (
+	       SELECT 1 FROM blocks
+	       WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+	   )
 	`
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
-	defer cancel()
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
 
-	_, err := s.db.ExecContext(ctx, query, userID, followerID)
-	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
-			return ErrConflict
+		res, err := tx.ExecContext(qctx, query, userID, followerID)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrConflict
+			}
+			return err
 		}
-	}
-	return err
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrBlocked
+		}
+
+		return notifications.Add(ctx, tx, notifications.Event{
+			UserID:  userID,
+			ActorID: followerID,
+			Type:    notifications.TypeFollow,
+		})
+	})
 }
 
 func (s *FollowerStore) Unfollow(ctx context.Context, followerID, userID int64) error {
@@ -45,3 +66,31 @@ func (s *FollowerStore) Unfollow(ctx context.Context, followerID, userID int64)
 	_, err := s.db.ExecContext(ctx, query, userID, followerID)
 	return err
 }
+
+// FilterFollowers returns which of candidates follow the user and haven't
+// muted them.
+func (s *FollowerStore) FilterFollowers(ctx context.Context, userID int64, candidates []int64) ([]int64, error) {
+	query := `
+	   SELECT f.follower_id FROM followers f
+	   WHERE f.user_id = $1 AND f.follower_id = ANY($2)
+	     AND NOT EXISTS (SELECT 1 FROM mutes m WHERE m.muter_id = f.follower_id AND m.muted_id = $1)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, pq.Array(candidates))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var followers []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		followers = append(followers, id)
+	}
+	return followers, rows.Err()
+}
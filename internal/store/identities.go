@@ -0,0 +1,235 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Identity struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	Provider  string `json:"provider"`
+	Subject   string `json:"subject"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
+
+type OIDCLoginState struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+}
+
+type IdentityStore struct {
+	db *sql.DB
+}
+
+func (s *IdentityStore) CreateLoginState(ctx context.Context, state string, ls *OIDCLoginState, exp time.Duration) error {
+	query := `
+		INSERT INTO oidc_login_states (state, provider, nonce, code_verifier, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, state, ls.Provider, ls.Nonce, ls.CodeVerifier, time.Now().Add(exp))
+	return err
+}
+
+// ConsumeLoginState deletes and returns an unexpired login state so it can
+// only be used by a single callback.
+func (s *IdentityStore) ConsumeLoginState(ctx context.Context, state string) (*OIDCLoginState, error) {
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state = $1 AND expiry > $2
+		RETURNING provider, nonce, code_verifier
+	`
+	hash := sha256.Sum256([]byte(state))
+	hashState := hex.EncodeToString(hash[:])
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	ls := &OIDCLoginState{}
+	err := s.db.QueryRowContext(ctx, query, hashState, time.Now()).Scan(
+		&ls.Provider,
+		&ls.Nonce,
+		&ls.CodeVerifier,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return ls, nil
+}
+
+func (s *IdentityStore) DeleteExpiredLoginStates(ctx context.Context) (int64, error) {
+	query := `DELETE FROM oidc_login_states WHERE expiry <= $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *IdentityStore) GetUser(ctx context.Context, provider, subject string) (*User, error) {
+	query := `
+		SELECT u.id, u.username, u.email, u.created_at, u.activated, u.totp_enabled, u.suspended_at
+		FROM users u
+		JOIN user_identities ui ON u.id = ui.user_id
+		WHERE ui.provider = $1 AND ui.subject = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	user := &User{}
+	err := s.db.QueryRowContext(ctx, query, provider, subject).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.CreatedAt,
+		&user.IsActive,
+		&user.TwoFactorEnabled,
+		&user.SuspendedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func (s *IdentityStore) Link(ctx context.Context, identity *Identity) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	return insertIdentity(ctx, s.db, identity)
+}
+
+// CreateUser creates an activated user for a first-time external login and
+// links the identity to it. The username is derived from base and made unique.
+func (s *IdentityStore) CreateUser(ctx context.Context, user *User, base string, identity *Identity) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		username, err := pickUsername(ctx, tx, base)
+		if err != nil {
+			return err
+		}
+		user.Username = username
+		user.IsActive = true
+
+		users := &UserStore{s.db}
+		if err := users.Create(ctx, tx, user); err != nil {
+			return err
+		}
+
+		identity.UserID = user.ID
+		return insertIdentity(ctx, tx, identity)
+	})
+}
+
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertIdentity(ctx context.Context, db rowQuerier, identity *Identity) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+		RETURNING id, created_at
+	`
+
+	err := db.QueryRowContext(
+		ctx,
+		query,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+	).Scan(
+		&identity.ID,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// pickUsername returns base, or base with a numeric suffix, that no user has
+// or had. A reserved base is only used with a suffix.
+func pickUsername(ctx context.Context, tx *sql.Tx, base string) (string, error) {
+	base = usernameInvalidChars.ReplaceAllString(strings.ToLower(base), "")
+	if len(base) > 30 {
+		base = base[:30]
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	var candidates []string
+	if !IsReservedUsername(base) {
+		candidates = append(candidates, base)
+	}
+	for i := 0; i < 5; i++ {
+		candidates = append(candidates, fmt.Sprintf("%s%d", base, rand.Intn(100000)))
+	}
+
+	query := `
+		SELECT username FROM users WHERE username = ANY($1)
+		UNION
+		SELECT lower(username::text) FROM previous_usernames WHERE username = ANY($1::citext[])
+	`
+	rows, err := tx.QueryContext(ctx, query, pq.Array(candidates))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return "", err
+		}
+		taken[username] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	for _, c := range candidates {
+		if !taken[c] {
+			return c, nil
+		}
+	}
+	return "", ErrDuplicateUsername
+}
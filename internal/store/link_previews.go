@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LinkPreview is the card shown for a URL in a post. URL is normalized.
+type LinkPreview struct {
+	URL         string    `json:"url"`
+	OK          bool      `json:"-"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	ImageURL    string    `json:"image_url,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	FetchedAt   time.Time `json:"-"`
+	ExpiresAt   time.Time `json:"-"`
+}
+
+type LinkPreviewStore struct {
+	db *sql.DB
+}
+
+// GetFresh returns the previews of urls that have not expired yet, failed
+// ones included, keyed by URL.
+func (s *LinkPreviewStore) GetFresh(ctx context.Context, urls []string) (map[string]LinkPreview, error) {
+	previews := map[string]LinkPreview{}
+	if len(urls) == 0 {
+		return previews, nil
+	}
+
+	query := `
+		SELECT url, ok, title, description, image_url, site_name, fetched_at, expires_at
+		FROM link_previews
+		WHERE url = ANY($1) AND expires_at > NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(urls))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p LinkPreview
+		if err := rows.Scan(
+			&p.URL,
+			&p.OK,
+			&p.Title,
+			&p.Description,
+			&p.ImageURL,
+			&p.SiteName,
+			&p.FetchedAt,
+			&p.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		previews[p.URL] = p
+	}
+	return previews, rows.Err()
+}
+
+func (s *LinkPreviewStore) Upsert(ctx context.Context, p *LinkPreview) error {
+	query := `
+		INSERT INTO link_previews (url, ok, title, description, image_url, site_name, fetched_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7)
+		ON CONFLICT (url) DO UPDATE
+		SET ok = EXCLUDED.ok, title = EXCLUDED.title, description = EXCLUDED.description,
+		    image_url = EXCLUDED.image_url, site_name = EXCLUDED.site_name,
+		    fetched_at = EXCLUDED.fetched_at, expires_at = EXCLUDED.expires_at
+		RETURNING fetched_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		p.URL,
+		p.OK,
+		p.Title,
+		p.Description,
+		p.ImageURL,
+		p.SiteName,
+		p.ExpiresAt,
+	).Scan(&p.FetchedAt)
+}
+
+func (s *LinkPreviewStore) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM link_previews WHERE expires_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	ThrottleScopeAccount = "account"
+	ThrottleScopeIP      = "ip"
+)
+
+type LoginThrottle struct {
+	Scope         string
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+type LoginThrottleStore struct {
+	db *sql.DB
+}
+
+func (s *LoginThrottleStore) Get(ctx context.Context, scope, key string) (*LoginThrottle, error) {
+	query := `
+		SELECT scope, key, failures, last_failure_at, locked_until
+		FROM login_throttles
+		WHERE scope = $1 AND key = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	t := &LoginThrottle{}
+	err := s.db.QueryRowContext(ctx, query, scope, key).Scan(
+		&t.Scope,
+		&t.Key,
+		&t.Failures,
+		&t.LastFailureAt,
+		&t.LockedUntil,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// RecordFailure counts a failed login. Failures older than window no longer
+// count, so the counter starts again from one.
+func (s *LoginThrottleStore) RecordFailure(ctx context.Context, scope, key string, window time.Duration) (*LoginThrottle, error) {
+	query := `
+		INSERT INTO login_throttles (scope, key, failures, last_failure_at)
+		VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (scope, key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttles.last_failure_at < NOW() - make_interval(secs => $3) THEN 1
+				ELSE login_throttles.failures + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING scope, key, failures, last_failure_at, locked_until
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	t := &LoginThrottle{}
+	err := s.db.QueryRowContext(ctx, query, scope, key, window.Seconds()).Scan(
+		&t.Scope,
+		&t.Key,
+		&t.Failures,
+		&t.LastFailureAt,
+		&t.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *LoginThrottleStore) Lock(ctx context.Context, scope, key string, until time.Time) error {
+	query := `UPDATE login_throttles SET locked_until = $3 WHERE scope = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, scope, key, until)
+	return err
+}
+
+func (s *LoginThrottleStore) Reset(ctx context.Context, scope, key string) error {
+	query := `DELETE FROM login_throttles WHERE scope = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, scope, key)
+	return err
+}
+
+func (s *LoginThrottleStore) CreateUnlockToken(ctx context.Context, userID int64, token string, exp time.Duration) error {
+	query := `INSERT INTO account_unlocks (token, user_id, expiry) VALUES ($1, $2, $3)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, token, userID, time.Now().Add(exp))
+	return err
+}
+
+// Unlock clears the account lockout belonging to the unlock token and
+// invalidates every unlock token of that account.
+func (s *LoginThrottleStore) Unlock(ctx context.Context, token string) (int64, error) {
+	var userID int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			SELECT u.id, u.email
+			FROM users u
+			JOIN account_unlocks au ON u.id = au.user_id
+			WHERE au.token = $1 AND au.expiry > $2
+		`
+		hash := sha256.Sum256([]byte(token))
+		hashToken := hex.EncodeToString(hash[:])
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		var email string
+		err := tx.QueryRowContext(ctx, query, hashToken, time.Now()).Scan(&userID, &email)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(
+			ctx,
+			`DELETE FROM login_throttles WHERE scope = $1 AND key = lower($2)`,
+			ThrottleScopeAccount,
+			email,
+		); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM account_unlocks WHERE user_id = $1`, userID)
+		return err
+	})
+	return userID, err
+}
@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrMediaUnavailable = errors.New("media not found or already attached to a post")
+
+type Media struct {
+	ID           int64     `json:"id"`
+	UserID       int64     `json:"user_id"`
+	PostID       *int64    `json:"post_id"`
+	Key          string    `json:"-"`
+	ThumbnailKey *string   `json:"-"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	AltText      string    `json:"alt_text"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type MediaStore struct {
+	db *sql.DB
+}
+
+const mediaColumns = `id, user_id, post_id, key, thumbnail_key, content_type, size, width, height, alt_text, created_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMedia(row rowScanner, m *Media) error {
+	return row.Scan(
+		&m.ID,
+		&m.UserID,
+		&m.PostID,
+		&m.Key,
+		&m.ThumbnailKey,
+		&m.ContentType,
+		&m.Size,
+		&m.Width,
+		&m.Height,
+		&m.AltText,
+		&m.CreatedAt,
+	)
+}
+
+func (s *MediaStore) Create(ctx context.Context, m *Media) error {
+	query := `
+		INSERT INTO media (user_id, key, thumbnail_key, content_type, size, width, height, alt_text)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		m.UserID,
+		m.Key,
+		m.ThumbnailKey,
+		m.ContentType,
+		m.Size,
+		m.Width,
+		m.Height,
+		m.AltText,
+	).Scan(
+		&m.ID,
+		&m.CreatedAt,
+	)
+}
+
+func (s *MediaStore) GetByID(ctx context.Context, id int64) (*Media, error) {
+	query := `SELECT ` + mediaColumns + ` FROM media WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	m := &Media{}
+	if err := scanMedia(s.db.QueryRowContext(ctx, query, id), m); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (s *MediaStore) GetByPost(ctx context.Context, postID int64) ([]Media, error) {
+	query := `SELECT ` + mediaColumns + ` FROM media WHERE post_id = $1 ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, postID)
+	if err != nil {
+		return nil, err
+	}
+	return collectMedia(rows)
+}
+
+func (s *MediaStore) UpdateAltText(ctx context.Context, m *Media) error {
+	query := `UPDATE media SET alt_text = $1 WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, m.AltText, m.ID)
+	return err
+}
+
+// DeleteUnattachedBefore removes uploads that were never used in a post, or
+// whose post is gone, and returns them so their blobs can be removed too.
+func (s *MediaStore) DeleteUnattachedBefore(ctx context.Context, cutoff time.Time) ([]Media, error) {
+	query := `DELETE FROM media WHERE post_id IS NULL AND created_at < $1 RETURNING ` + mediaColumns
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	return collectMedia(rows)
+}
+
+// DeleteOfScheduledUsers removes the uploads of accounts that are about to be
+// deleted, returning them so their blobs can be removed too.
+func (s *MediaStore) DeleteOfScheduledUsers(ctx context.Context, now time.Time) ([]Media, error) {
+	query := `
+		DELETE FROM media
+		WHERE user_id IN (SELECT id FROM users WHERE deletion_scheduled_at <= $1)
+		RETURNING ` + mediaColumns
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	return collectMedia(rows)
+}
+
+func collectMedia(rows *sql.Rows) ([]Media, error) {
+	defer rows.Close()
+
+	media := []Media{}
+	for rows.Next() {
+		var m Media
+		if err := scanMedia(rows, &m); err != nil {
+			return nil, err
+		}
+		media = append(media, m)
+	}
+	return media, rows.Err()
+}
+
+// attachMedia links uploads of the user that are not in use yet to the post.
+func attachMedia(ctx context.Context, tx *sql.Tx, postID, userID int64, ids []int64) ([]Media, error) {
+	query := `
+		UPDATE media SET post_id = $1
+		WHERE id = ANY($2) AND user_id = $3 AND post_id IS NULL
+		RETURNING ` + mediaColumns
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, postID, pq.Array(ids), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	attached, err := collectMedia(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(attached) != len(ids) {
+		return nil, ErrMediaUnavailable
+	}
+
+	// keep the order the client listed them in
+	byID := make(map[int64]Media, len(attached))
+	for _, m := range attached {
+		byID[m.ID] = m
+	}
+	media := make([]Media, len(ids))
+	for i, id := range ids {
+		media[i] = byID[id]
+	}
+	return media, nil
+}
@@ -0,0 +1,238 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nati3514/Social/internal/mention"
+	"github.com/nati3514/Social/internal/notifications"
+)
+
+// Mention is an @username in a post or comment that resolved to a user.
+// Start and End are offsets in characters covering the @ and the username.
+type Mention struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+// MentionedIn is a post or comment a user was mentioned in.
+type MentionedIn struct {
+	PostID         int64     `json:"post_id"`
+	CommentID      *int64    `json:"comment_id,omitempty"`
+	AuthorID       int64     `json:"author_id"`
+	AuthorUsername string    `json:"author_username"`
+	Content        string    `json:"content"`
+	MentionedAt    time.Time `json:"mentioned_at"`
+}
+
+type MentionStore struct {
+	db *sql.DB
+}
+
+// GetByPosts returns the mentions in each of the posts, in order.
+func (s *MentionStore) GetByPosts(ctx context.Context, postIDs []int64) (map[int64][]Mention, error) {
+	return s.getBy(ctx, "post_id", postIDs)
+}
+
+// GetByComments returns the mentions in each of the comments, in order.
+func (s *MentionStore) GetByComments(ctx context.Context, commentIDs []int64) (map[int64][]Mention, error) {
+	return s.getBy(ctx, "comment_id", commentIDs)
+}
+
+func (s *MentionStore) getBy(ctx context.Context, column string, ids []int64) (map[int64][]Mention, error) {
+	mentions := map[int64][]Mention{}
+	if len(ids) == 0 {
+		return mentions, nil
+	}
+
+	query := `
+		SELECT m.` + column + `, m.user_id, u.username, m.start_offset, m.end_offset
+		FROM mentions m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.` + column + ` = ANY($1)
+		ORDER BY m.start_offset
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var m Mention
+		if err := rows.Scan(&id, &m.UserID, &m.Username, &m.Start, &m.End); err != nil {
+			return nil, err
+		}
+		mentions[id] = append(mentions[id], m)
+	}
+	return mentions, rows.Err()
+}
+
+// GetForUser returns the published posts and comments the user was mentioned
+// in, most recent first.
+func (s *MentionStore) GetForUser(ctx context.Context, userID int64, limit, offset int) ([]MentionedIn, error) {
+	query := `
+		SELECT p.id, c.id, author.id, author.username, COALESCE(c.content, p.content),
+		       MIN(m.created_at) AS mentioned_at
+		FROM mentions m
+		LEFT JOIN comments c ON c.id = m.comment_id
+		JOIN posts p ON p.id = COALESCE(m.post_id, c.post_id)
+		JOIN users author ON author.id = COALESCE(c.user_id, p.user_id)
+		WHERE m.user_id = $1
+		  AND p.status = 'published' AND p.deleted_at IS NULL
+		  AND (c.id IS NULL OR c.deleted_at IS NULL)
+		GROUP BY p.id, c.id, author.id
+		ORDER BY mentioned_at DESC, p.id DESC, c.id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := []MentionedIn{}
+	for rows.Next() {
+		var m MentionedIn
+		if err := rows.Scan(
+			&m.PostID,
+			&m.CommentID,
+			&m.AuthorID,
+			&m.AuthorUsername,
+			&m.Content,
+			&m.MentionedAt,
+		); err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, m)
+	}
+	return mentions, rows.Err()
+}
+
+// saveMentions replaces the mentions of a post or comment with those in
+// content. Users who stay mentioned keep the time they were first mentioned
+// at, so edits don't bring old mentions back to the top of their list, and
+// aren't notified again. Users who blocked the author, or were blocked by
+// them, can't be mentioned.
+func saveMentions(ctx context.Context, tx *sql.Tx, column string, id, authorID int64, content string) ([]Mention, error) {
+	spans := mention.Find(content)
+
+	usernames := make([]string, len(spans))
+	starts := make([]int64, len(spans))
+	ends := make([]int64, len(spans))
+	for i, span := range spans {
+		usernames[i] = span.Username
+		starts[i] = int64(span.Start)
+		ends[i] = int64(span.End)
+	}
+
+	query := `
+		WITH old AS (
+			DELETE FROM mentions WHERE ` + column + ` = $1
+			RETURNING user_id, created_at, notified
+		)
+		INSERT INTO mentions (user_id, ` + column + `, start_offset, end_offset, created_at, notified)
+		SELECT u.id, $1, m.start_offset, m.end_offset,
+		       COALESCE((SELECT MIN(old.created_at) FROM old WHERE old.user_id = u.id), NOW()),
+		       COALESCE((SELECT bool_or(old.notified) FROM old WHERE old.user_id = u.id), false)
+		FROM unnest($2::varchar[], $3::int[], $4::int[]) AS m(username, start_offset, end_offset)
+		JOIN users u ON u.username = m.username
+		WHERE NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker_id = u.id AND b.blocked_id = $5) OR (b.blocker_id = $5 AND b.blocked_id = u.id)
+		)
+		RETURNING user_id, start_offset, end_offset
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, id, pq.Array(usernames), pq.Array(starts), pq.Array(ends), authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// the usernames are the ones written, which matched exactly
+	byStart := make(map[int]string, len(spans))
+	for _, span := range spans {
+		byStart[span.Start] = span.Username
+	}
+
+	mentions := []Mention{}
+	for rows.Next() {
+		var m Mention
+		if err := rows.Scan(&m.UserID, &m.Start, &m.End); err != nil {
+			return nil, err
+		}
+		m.Username = byStart[m.Start]
+		mentions = append(mentions, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(mentions, func(i, j int) bool { return mentions[i].Start < mentions[j].Start })
+	return mentions, nil
+}
+
+// notifyMentions notifies the users mentioned in a post or comment who
+// haven't been yet. It is called once the post or comment is published, so
+// drafts don't notify anyone until they go out.
+func notifyMentions(ctx context.Context, tx *sql.Tx, column string, id int64, e notifications.Event) error {
+	query := `
+		UPDATE mentions SET notified = true
+		WHERE ` + column + ` = $1 AND NOT notified
+		RETURNING user_id
+	`
+
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(qctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	var userIDs []int64
+	seen := map[int64]bool{}
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		if !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	e.Type = notifications.TypeMention
+	for _, userID := range userIDs {
+		e.UserID = userID
+		if err := notifications.Add(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,21 +6,51 @@ import (
 	"errors"
 	"fmt"
 	"time"
+	"unicode/utf8"
 
 	"github.com/lib/pq"
+
+	"github.com/nati3514/Social/internal/notifications"
+	"github.com/nati3514/Social/internal/realtime"
+)
+
+const (
+	PostDraft     = "draft"
+	PostScheduled = "scheduled"
+	PostPublished = "published"
 )
 
 type Post struct {
-	ID        int64     `json:"id"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	UserID    int64     `json:"user_id"`
-	Tags      []string  `json:"tags"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Version   int32     `json:"version"`
-	Comments  []Comment `json:"comments"`
-	User      User      `json:"user"`
+	ID          int64      `json:"id"`
+	Title       string     `json:"title"`
+	Content     string     `json:"content"`
+	ContentHTML string     `json:"content_html"`
+	UserID      int64      `json:"user_id"`
+	Tags        []string   `json:"tags"`
+	Status      string     `json:"status"`
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Version     int32      `json:"version"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	Comments    []Comment  `json:"comments"`
+	// Media is only loaded for a single post, feeds and lists leave it
+	// empty.
+	Media    []Media   `json:"media,omitempty"`
+	Mentions []Mention `json:"mentions,omitempty"`
+	// LinkPreviews are cards for the URLs in the content that have been
+	// unfurled so far.
+	LinkPreviews []LinkPreview `json:"link_previews"`
+	User         User          `json:"user"`
+	// Hold is set when the content filters held the post for review. It is
+	// saved hidden until a moderator lets it through.
+	Hold *Hold `json:"hold,omitempty"`
+	// Hidden is set when a moderator or the content filters took the post
+	// down. Only a moderator can bring it back.
+	Hidden bool `json:"-"`
+	// Bookmarked is whether the user viewing the post bookmarked it.
+	Bookmarked bool `json:"bookmarked"`
 }
 
 type PostWithMetadata struct {
@@ -29,30 +59,86 @@ type PostWithMetadata struct {
 }
 
 type PostStore struct {
-	db *sql.DB
+	db       *sql.DB
+	rendered *renderCache
 }
 
-func (s *PostStore) GetUserFeed(ctx context.Context, userID int64) ([]PostWithMetadata, error) {
-	query := `
+// feedQuery selects the published posts of a user and of the users they
+// follow but haven't muted, with their comment counts, for conditions on p to
+// be appended.
+const feedQuery = `
     SELECT p.id, p.content, p.title, p.user_id, p.tags, p.created_at, p.updated_at, p.version, 
-           COUNT(c.id) AS comment_count, u.username
+           p.published_at, COUNT(c.id) AS comment_count, u.username,
+           EXISTS (
+               SELECT 1 FROM bookmarks b
+               WHERE b.user_id = $1 AND b.post_id = p.id
+           ) AS bookmarked
     FROM posts p
-    LEFT JOIN comments c ON p.id = c.post_id
+    LEFT JOIN comments c ON p.id = c.post_id AND c.deleted_at IS NULL
     LEFT JOIN users u ON p.user_id = u.id
-    WHERE p.user_id = $1 
+    WHERE p.status = 'published' AND p.deleted_at IS NULL
+      AND (p.user_id = $1
        OR p.user_id IN (
            SELECT user_id
            FROM followers
            WHERE follower_id = $1
+       ))
+      AND NOT EXISTS (
+           SELECT 1 FROM mutes
+           WHERE muter_id = $1 AND muted_id = p.user_id
        )
+`
+
+func (s *PostStore) GetUserFeed(ctx context.Context, userID int64) ([]PostWithMetadata, error) {
+	query := feedQuery + `
     GROUP BY p.id, u.username, p.content, p.title, p.user_id, p.tags, p.created_at, p.updated_at, p.version
-    ORDER BY p.created_at DESC
+    ORDER BY p.published_at DESC
     LIMIT 20
     `
+	return s.queryFeed(ctx, query, userID)
+}
+
+// FeedCursor is a position in the feed in order of publication. Scheduled
+// posts keep the ID they were created with, so the ID alone doesn't order
+// posts by when they were published.
+type FeedCursor struct {
+	PublishedAt time.Time
+	ID          int64
+}
+
+// GetFeedSince returns up to limit posts of the user's feed published after
+// the cursor, in order of publication.
+func (s *PostStore) GetFeedSince(ctx context.Context, userID int64, after FeedCursor, limit int) ([]PostWithMetadata, error) {
+	query := feedQuery + `
+      AND (p.published_at, p.id) > ($2, $3)
+    GROUP BY p.id, u.username
+    ORDER BY p.published_at, p.id
+    LIMIT $4
+    `
+	return s.queryFeed(ctx, query, userID, after.PublishedAt, after.ID, limit)
+}
+
+// GetFeedPost returns the post if it is in the user's feed.
+func (s *PostStore) GetFeedPost(ctx context.Context, userID, postID int64) (*PostWithMetadata, error) {
+	query := feedQuery + `
+      AND p.id = $2
+    GROUP BY p.id, u.username
+    `
+	feed, err := s.queryFeed(ctx, query, userID, postID)
+	if err != nil {
+		return nil, err
+	}
+	if len(feed) == 0 {
+		return nil, ErrNotFound
+	}
+	return &feed[0], nil
+}
+
+func (s *PostStore) queryFeed(ctx context.Context, query string, args ...any) ([]PostWithMetadata, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
-	rows, err := s.db.QueryContext(ctx, query, userID)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -75,8 +161,10 @@ func (s *PostStore) GetUserFeed(ctx context.Context, userID int64) ([]PostWithMe
 			&p.CreatedAt,
 			&p.UpdatedAt,
 			&p.Version,
+			&p.PublishedAt,
 			&p.CommentCount,
 			&username, // Scan the username
+			&p.Bookmarked,
 		); err != nil {
 			return nil, fmt.Errorf("error scanning row: %w", err)
 		}
@@ -86,6 +174,7 @@ func (s *PostStore) GetUserFeed(ctx context.Context, userID int64) ([]PostWithMe
 			p.Post.User.Username = username.String
 		}
 		
+		s.rendered.render(&p.Post)
 		feed = append(feed, p)
 	}
 	
@@ -99,44 +188,98 @@ var (
 	ErrEditConflict = errors.New("edit conflict: post has been modified by another user")
 )
 
+// Create creates a post without any uploads.
 func (s *PostStore) Create(ctx context.Context, post *Post) error {
-	query := `
-	INSERT INTO posts (content, title, user_id, tags) 
-	VALUES  ($1, $2, $3, $4) RETURNING id, created_at, updated_at
-	`
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
-	defer cancel()
+	return s.CreateWithMedia(ctx, post, nil)
+}
 
-	err := s.db.QueryRowContext(
-		ctx,
-		query,
-		post.Content,
-		post.Title,
-		post.UserID,
-		pq.Array(post.Tags),
-	).Scan(
-		&post.ID,
-		&post.CreatedAt,
-		&post.UpdatedAt,
-	)
+// CreateWithMedia creates the post and attaches the uploads with the given
+// IDs to it. They have to belong to the author and not be in use yet.
+func (s *PostStore) CreateWithMedia(ctx context.Context, post *Post, mediaIDs []int64) error {
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+		INSERT INTO posts (content, title, user_id, tags, status, publish_at, published_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CASE WHEN $7 THEN NOW() END)
+		RETURNING id, published_at, created_at, updated_at
+		`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		if post.Status == "" {
+			post.Status = PostPublished
+		}
+
+		err := tx.QueryRowContext(
+			qctx,
+			query,
+			post.Content,
+			post.Title,
+			post.UserID,
+			pq.Array(post.Tags),
+			post.Status,
+			post.PublishAt,
+			post.Status == PostPublished,
+		).Scan(
+			&post.ID,
+			&post.PublishedAt,
+			&post.CreatedAt,
+			&post.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		post.Mentions, err = saveMentions(ctx, tx, "post_id", post.ID, post.UserID, post.Content)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case post.Hold != nil:
+			if err := holdForReview(ctx, tx, ReportTargetPost, post.ID, post.UserID, post.Title+"\n\n"+post.Content, post.Hold); err != nil {
+				return err
+			}
+		case post.Status == PostPublished:
+			if err := notifyPublished(ctx, tx, post); err != nil {
+				return err
+			}
+		}
+
+		if len(mediaIDs) == 0 {
+			return nil
+		}
+
+		post.Media, err = attachMedia(ctx, tx, post.ID, post.UserID, mediaIDs)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
+	s.rendered.render(post)
 	return nil
-
 }
 
 func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
+	return s.getByID(ctx, id, false)
+}
+
+// GetByIDWithDeleted is GetByID for posts that may be in the trash.
+func (s *PostStore) GetByIDWithDeleted(ctx context.Context, id int64) (*Post, error) {
+	return s.getByID(ctx, id, true)
+}
+
+func (s *PostStore) getByID(ctx context.Context, id int64, withDeleted bool) (*Post, error) {
 	query := `
-	SELECT id, content, title, user_id, tags, created_at, updated_at, version
+	SELECT id, content, title, user_id, tags, status, publish_at, published_at, created_at, updated_at, version, deleted_at, hidden
 	FROM posts
-	WHERE id = $1
+	WHERE id = $1 AND ($2 OR deleted_at IS NULL)
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
-	row := s.db.QueryRowContext(ctx, query, id)
+	row := s.db.QueryRowContext(ctx, query, id, withDeleted)
 	var post Post
 	if err := row.Scan(
 		&post.ID,
@@ -144,9 +287,14 @@ func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 		&post.Title,
 		&post.UserID,
 		pq.Array(&post.Tags),
+		&post.Status,
+		&post.PublishAt,
+		&post.PublishedAt,
 		&post.CreatedAt,
 		&post.UpdatedAt,
 		&post.Version,
+		&post.DeletedAt,
+		&post.Hidden,
 	); err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -155,13 +303,16 @@ func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 			return nil, err
 		}
 	}
+	s.rendered.render(&post)
 	return &post, nil
 }
 
+// Delete moves the post to the trash, from where it can be restored until it
+// is purged.
 func (s *PostStore) Delete(ctx context.Context, postID int64) error {
 	query := `
-    DELETE FROM posts
-    WHERE id = $1
+    UPDATE posts SET deleted_at = NOW()
+    WHERE id = $1 AND deleted_at IS NULL
     `
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
@@ -188,49 +339,340 @@ func (s *PostStore) Update(ctx context.Context, post *Post) error {
 
 	query := `
         UPDATE posts 
-        SET title = $1, content = $2, tags = $3, version = version + 1, updated_at = NOW() 
+        SET title = $1, content = $2, tags = $3, status = $6, publish_at = $7,
+            published_at = CASE WHEN $8 THEN COALESCE(published_at, NOW()) END,
+            version = version + 1, updated_at = NOW() 
         WHERE id = $4 AND version = $5
-        RETURNING version, updated_at
+        RETURNING version, published_at, updated_at
     `
 
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
-	defer cancel()
+	// The post was loaded at the version being updated, so this is its first
+	// publication if it wasn't published then
+	firstPublished := post.Status == PostPublished && post.PublishedAt == nil
 
-	originalVersion := post.Version
-	err := s.db.QueryRowContext(
-		ctx,
-		query,
-		post.Title,
-		post.Content,
-		pq.Array(post.Tags),
-		post.ID,
-		originalVersion,
-	).Scan(&post.Version, &post.UpdatedAt)
+	// The old version is saved in the same transaction, so history and post
+	// never disagree
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if err := saveRevision(ctx, tx, post.ID, post.Version); err != nil {
+			return err
+		}
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		err := tx.QueryRowContext(
+			qctx,
+			query,
+			post.Title,
+			post.Content,
+			pq.Array(post.Tags),
+			post.ID,
+			post.Version,
+			post.Status,
+			post.PublishAt,
+			post.Status == PostPublished,
+		).Scan(&post.Version, &post.PublishedAt, &post.UpdatedAt)
+
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return fmt.Errorf("error updating post: %w", err)
+			}
+		}
+
+		// Mentions the new version dropped go away
+		post.Mentions, err = saveMentions(ctx, tx, "post_id", post.ID, post.UserID, post.Content)
+		if err != nil {
+			return err
+		}
 
-	if err != nil {
 		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+		case post.Hold != nil:
+			return holdForReview(ctx, tx, ReportTargetPost, post.ID, post.UserID, post.Title+"\n\n"+post.Content, post.Hold)
+		case firstPublished:
+			return notifyPublished(ctx, tx, post)
+		case post.Status == PostPublished:
+			return notifyPostMentions(ctx, tx, post)
 		default:
-			return fmt.Errorf("error updating post: %w", err)
+			return nil
 		}
+	})
+	if err != nil {
+		return err
 	}
+
+	s.rendered.render(post)
 	return nil
 }
 
+// GetRecentTexts returns the title and content of the posts the user created
+// since the given time, except the one with ID exceptID.
+func (s *PostStore) GetRecentTexts(ctx context.Context, userID int64, since time.Time, exceptID int64) ([]string, error) {
+	query := `
+		SELECT title || E'\n\n' || content FROM posts
+		WHERE user_id = $1 AND created_at >= $2 AND id <> $3
+	`
+	return getTexts(ctx, s.db, query, userID, since, exceptID)
+}
+
+func getTexts(ctx context.Context, db *sql.DB, query string, args ...any) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var texts []string
+	for rows.Next() {
+		var text string
+		if err := rows.Scan(&text); err != nil {
+			return nil, err
+		}
+		texts = append(texts, text)
+	}
+	return texts, rows.Err()
+}
+
 // Add this validation helper function
 func validatePost(post *Post) error {
 	if post.Title == "" {
 		return errors.New("title is required")
 	}
-	if len(post.Title) > 100 {
+	if utf8.RuneCountInString(post.Title) > 100 {
 		return errors.New("title must be less than 100 characters")
 	}
 	if post.Content == "" {
 		return errors.New("content is required")
 	}
-	if len(post.Content) > 1000 {
+	// The limit is on the Markdown source, not the HTML it renders to
+	if utf8.RuneCountInString(post.Content) > 1000 {
 		return errors.New("content must be less than 1000 characters")
 	}
 	return nil
 }
+
+// GetDrafts returns the posts of the user that are not published yet.
+func (s *PostStore) GetDrafts(ctx context.Context, userID int64) ([]Post, error) {
+	query := `
+	SELECT id, content, title, user_id, tags, status, publish_at, created_at, updated_at, version
+	FROM posts
+	WHERE user_id = $1 AND status <> 'published' AND deleted_at IS NULL
+	ORDER BY publish_at NULLS LAST, updated_at DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := []Post{}
+	for rows.Next() {
+		var p Post
+		if err := rows.Scan(
+			&p.ID,
+			&p.Content,
+			&p.Title,
+			&p.UserID,
+			pq.Array(&p.Tags),
+			&p.Status,
+			&p.PublishAt,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+			&p.Version,
+		); err != nil {
+			return nil, err
+		}
+		s.rendered.render(&p)
+		drafts = append(drafts, p)
+	}
+	return drafts, rows.Err()
+}
+
+// PublishDue publishes up to limit scheduled posts whose time has come and
+// returns them. Rows another instance is already publishing are skipped, so
+// each post is published exactly once however many schedulers run.
+func (s *PostStore) PublishDue(ctx context.Context, now time.Time, limit int) ([]Post, error) {
+	query := `
+	WITH due AS (
+		SELECT id FROM posts
+		WHERE status = 'scheduled' AND publish_at <= $1 AND deleted_at IS NULL
+		ORDER BY publish_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	), revisions AS (
+		INSERT INTO post_revisions (post_id, version, title, content, tags, created_at)
+		SELECT p.id, p.version, p.title, p.content, p.tags, p.updated_at
+		FROM posts p JOIN due ON due.id = p.id
+	)
+	UPDATE posts
+	SET status = 'published', published_at = NOW(), version = version + 1, updated_at = NOW()
+	FROM due
+	WHERE posts.id = due.id
+	RETURNING posts.id, posts.title, posts.user_id, posts.publish_at, posts.published_at
+	`
+	published := []Post{}
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		rows, err := tx.QueryContext(qctx, query, now, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			p := Post{Status: PostPublished}
+			if err := rows.Scan(&p.ID, &p.Title, &p.UserID, &p.PublishAt, &p.PublishedAt); err != nil {
+				return err
+			}
+			published = append(published, p)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		// Scheduled posts are announced now that they can be seen
+		for i := range published {
+			if err := notifyPublished(ctx, tx, &published[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return published, nil
+}
+
+// FeedItem is the event followers of an author receive when they publish a
+// post; clients fetch the post itself.
+type FeedItem struct {
+	ID          int64      `json:"id"`
+	Title       string     `json:"title"`
+	UserID      int64      `json:"user_id"`
+	PublishedAt *time.Time `json:"published_at"`
+}
+
+// notifyPublished notifies the users mentioned in a post that was just
+// published and pushes it to the feeds of the author's followers.
+func notifyPublished(ctx context.Context, tx *sql.Tx, post *Post) error {
+	if err := notifyPostMentions(ctx, tx, post); err != nil {
+		return err
+	}
+
+	msg, err := realtime.NewMessage(realtime.EventFeedPost, FeedItem{
+		ID:          post.ID,
+		Title:       post.Title,
+		UserID:      post.UserID,
+		PublishedAt: post.PublishedAt,
+	})
+	if err != nil {
+		return err
+	}
+	msg.FollowersOf = post.UserID
+	return realtime.Publish(ctx, tx, msg)
+}
+
+func notifyPostMentions(ctx context.Context, tx *sql.Tx, post *Post) error {
+	return notifyMentions(ctx, tx, "post_id", post.ID, notifications.Event{
+		ActorID: post.UserID,
+		PostID:  &post.ID,
+	})
+}
+
+// Restore takes the post out of the trash if it was deleted at or after
+// deletedSince, unless a moderator hid it.
+func (s *PostStore) Restore(ctx context.Context, postID int64, deletedSince time.Time) error {
+	query := `UPDATE posts SET deleted_at = NULL WHERE id = $1 AND deleted_at >= $2 AND NOT hidden`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, postID, deletedSince)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetDeleted returns the posts of the user deleted at or after deletedSince
+// and not hidden by a moderator, most recently deleted first.
+func (s *PostStore) GetDeleted(ctx context.Context, userID int64, deletedSince time.Time) ([]Post, error) {
+	query := `
+	SELECT id, content, title, user_id, tags, status, created_at, updated_at, version, deleted_at
+	FROM posts
+	WHERE user_id = $1 AND deleted_at >= $2 AND NOT hidden
+	ORDER BY deleted_at DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, deletedSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var p Post
+		if err := rows.Scan(
+			&p.ID,
+			&p.Content,
+			&p.Title,
+			&p.UserID,
+			pq.Array(&p.Tags),
+			&p.Status,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+			&p.Version,
+			&p.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		s.rendered.render(&p)
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// PurgeDeleted removes posts that were deleted before cutoff for good. Posts
+// with an unresolved report, such as those held for review, are kept until
+// it is resolved.
+func (s *PostStore) PurgeDeleted(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `
+		DELETE FROM posts t
+		WHERE t.deleted_at < $1
+		  AND NOT EXISTS (
+		      SELECT 1 FROM reports r
+		      WHERE r.target_type = 'post' AND r.target_id = t.id AND r.status <> 'resolved'
+		  )
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
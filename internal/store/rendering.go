@@ -0,0 +1,70 @@
+package store
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/nati3514/Social/internal/markdown"
+)
+
+// renderCacheSize is how many rendered posts are kept in memory.
+const renderCacheSize = 5000
+
+type renderKey struct {
+	postID  int64
+	version int32
+}
+
+type renderEntry struct {
+	key  renderKey
+	html string
+}
+
+// renderCache keeps the HTML of recently read posts. Every change to a post
+// bumps its version, so entries never go stale; the least recently used ones
+// are evicted when the cache is full.
+type renderCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[renderKey]*list.Element
+}
+
+func newRenderCache(size int) *renderCache {
+	return &renderCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[renderKey]*list.Element),
+	}
+}
+
+// render sets the ContentHTML of the post from its Markdown content.
+func (c *renderCache) render(post *Post) {
+	key := renderKey{post.ID, post.Version}
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		post.ContentHTML = el.Value.(*renderEntry).html
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	// Render outside the lock, at worst two readers render the same post
+	html := markdown.Render(post.Content)
+	post.ContentHTML = html
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&renderEntry{key, html})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*renderEntry).key)
+	}
+}
@@ -0,0 +1,507 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nati3514/Social/internal/notifications"
+	"github.com/nati3514/Social/internal/realtime"
+)
+
+const (
+	ReportTargetPost    = "post"
+	ReportTargetComment = "comment"
+	ReportTargetUser    = "user"
+
+	ReportOpen     = "open"
+	ReportClaimed  = "claimed"
+	ReportResolved = "resolved"
+
+	ReportActionDismiss = "dismiss"
+	ReportActionHide    = "hide"
+	ReportActionWarn    = "warn"
+	ReportActionSuspend = "suspend"
+
+	// moderationClaim is recorded in the audit trail when a moderator takes
+	// a report.
+	moderationClaim = "claim"
+)
+
+// ErrHideProfile is returned for hiding a reported user, who can only be
+// warned or suspended.
+var ErrHideProfile = errors.New("profiles can't be hidden, warn or suspend the user instead")
+
+type Report struct {
+	ID         int64  `json:"id"`
+	ReporterID *int64 `json:"reporter_id"`
+	TargetType string `json:"target_type"`
+	TargetID   int64  `json:"target_id"`
+	// TargetUserID is the author of the reported post or comment, or the
+	// reported user.
+	TargetUserID int64  `json:"target_user_id"`
+	Reason       string `json:"reason"`
+	Details      string `json:"details"`
+	// Snapshot is what was reported, as it read when it was reported.
+	Snapshot   string     `json:"snapshot"`
+	Status     string     `json:"status"`
+	ClaimedBy  *int64     `json:"claimed_by"`
+	ClaimedAt  *time.Time `json:"claimed_at"`
+	ResolvedBy *int64     `json:"resolved_by"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	Action     *string    `json:"action"`
+	Note       string     `json:"note"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// TargetReports is how many users reported the same target.
+	TargetReports int `json:"target_reports"`
+	// Actions are filled in only for a single report.
+	Actions []ModerationAction `json:"actions,omitempty"`
+}
+
+// Hold is why the content filters held a post or comment for review.
+type Hold struct {
+	// Category is the report reason the hold is filed under.
+	Category string `json:"-"`
+	Reason   string `json:"reason"`
+}
+
+// Decision is something moderators judged, for the spam classifier to learn
+// from.
+type Decision struct {
+	Text string
+	Spam bool
+}
+
+// ModerationAction is an entry of the audit trail.
+type ModerationAction struct {
+	ID          int64     `json:"id"`
+	ModeratorID *int64    `json:"moderator_id"`
+	ReportID    *int64    `json:"report_id"`
+	Action      string    `json:"action"`
+	TargetType  string    `json:"target_type"`
+	TargetID    int64     `json:"target_id"`
+	Note        string    `json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ReportStore struct {
+	db *sql.DB
+}
+
+// Create records the report. Reporting the same target twice is an
+// ErrConflict.
+func (s *ReportStore) Create(ctx context.Context, report *Report) error {
+	query := `
+		INSERT INTO reports (reporter_id, target_type, target_id, target_user_id, reason, details, snapshot)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, status, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		report.ReporterID,
+		report.TargetType,
+		report.TargetID,
+		report.TargetUserID,
+		report.Reason,
+		report.Details,
+		report.Snapshot,
+	).Scan(
+		&report.ID,
+		&report.Status,
+		&report.CreatedAt,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+const selectReports = `
+	SELECT r.id, r.reporter_id, r.target_type, r.target_id, r.target_user_id, r.reason,
+	       r.details, r.snapshot, r.status, r.claimed_by, r.claimed_at, r.resolved_by,
+	       r.resolved_at, r.action, r.note, r.created_at,
+	       (SELECT COUNT(*) FROM reports t WHERE t.target_type = r.target_type AND t.target_id = r.target_id)
+	FROM reports r
+`
+
+// List returns the reports with the status, oldest first, so the queue is
+// worked through in order.
+func (s *ReportStore) List(ctx context.Context, status string, limit, offset int) ([]Report, error) {
+	query := selectReports + `
+		WHERE r.status = $1
+		ORDER BY r.id
+		LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		var r Report
+		if err := scanReport(rows, &r); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// Get returns the report with its audit trail.
+func (s *ReportStore) Get(ctx context.Context, id int64) (*Report, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	report := &Report{}
+	err := scanReport(s.db.QueryRowContext(ctx, selectReports+`WHERE r.id = $1`, id), report)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query := `
+		SELECT id, moderator_id, report_id, action, target_type, target_id, note, created_at
+		FROM moderation_actions
+		WHERE report_id = $1
+		ORDER BY id
+	`
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report.Actions = []ModerationAction{}
+	for rows.Next() {
+		var a ModerationAction
+		if err := rows.Scan(
+			&a.ID,
+			&a.ModeratorID,
+			&a.ReportID,
+			&a.Action,
+			&a.TargetType,
+			&a.TargetID,
+			&a.Note,
+			&a.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		report.Actions = append(report.Actions, a)
+	}
+	return report, rows.Err()
+}
+
+func scanReport(row interface{ Scan(...any) error }, r *Report) error {
+	return row.Scan(
+		&r.ID,
+		&r.ReporterID,
+		&r.TargetType,
+		&r.TargetID,
+		&r.TargetUserID,
+		&r.Reason,
+		&r.Details,
+		&r.Snapshot,
+		&r.Status,
+		&r.ClaimedBy,
+		&r.ClaimedAt,
+		&r.ResolvedBy,
+		&r.ResolvedAt,
+		&r.Action,
+		&r.Note,
+		&r.CreatedAt,
+		&r.TargetReports,
+	)
+}
+
+// Claim assigns the open report to the moderator. A report that is already
+// claimed or resolved is an ErrConflict.
+func (s *ReportStore) Claim(ctx context.Context, id, moderatorID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		query := `
+			UPDATE reports SET status = 'claimed', claimed_by = $2, claimed_at = NOW()
+			WHERE id = $1 AND status = 'open'
+			RETURNING target_type, target_id
+		`
+		var targetType string
+		var targetID int64
+		err := tx.QueryRowContext(qctx, query, id, moderatorID).Scan(&targetType, &targetID)
+		if errors.Is(err, sql.ErrNoRows) {
+			var exists bool
+			if err := tx.QueryRowContext(qctx, `SELECT EXISTS (SELECT 1 FROM reports WHERE id = $1)`, id).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				return ErrNotFound
+			}
+			return ErrConflict
+		}
+		if err != nil {
+			return err
+		}
+
+		return recordModeration(qctx, tx, moderatorID, id, moderationClaim, targetType, targetID, "")
+	})
+}
+
+// Resolve takes the action on the target of the report and closes it, along
+// with every other unresolved report of the same target. Each reporter is
+// notified of the outcome, and warned users of the warning. Reports claimed
+// by another moderator, or already resolved, are an ErrConflict.
+func (s *ReportStore) Resolve(ctx context.Context, id, moderatorID int64, action, note string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		query := `
+			SELECT target_type, target_id, target_user_id, status, claimed_by
+			FROM reports WHERE id = $1
+			FOR UPDATE
+		`
+		var targetType, status string
+		var targetID, targetUserID int64
+		var claimedBy *int64
+		err := tx.QueryRowContext(qctx, query, id).Scan(&targetType, &targetID, &targetUserID, &status, &claimedBy)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+		if status == ReportResolved || (claimedBy != nil && *claimedBy != moderatorID) {
+			return ErrConflict
+		}
+
+		switch action {
+		case ReportActionDismiss:
+			// Dismissing a hold of the content filters lets the content through
+			if targetType == ReportTargetUser {
+				break
+			}
+			if err := releaseHold(ctx, tx, targetType, targetID); err != nil {
+				return err
+			}
+		case ReportActionHide:
+			if targetType == ReportTargetUser {
+				return ErrHideProfile
+			}
+			// posts or comments, never user input
+			query := fmt.Sprintf(`
+				UPDATE %ss SET deleted_at = COALESCE(deleted_at, NOW()), hidden = true
+				WHERE id = $1
+			`, targetType)
+			if _, err := tx.ExecContext(qctx, query, targetID); err != nil {
+				return err
+			}
+		case ReportActionSuspend:
+			query := `UPDATE users SET suspended_at = COALESCE(suspended_at, NOW()) WHERE id = $1`
+			if _, err := tx.ExecContext(qctx, query, targetUserID); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(qctx, `DELETE FROM sessions WHERE user_id = $1`, targetUserID); err != nil {
+				return err
+			}
+			if err := realtime.PublishDisconnect(ctx, tx, targetUserID); err != nil {
+				return err
+			}
+		case ReportActionWarn:
+			err := notifications.Add(ctx, tx, notifications.Event{
+				UserID:   targetUserID,
+				Type:     notifications.TypeWarning,
+				ReportID: &id,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		query = `
+			UPDATE reports
+			SET status = 'resolved', resolved_by = $3, resolved_at = NOW(), action = $4, note = $5
+			WHERE target_type = $1 AND target_id = $2 AND status <> 'resolved'
+			RETURNING id, reporter_id
+		`
+		rows, err := tx.QueryContext(qctx, query, targetType, targetID, moderatorID, action, note)
+		if err != nil {
+			return err
+		}
+		// Collected first, the transaction can't run other queries while
+		// rows are open
+		var resolved []Report
+		for rows.Next() {
+			var r Report
+			if err := rows.Scan(&r.ID, &r.ReporterID); err != nil {
+				rows.Close()
+				return err
+			}
+			resolved = append(resolved, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, r := range resolved {
+			if r.ReporterID == nil {
+				continue
+			}
+			err := notifications.Add(ctx, tx, notifications.Event{
+				UserID:   *r.ReporterID,
+				Type:     notifications.TypeReport,
+				ReportID: &r.ID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return recordModeration(qctx, tx, moderatorID, id, action, targetType, targetID, note)
+	})
+}
+
+// holdForReview hides a post or comment the content filters held, and files
+// a report without a reporter for moderators to review it.
+func holdForReview(ctx context.Context, tx *sql.Tx, targetType string, targetID, userID int64, snapshot string, hold *Hold) error {
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	// posts or comments, never user input
+	query := fmt.Sprintf(`UPDATE %ss SET deleted_at = NOW(), hidden = true WHERE id = $1`, targetType)
+	if _, err := tx.ExecContext(qctx, query, targetID); err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO reports (target_type, target_id, target_user_id, reason, details, snapshot)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := tx.ExecContext(qctx, query, targetType, targetID, userID, hold.Category, hold.Reason, snapshot)
+	return err
+}
+
+// releaseHold lets through a post or comment the content filters held, if it
+// is held, and sends the notifications that were held back with it.
+func releaseHold(ctx context.Context, tx *sql.Tx, targetType string, targetID int64) error {
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	// The earliest open hold tells whether the post was published by the
+	// same change that got it held. reports.created_at is rounded to the
+	// second, published_at is not.
+	query := fmt.Sprintf(`
+		WITH hold AS (
+			SELECT MIN(created_at) AS created_at FROM reports
+			WHERE target_type = $2 AND target_id = $1 AND reporter_id IS NULL AND status <> 'resolved'
+		)
+		UPDATE %ss t SET deleted_at = NULL, hidden = false
+		FROM hold
+		WHERE t.id = $1 AND t.hidden AND hold.created_at IS NOT NULL
+		RETURNING t.user_id, hold.created_at
+	`, targetType)
+
+	var userID int64
+	var heldAt time.Time
+	err := tx.QueryRowContext(qctx, query, targetID, targetType).Scan(&userID, &heldAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Not held, nothing was held back either
+			return nil
+		default:
+			return err
+		}
+	}
+
+	if targetType == ReportTargetComment {
+		comment := &Comment{ID: targetID, UserID: userID}
+		if err := tx.QueryRowContext(qctx, `SELECT post_id FROM comments WHERE id = $1`, targetID).Scan(&comment.PostID); err != nil {
+			return err
+		}
+		return notifyComment(ctx, tx, comment)
+	}
+
+	post := &Post{ID: targetID, UserID: userID}
+	query = `SELECT title, status, published_at FROM posts WHERE id = $1`
+	if err := tx.QueryRowContext(qctx, query, targetID).Scan(&post.Title, &post.Status, &post.PublishedAt); err != nil {
+		return err
+	}
+
+	switch {
+	case post.Status != PostPublished:
+		// The scheduler notifies when it goes out
+		return nil
+	case post.PublishedAt != nil && post.PublishedAt.After(heldAt.Add(-time.Second)):
+		return notifyPublished(ctx, tx, post)
+	default:
+		return notifyPostMentions(ctx, tx, post)
+	}
+}
+
+// GetDecisions returns what was reported in up to limit of the most recently
+// resolved reports of posts and comments, with whether moderators found it to
+// be spam. Reports dismissed as fine count as not spam, reports for other
+// reasons that were acted on are left out.
+func (s *ReportStore) GetDecisions(ctx context.Context, limit int) ([]Decision, error) {
+	query := `
+		SELECT snapshot, reason = 'spam' AND action <> 'dismiss'
+		FROM reports
+		WHERE status = 'resolved' AND target_type <> 'user' AND snapshot <> ''
+		  AND (action = 'dismiss' OR reason = 'spam')
+		ORDER BY resolved_at DESC
+		LIMIT $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	decisions := []Decision{}
+	for rows.Next() {
+		var d Decision
+		if err := rows.Scan(&d.Text, &d.Spam); err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions, rows.Err()
+}
+
+func recordModeration(ctx context.Context, tx *sql.Tx, moderatorID, reportID int64, action, targetType string, targetID int64, note string) error {
+	query := `
+		INSERT INTO moderation_actions (moderator_id, report_id, action, target_type, target_id, note)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := tx.ExecContext(ctx, query, moderatorID, reportID, action, targetType, targetID, note)
+	return err
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostRevision is a post as it was at a version. CreatedAt is when that
+// version was written.
+type PostRevision struct {
+	PostID    int64     `json:"post_id"`
+	Version   int32     `json:"version"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Tags      []string  `json:"tags"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PostRevisionStore struct {
+	db *sql.DB
+}
+
+// revisionsQuery selects the saved revisions of a post together with its
+// current version, so callers see the whole history.
+const revisionsQuery = `
+	SELECT post_id, version, title, content, tags, created_at
+	FROM post_revisions WHERE post_id = $1
+	UNION ALL
+	SELECT id, version, title, content, tags, updated_at
+	FROM posts WHERE id = $1
+`
+
+// GetByPost returns every version of the post, newest first.
+func (s *PostRevisionStore) GetByPost(ctx context.Context, postID int64) ([]PostRevision, error) {
+	query := revisionsQuery + ` ORDER BY version DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []PostRevision{}
+	for rows.Next() {
+		var rev PostRevision
+		if err := scanRevision(rows, &rev); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// Get returns the post as it was at version, which may be the current one.
+func (s *PostRevisionStore) Get(ctx context.Context, postID int64, version int32) (*PostRevision, error) {
+	query := `SELECT * FROM (` + revisionsQuery + `) AS revisions WHERE version = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rev := &PostRevision{}
+	if err := scanRevision(s.db.QueryRowContext(ctx, query, postID, version), rev); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return rev, nil
+}
+
+func scanRevision(row rowScanner, rev *PostRevision) error {
+	return row.Scan(
+		&rev.PostID,
+		&rev.Version,
+		&rev.Title,
+		&rev.Content,
+		pq.Array(&rev.Tags),
+		&rev.CreatedAt,
+	)
+}
+
+// saveRevision copies the post as it is at version into its history and
+// locks it for the update that follows. It fails with ErrEditConflict when
+// the post is no longer at version.
+func saveRevision(ctx context.Context, tx *sql.Tx, postID int64, version int32) error {
+	query := `
+		INSERT INTO post_revisions (post_id, version, title, content, tags, created_at)
+		SELECT id, version, title, content, tags, updated_at
+		FROM posts
+		WHERE id = $1 AND version = $2
+		FOR UPDATE
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, postID, version)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
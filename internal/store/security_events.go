@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	SecurityEventAccountLocked   = "account_locked"
+	SecurityEventAccountUnlocked = "account_unlocked"
+	SecurityEventIPLocked        = "ip_locked"
+
+	// Taken by an admin, whose ID is in the details.
+	SecurityEventAccountSuspended   = "account_suspended"
+	SecurityEventAccountUnsuspended = "account_unsuspended"
+	SecurityEventAccountActivated   = "account_activated"
+	SecurityEventSessionsReset      = "sessions_reset"
+	SecurityEventRoleChanged        = "role_changed"
+)
+
+type SecurityEvent struct {
+	ID        int64          `json:"id"`
+	UserID    *int64         `json:"user_id"`
+	Kind      string         `json:"kind"`
+	IP        string         `json:"ip"`
+	Details   map[string]any `json:"details"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type SecurityEventStore struct {
+	db *sql.DB
+}
+
+func (s *SecurityEventStore) Create(ctx context.Context, event *SecurityEvent) error {
+	query := `
+		INSERT INTO security_events (user_id, kind, ip, details)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at
+	`
+
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+	if event.Details == nil {
+		details = []byte("{}")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		event.UserID,
+		event.Kind,
+		event.IP,
+		details,
+	).Scan(
+		&event.ID,
+		&event.CreatedAt,
+	)
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+func (s *SessionStore) Create(ctx context.Context, userID int64, token string, exp time.Duration) error {
+	query := `INSERT INTO sessions (token, user_id, expiry) VALUES ($1, $2, $3)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, token, userID, time.Now().Add(exp))
+	return err
+}
+
+func (s *SessionStore) GetUserByToken(ctx context.Context, token string) (*User, error) {
+	query := `
+		SELECT u.id, u.username, u.email, u.created_at, u.activated, u.totp_enabled, u.role
+		FROM users u
+		JOIN sessions s ON u.id = s.user_id
+		WHERE s.token = $1 AND s.expiry > $2 AND u.suspended_at IS NULL
+	`
+	hash := sha256.Sum256([]byte(token))
+	hashToken := hex.EncodeToString(hash[:])
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	user := &User{}
+	err := s.db.QueryRowContext(ctx, query, hashToken, time.Now()).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.CreatedAt,
+		&user.IsActive,
+		&user.TwoFactorEnabled,
+		&user.Role,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func (s *SessionStore) CreateWSTicket(ctx context.Context, userID int64, token string, exp time.Duration) error {
+	query := `INSERT INTO ws_tickets (token, user_id, expiry) VALUES ($1, $2, $3)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, token, userID, time.Now().Add(exp))
+	return err
+}
+
+// ConsumeWSTicket returns the user of an unexpired ticket. Tickets can only
+// be used once.
+func (s *SessionStore) ConsumeWSTicket(ctx context.Context, token string) (*User, error) {
+	query := `
+		WITH t AS (
+			DELETE FROM ws_tickets WHERE token = $1
+			RETURNING user_id, expiry
+		)
+		SELECT u.id, u.username, u.email, u.created_at, u.activated, u.totp_enabled, u.role
+		FROM users u
+		JOIN t ON u.id = t.user_id
+		WHERE t.expiry > $2 AND u.suspended_at IS NULL
+	`
+	hash := sha256.Sum256([]byte(token))
+	hashToken := hex.EncodeToString(hash[:])
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	user := &User{}
+	err := s.db.QueryRowContext(ctx, query, hashToken, time.Now()).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.CreatedAt,
+		&user.IsActive,
+		&user.TwoFactorEnabled,
+		&user.Role,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func (s *SessionStore) DeleteExpiredWSTickets(ctx context.Context) (int64, error) {
+	query := `DELETE FROM ws_tickets WHERE expiry <= $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
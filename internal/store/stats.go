@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DailyStats is the activity on the platform on a day, in UTC.
+type DailyStats struct {
+	Day      string `json:"day"`
+	Signups  int    `json:"signups"`
+	Posts    int    `json:"posts"`
+	Comments int    `json:"comments"`
+	Follows  int    `json:"follows"`
+}
+
+type StatsStore struct {
+	db *sql.DB
+}
+
+// GetDaily returns the activity of every day from the first to the last,
+// both included. Posts count when they were published. Whatever was deleted
+// since doesn't count anymore.
+func (s *StatsStore) GetDaily(ctx context.Context, from, to time.Time) ([]DailyStats, error) {
+	query := `
+		WITH days AS (
+			SELECT (generate_series($1::timestamptz, $2::timestamptz - INTERVAL '1 day', INTERVAL '1 day') AT TIME ZONE 'UTC')::date AS day
+		), signups AS (
+			SELECT (created_at AT TIME ZONE 'UTC')::date AS day, COUNT(*) AS n
+			FROM users
+			WHERE created_at >= $1 AND created_at < $2
+			GROUP BY 1
+		), published AS (
+			SELECT (published_at AT TIME ZONE 'UTC')::date AS day, COUNT(*) AS n
+			FROM posts
+			WHERE published_at >= $1 AND published_at < $2 AND deleted_at IS NULL
+			GROUP BY 1
+		), commented AS (
+			SELECT (created_at AT TIME ZONE 'UTC')::date AS day, COUNT(*) AS n
+			FROM comments
+			WHERE created_at >= $1 AND created_at < $2 AND deleted_at IS NULL
+			GROUP BY 1
+		), follows AS (
+			SELECT (created_at AT TIME ZONE 'UTC')::date AS day, COUNT(*) AS n
+			FROM followers
+			WHERE created_at >= $1 AND created_at < $2
+			GROUP BY 1
+		)
+		SELECT to_char(d.day, 'YYYY-MM-DD'), COALESCE(s.n, 0), COALESCE(p.n, 0), COALESCE(c.n, 0), COALESCE(f.n, 0)
+		FROM days d
+		LEFT JOIN signups s ON s.day = d.day
+		LEFT JOIN published p ON p.day = d.day
+		LEFT JOIN commented c ON c.day = d.day
+		LEFT JOIN follows f ON f.day = d.day
+		ORDER BY d.day
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(to.Year(), to.Month(), to.Day()+1, 0, 0, 0, 0, time.UTC)
+	rows, err := s.db.QueryContext(ctx, query, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []DailyStats{}
+	for rows.Next() {
+		var d DailyStats
+		if err := rows.Scan(&d.Day, &d.Signups, &d.Posts, &d.Comments, &d.Follows); err != nil {
+			return nil, err
+		}
+		stats = append(stats, d)
+	}
+	return stats, rows.Err()
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/nati3514/Social/internal/notifications"
 )
 
 var (
@@ -16,10 +18,20 @@ var (
 type Storage struct {
 	Posts interface {
 		GetByID(context.Context, int64) (*Post, error)
+		GetByIDWithDeleted(context.Context, int64) (*Post, error)
 		Create(context.Context, *Post) error
 		Update(context.Context, *Post) error
 		Delete(context.Context, int64) error
+		Restore(ctx context.Context, postID int64, deletedSince time.Time) error
+		GetDeleted(ctx context.Context, userID int64, deletedSince time.Time) ([]Post, error)
+		GetRecentTexts(ctx context.Context, userID int64, since time.Time, exceptID int64) ([]string, error)
+		PurgeDeleted(context.Context, time.Time) (int64, error)
+		CreateWithMedia(ctx context.Context, post *Post, mediaIDs []int64) error
+		GetDrafts(context.Context, int64) ([]Post, error)
+		PublishDue(ctx context.Context, now time.Time, limit int) ([]Post, error)
 		GetUserFeed(context.Context, int64) ([]PostWithMetadata, error)
+		GetFeedSince(ctx context.Context, userID int64, after FeedCursor, limit int) ([]PostWithMetadata, error)
+		GetFeedPost(ctx context.Context, userID, postID int64) (*PostWithMetadata, error)
 	}
 
 	Users interface {
@@ -27,23 +39,194 @@ type Storage struct {
 		Create(context.Context, *sql.Tx, *User) error
 		CreateAndInvite(ctx context.Context, user *User, token string, exp time.Duration) error
 		Activate(context.Context, string) error
+		GetByEmail(context.Context, string) (*User, error)
+		UpdateProfile(context.Context, *User) error
+		CreateEmailChange(ctx context.Context, userID int64, newEmail, token string, exp time.Duration) error
+		ConfirmEmailChange(context.Context, string) error
+		ChangeUsername(ctx context.Context, userID int64, username string, cooldown time.Duration) error
+		ScheduleDeletion(ctx context.Context, userID int64, at time.Time) error
+		CancelDeletion(context.Context, int64) (bool, error)
+		DeleteScheduled(context.Context, time.Time) (int64, error)
+		CreatePasswordReset(ctx context.Context, userID int64, token string, exp time.Duration) error
+		ResetPassword(ctx context.Context, token, newPassword string) error
+		ReplaceInvitation(ctx context.Context, userID int64, token string, exp time.Duration) error
+		DeleteExpiredInvitations(context.Context) (int64, error)
+		DeleteUnactivatedBefore(context.Context, time.Time) (int64, error)
+		List(ctx context.Context, filter UserFilter, limit, offset int) ([]User, error)
+		GetCounts(context.Context, int64) (*UserCounts, error)
+		Suspend(context.Context, int64) (bool, error)
+		Unsuspend(context.Context, int64) (bool, error)
+		ForceActivate(context.Context, int64) (bool, error)
+		SignOutEverywhere(context.Context, int64) (int64, error)
+		SetRole(ctx context.Context, userID int64, role string) error
 	}
 	Comments interface {
 		Create(context.Context, *Comment) error
 		GetByPostsID(context.Context, int64) ([]Comment, error)
+		GetByPostsIDWithDeleted(context.Context, int64) ([]Comment, error)
+		GetByID(context.Context, int64) (*Comment, error)
+		Delete(context.Context, int64) error
+		Restore(ctx context.Context, id int64, deletedSince time.Time) error
+		GetDeleted(ctx context.Context, userID int64, deletedSince time.Time) ([]Comment, error)
+		GetRecentTexts(ctx context.Context, userID int64, since time.Time, exceptID int64) ([]string, error)
+		PurgeDeleted(context.Context, time.Time) (int64, error)
 	}
 	Followers interface {
 		Follow(ctx context.Context, followerID, userID int64) error
 		Unfollow(ctx context.Context, follwerID, userID int64) error
+		FilterFollowers(ctx context.Context, userID int64, candidates []int64) ([]int64, error)
+	}
+	Blocks interface {
+		Block(ctx context.Context, blockerID, blockedID int64) error
+		Unblock(ctx context.Context, blockerID, blockedID int64) error
+		IsBlocked(ctx context.Context, userID, otherID int64) (bool, error)
+		BlockedByAny(ctx context.Context, userID int64, otherIDs []int64) (bool, error)
+		GetBlockers(context.Context, int64) (map[int64]bool, error)
+		GetBlocked(context.Context, int64) ([]RelatedUser, error)
+	}
+	Mutes interface {
+		Mute(ctx context.Context, muterID, mutedID int64) error
+		Unmute(ctx context.Context, muterID, mutedID int64) error
+		GetMuted(context.Context, int64) ([]RelatedUser, error)
+	}
+	Reports interface {
+		Create(context.Context, *Report) error
+		List(ctx context.Context, status string, limit, offset int) ([]Report, error)
+		Get(context.Context, int64) (*Report, error)
+		Claim(ctx context.Context, id, moderatorID int64) error
+		Resolve(ctx context.Context, id, moderatorID int64, action, note string) error
+		GetDecisions(ctx context.Context, limit int) ([]Decision, error)
+	}
+	Sessions interface {
+		Create(ctx context.Context, userID int64, token string, exp time.Duration) error
+		GetUserByToken(context.Context, string) (*User, error)
+		CreateWSTicket(ctx context.Context, userID int64, token string, exp time.Duration) error
+		ConsumeWSTicket(context.Context, string) (*User, error)
+		DeleteExpiredWSTickets(context.Context) (int64, error)
+	}
+	LoginThrottles interface {
+		Get(ctx context.Context, scope, key string) (*LoginThrottle, error)
+		RecordFailure(ctx context.Context, scope, key string, window time.Duration) (*LoginThrottle, error)
+		Lock(ctx context.Context, scope, key string, until time.Time) error
+		Reset(ctx context.Context, scope, key string) error
+		CreateUnlockToken(ctx context.Context, userID int64, token string, exp time.Duration) error
+		Unlock(ctx context.Context, token string) (int64, error)
+	}
+	SecurityEvents interface {
+		Create(context.Context, *SecurityEvent) error
+	}
+	TwoFactor interface {
+		Get(context.Context, int64) (*TwoFactor, error)
+		SetPendingSecret(ctx context.Context, userID int64, secret string) error
+		Enable(ctx context.Context, userID, step int64, recoveryCodeHashes []string) error
+		Disable(context.Context, int64) error
+		UseStep(ctx context.Context, userID, step int64) error
+		UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error
+		CreateChallenge(ctx context.Context, userID int64, token string, exp time.Duration) error
+		AttemptChallenge(ctx context.Context, token string, maxAttempts int) (int64, error)
+		DeleteChallenge(context.Context, string) error
+	}
+	Identities interface {
+		CreateLoginState(ctx context.Context, state string, ls *OIDCLoginState, exp time.Duration) error
+		ConsumeLoginState(context.Context, string) (*OIDCLoginState, error)
+		DeleteExpiredLoginStates(context.Context) (int64, error)
+		GetUser(ctx context.Context, provider, subject string) (*User, error)
+		Link(context.Context, *Identity) error
+		CreateUser(ctx context.Context, user *User, base string, identity *Identity) error
+	}
+	AccessTokens interface {
+		Create(ctx context.Context, t *AccessToken, token string) error
+		GetByUser(context.Context, int64) ([]AccessToken, error)
+		Revoke(ctx context.Context, userID, tokenID int64) error
+		GetUserByToken(context.Context, string) (*User, []string, error)
+	}
+	DataExports interface {
+		Create(context.Context, int64) (*DataExport, error)
+		GetLatest(context.Context, int64) (*DataExport, error)
+		Complete(ctx context.Context, export *DataExport, archive []byte, token string, exp time.Duration) error
+		Fail(context.Context, int64) error
+		GetArchive(context.Context, string) ([]byte, error)
+		DeleteExpired(ctx context.Context, staleBefore time.Time) (int64, error)
+		Collect(context.Context, int64) (*UserData, error)
+	}
+	Mentions interface {
+		GetByPosts(context.Context, []int64) (map[int64][]Mention, error)
+		GetByComments(context.Context, []int64) (map[int64][]Mention, error)
+		GetForUser(ctx context.Context, userID int64, limit, offset int) ([]MentionedIn, error)
+	}
+	Notifications interface {
+		List(ctx context.Context, userID, before int64, limit int) ([]notifications.Notification, error)
+		UnreadCount(context.Context, int64) (int, error)
+		MarkRead(ctx context.Context, userID, upTo int64) (int64, error)
+		DeleteReadBefore(context.Context, time.Time) (int64, error)
+	}
+	Conversations interface {
+		CanMessage(ctx context.Context, senderID int64, userIDs []int64) (map[int64]bool, error)
+		Create(ctx context.Context, c *Conversation, memberIDs []int64) (bool, error)
+		GetForUser(ctx context.Context, userID int64, limit, offset int) ([]Conversation, error)
+		Get(ctx context.Context, id, userID int64) (*Conversation, error)
+		GetMessages(ctx context.Context, conversationID, before int64, limit int) ([]Message, error)
+		CreateMessage(context.Context, *Message) error
+		MarkRead(ctx context.Context, conversationID, userID, upTo int64) (int64, error)
+	}
+	LinkPreviews interface {
+		GetFresh(context.Context, []string) (map[string]LinkPreview, error)
+		Upsert(context.Context, *LinkPreview) error
+		DeleteExpired(context.Context) (int64, error)
+	}
+	Revisions interface {
+		GetByPost(context.Context, int64) ([]PostRevision, error)
+		Get(ctx context.Context, postID int64, version int32) (*PostRevision, error)
+	}
+	Media interface {
+		Create(context.Context, *Media) error
+		GetByID(context.Context, int64) (*Media, error)
+		GetByPost(context.Context, int64) ([]Media, error)
+		UpdateAltText(context.Context, *Media) error
+		DeleteUnattachedBefore(context.Context, time.Time) ([]Media, error)
+		DeleteOfScheduledUsers(context.Context, time.Time) ([]Media, error)
+	}
+	Bookmarks interface {
+		Save(ctx context.Context, userID, postID int64, collectionID *int64) error
+		Delete(ctx context.Context, userID, postID int64) error
+		IsBookmarked(ctx context.Context, userID, postID int64) (bool, error)
+		List(ctx context.Context, userID int64, collectionID *int64, before int64, limit int) ([]Bookmark, error)
+		CreateCollection(context.Context, *BookmarkCollection) error
+		GetCollections(context.Context, int64) ([]BookmarkCollection, error)
+		RenameCollection(context.Context, *BookmarkCollection) error
+		DeleteCollection(ctx context.Context, userID, id int64) error
+	}
+	Stats interface {
+		GetDaily(ctx context.Context, from, to time.Time) ([]DailyStats, error)
 	}
 }
 
 func NewStorage(db *sql.DB) Storage {
+	rendered := newRenderCache(renderCacheSize)
+
 	return Storage{
-		Posts:     &PostStore{db},
-		Users:     &UserStore{db},
-		Comments:  &CommentStore{db},
-		Followers: &FollowerStore{db},
+		Posts:          &PostStore{db, rendered},
+		Users:          &UserStore{db},
+		Comments:       &CommentStore{db},
+		Followers:      &FollowerStore{db},
+		Blocks:         &BlockStore{db},
+		Mutes:          &MuteStore{db},
+		Reports:        &ReportStore{db},
+		Sessions:       &SessionStore{db},
+		LoginThrottles: &LoginThrottleStore{db},
+		SecurityEvents: &SecurityEventStore{db},
+		TwoFactor:      &TwoFactorStore{db},
+		Identities:     &IdentityStore{db},
+		AccessTokens:   &AccessTokenStore{db},
+		DataExports:    &DataExportStore{db},
+		Media:          &MediaStore{db},
+		Revisions:      &PostRevisionStore{db},
+		LinkPreviews:   &LinkPreviewStore{db},
+		Mentions:       &MentionStore{db},
+		Notifications:  notifications.NewStore(db),
+		Conversations:  &ConversationStore{db},
+		Bookmarks:      &BookmarkStore{db, rendered},
+		Stats:          &StatsStore{db},
 	}
 }
 
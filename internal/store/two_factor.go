@@ -0,0 +1,238 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	ErrCodeReused       = errors.New("this code has already been used")
+)
+
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+type TwoFactorStore struct {
+	db *sql.DB
+}
+
+func (s *TwoFactorStore) Get(ctx context.Context, userID int64) (*TwoFactor, error) {
+	query := `
+		SELECT COALESCE(totp_secret, ''), totp_enabled, totp_last_step
+		FROM users
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	tf := &TwoFactor{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&tf.Secret,
+		&tf.Enabled,
+		&tf.LastStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return tf, nil
+}
+
+// SetPendingSecret stores a secret that only takes effect once Enable confirms
+// the user can generate codes from it.
+func (s *TwoFactorStore) SetPendingSecret(ctx context.Context, userID int64, secret string) error {
+	query := `
+		UPDATE users SET totp_secret = $1
+		WHERE id = $2 AND totp_enabled = FALSE
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, secret, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+// Enable turns on two-factor authentication and replaces the user's recovery
+// codes with the given hashes.
+func (s *TwoFactorStore) Enable(ctx context.Context, userID, step int64, recoveryCodeHashes []string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE users SET totp_enabled = TRUE, totp_last_step = $1
+			WHERE id = $2 AND totp_enabled = FALSE AND totp_secret IS NOT NULL
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, step, userID)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrTwoFactorEnabled
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		for _, hash := range recoveryCodeHashes {
+			if _, err := tx.ExecContext(
+				ctx,
+				`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+				userID,
+				hash,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *TwoFactorStore) Disable(ctx context.Context, userID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0
+			WHERE id = $1
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID)
+		return err
+	})
+}
+
+// UseStep records the time step of an accepted code. A code from the same or
+// an earlier step is rejected with ErrCodeReused.
+func (s *TwoFactorStore) UseStep(ctx context.Context, userID, step int64) error {
+	query := `
+		UPDATE users SET totp_last_step = $1
+		WHERE id = $2 AND totp_last_step < $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrCodeReused
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It returns
+// ErrNotFound when the code does not exist or was already used.
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	query := `
+		UPDATE user_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *TwoFactorStore) CreateChallenge(ctx context.Context, userID int64, token string, exp time.Duration) error {
+	query := `INSERT INTO login_challenges (token, user_id, expiry) VALUES ($1, $2, $3)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, token, userID, time.Now().Add(exp))
+	return err
+}
+
+// AttemptChallenge counts an attempt against an unexpired challenge and
+// returns the user it belongs to. Challenges with maxAttempts or more
+// attempts are treated as not found.
+func (s *TwoFactorStore) AttemptChallenge(ctx context.Context, token string, maxAttempts int) (int64, error) {
+	query := `
+		UPDATE login_challenges SET attempts = attempts + 1
+		WHERE token = $1 AND expiry > $2 AND attempts < $3
+		RETURNING user_id
+	`
+	hash := sha256.Sum256([]byte(token))
+	hashToken := hex.EncodeToString(hash[:])
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	var userID int64
+	err := s.db.QueryRowContext(ctx, query, hashToken, time.Now(), maxAttempts).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+	return userID, nil
+}
+
+func (s *TwoFactorStore) DeleteChallenge(ctx context.Context, token string) error {
+	query := `DELETE FROM login_challenges WHERE token = $1`
+	hash := sha256.Sum256([]byte(token))
+	hashToken := hex.EncodeToString(hash[:])
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, hashToken)
+	return err
+}
@@ -6,23 +6,107 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"errors"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/lib/pq"
+	"github.com/nati3514/Social/internal/realtime"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrDuplicateEmail    = errors.New("a user with that email already exists")
 	ErrDuplicateUsername = errors.New("a user with that username already exists")
+	ErrUsernameCooldown  = errors.New("the username was changed too recently")
+)
+
+// reservedUsernames can't be registered or switched to, so nobody can pass
+// themselves off as staff or shadow a route.
+var reservedUsernames = map[string]bool{
+	"admin":         true,
+	"administrator": true,
+	"api":           true,
+	"me":            true,
+	"moderator":     true,
+	"root":          true,
+	"social":        true,
+	"support":       true,
+	"system":        true,
+}
+
+func IsReservedUsername(username string) bool {
+	return reservedUsernames[strings.ToLower(username)]
+}
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
 )
 
 type User struct {
-	ID        int64    `json:"id"`
-	Username  string   `json:"username"`
-	Email     string   `json:"email"`
-	Password  password `json:"-"`
-	CreatedAt string   `json:"created_at"`
-	IsActive  bool     `json:"is_active"`
+	ID               int64    `json:"id"`
+	Username         string   `json:"username"`
+	Email            string   `json:"email"`
+	Password         password `json:"-"`
+	DisplayName      string   `json:"display_name"`
+	Bio              string   `json:"bio"`
+	Location         string   `json:"location"`
+	Website          string   `json:"website"`
+	AvatarURL        string   `json:"avatar_url"`
+	Version          int32    `json:"version"`
+	CreatedAt        string   `json:"created_at"`
+	UpdatedAt        string   `json:"updated_at"`
+	IsActive         bool     `json:"is_active"`
+	TwoFactorEnabled bool     `json:"two_factor_enabled"`
+	// DeletionScheduledAt is set while the account waits out the grace
+	// period before it is deleted for good.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+	Role                string     `json:"role"`
+	// SuspendedAt is set while a moderator has suspended the account, which
+	// can't be logged into or used meanwhile.
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+	// OpenDMs lets users who aren't followed by this one message them.
+	OpenDMs bool `json:"open_dms"`
+}
+
+// IsModerator reports whether the user may see and act on content that is
+// hidden from others, such as deleted posts.
+func (u *User) IsModerator() bool {
+	return u.Role == RoleModerator || u.Role == RoleAdmin
+}
+
+// IsAdmin reports whether the user may manage other accounts.
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// PublicProfile is what other users get to see of an account.
+type PublicProfile struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	Location    string `json:"location"`
+	Website     string `json:"website"`
+	AvatarURL   string `json:"avatar_url"`
+	OpenDMs     bool   `json:"open_dms"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func (u *User) PublicProfile() *PublicProfile {
+	return &PublicProfile{
+		ID:          u.ID,
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		Location:    u.Location,
+		Website:     u.Website,
+		AvatarURL:   u.AvatarURL,
+		OpenDMs:     u.OpenDMs,
+		CreatedAt:   u.CreatedAt,
+	}
 }
 
 type password struct {
@@ -41,34 +125,163 @@ func (p *password) Set(text string) error {
 	return nil
 }
 
+// dummyHash is compared against when there is no stored hash, so checking the
+// password of a user that does not exist takes as long as of one that does.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func (p *password) Compare(text string) error {
+	if len(p.Hash) == 0 {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(text))
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
+	return bcrypt.CompareHashAndPassword(p.Hash, []byte(text))
+}
+
 type UserStore struct {
 	db *sql.DB
 }
 
 func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 	query := `
-      	INSERT INTO users (username, password, email) VALUES($1, $2, $3) RETURNING id,
+      	INSERT INTO users (username, password, email, activated) VALUES($1, $2, $3, $4) RETURNING id,
         created_at`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
-	err := s.db.QueryRowContext(
+	held, err := s.usernameHeld(ctx, tx, user.Username, 0)
+	if err != nil {
+		return err
+	}
+	if held {
+		return ErrDuplicateUsername
+	}
+
+	err = tx.QueryRowContext(
 		ctx,
 		query,
 		user.Username,
 		user.Password.Hash,
 		user.Email,
+		user.IsActive,
 	).Scan(
 		&user.ID,
 		&user.CreatedAt,
 	)
+	if err != nil {
+		return uniqueUserError(err)
+	}
+	return nil
+}
+
+// uniqueUserError turns a unique violation on the users table into
+// ErrDuplicateEmail or ErrDuplicateUsername.
+func uniqueUserError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return err
+	}
+
+	switch pqErr.Constraint {
+	case "users_email_key":
+		return ErrDuplicateEmail
+	case "users_username_key":
+		return ErrDuplicateUsername
+	default:
+		return err
+	}
+}
+
+// usernameHeld reports whether another user than userID went by username
+// before. Old names stay reserved so they cannot be taken over.
+func (s *UserStore) usernameHeld(ctx context.Context, tx *sql.Tx, username string, userID int64) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM previous_usernames WHERE username = $1 AND user_id <> $2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	var held bool
+	err := tx.QueryRowContext(ctx, query, username, userID).Scan(&held)
+	return held, err
+}
+
+func (s *UserStore) GetByID(ctx context.Context, id int64) (*User, error) {
+	query := `
+		SELECT id, username, email, password, display_name, bio, location, website,
+		       avatar_url, version, created_at, updated_at, activated, totp_enabled,
+		       deletion_scheduled_at, role, open_dms, suspended_at
+		FROM users
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	user := &User{}
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Password.Hash,
+		&user.DisplayName,
+		&user.Bio,
+		&user.Location,
+		&user.Website,
+		&user.AvatarURL,
+		&user.Version,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.IsActive,
+		&user.TwoFactorEnabled,
+		&user.DeletionScheduledAt,
+		&user.Role,
+		&user.OpenDMs,
+		&user.SuspendedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// UpdateProfile saves the profile fields of the user, as long as nobody else
+// changed them since the user was read.
+func (s *UserStore) UpdateProfile(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET display_name = $1, bio = $2, location = $3, website = $4, avatar_url = $5,
+		    open_dms = $8, version = version + 1, updated_at = NOW()
+		WHERE id = $6 AND version = $7
+		RETURNING version, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		user.DisplayName,
+		user.Bio,
+		user.Location,
+		user.Website,
+		user.AvatarURL,
+		user.ID,
+		user.Version,
+		user.OpenDMs,
+	).Scan(&user.Version, &user.UpdatedAt)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "user_email_key`:
-			return ErrDuplicateEmail
-		case err.Error() == `pq: duplicate key value constraint "user_username_key"`:
-			return ErrDuplicateUsername
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
 		default:
 			return err
 		}
@@ -76,23 +289,26 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 	return nil
 }
 
-func (s *UserStore) GetByID(ctx context.Context, id int64) (*User, error) {
+func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, username, email, password, created_at
+		SELECT id, username, email, password, created_at, activated, totp_enabled, suspended_at
 		FROM users
-		WHERE id = $1
+		WHERE email = $1
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
 	user := &User{}
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
+	err := s.db.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
-		&user.Password,
+		&user.Password.Hash,
 		&user.CreatedAt,
+		&user.IsActive,
+		&user.TwoFactorEnabled,
+		&user.SuspendedAt,
 	)
 	if err != nil {
 		switch err {
@@ -219,3 +435,574 @@ func (s *UserStore) deleteUserInvitations(ctx context.Context, tx *sql.Tx, userI
 
 	return nil
 }
+
+func (s *UserStore) CreatePasswordReset(ctx context.Context, userID int64, token string, exp time.Duration) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		// only the most recently requested token stays valid
+		if err := s.deletePasswordResets(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO password_resets (token, user_id, expiry) VALUES ($1, $2, $3)`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		_, err := tx.ExecContext(ctx, query, token, userID, time.Now().Add(exp))
+		return err
+	})
+}
+
+func (s *UserStore) ResetPassword(ctx context.Context, token, newPassword string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		// 1. find the user that this token belongs to
+		user, err := s.getUserFromPasswordReset(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+
+		// 2. set the new password
+		if err := user.Password.Set(newPassword); err != nil {
+			return err
+		}
+		if err := s.updatePassword(ctx, tx, user); err != nil {
+			return err
+		}
+
+		// 3. log out everywhere
+		if err := s.deleteUserSessions(ctx, tx, user.ID); err != nil {
+			return err
+		}
+
+		// 4. the token is single use
+		if err := s.deletePasswordResets(ctx, tx, user.ID); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (s *UserStore) getUserFromPasswordReset(ctx context.Context, tx *sql.Tx, token string) (*User, error) {
+	query := `
+	SELECT u.id, u.username, u.email, u.created_at, u.activated
+	FROM users u
+	JOIN password_resets pr ON u.id = pr.user_id
+	WHERE pr.token = $1 AND pr.expiry > $2
+	FOR UPDATE OF pr
+	`
+	hash := sha256.Sum256([]byte(token))
+	hashToken := hex.EncodeToString(hash[:])
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	user := &User{}
+	err := tx.QueryRowContext(ctx, query, hashToken, time.Now()).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.CreatedAt,
+		&user.IsActive,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func (s *UserStore) updatePassword(ctx context.Context, tx *sql.Tx, user *User) error {
+	query := `UPDATE users SET password = $1 WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, user.Password.Hash, user.ID)
+	return err
+}
+
+func (s *UserStore) deletePasswordResets(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `DELETE FROM password_resets WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
+}
+
+// deleteUserSessions ends every session of the user, and closes their
+// WebSocket connections once tx commits.
+func (s *UserStore) deleteUserSessions(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `DELETE FROM sessions WHERE user_id = $1`
+
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	if _, err := tx.ExecContext(qctx, query, userID); err != nil {
+		return err
+	}
+	return realtime.PublishDisconnect(ctx, tx, userID)
+}
+
+func (s *UserStore) ReplaceInvitation(ctx context.Context, userID int64, token string, invitationExp time.Duration) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.deleteUserInvitations(ctx, tx, userID); err != nil {
+			return err
+		}
+		return s.createUserInvitation(ctx, tx, token, invitationExp, userID)
+	})
+}
+
+func (s *UserStore) DeleteExpiredInvitations(ctx context.Context) (int64, error) {
+	query := `DELETE FROM user_invitations WHERE expiry <= $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteUnactivatedBefore removes accounts that were never activated and were
+// created before the cutoff. Their invitations go with them via the foreign key.
+func (s *UserStore) DeleteUnactivatedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `DELETE FROM users WHERE activated = FALSE AND created_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *UserStore) CreateEmailChange(ctx context.Context, userID int64, newEmail, token string, exp time.Duration) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		// only the most recently requested change stays pending
+		if err := s.deleteEmailChanges(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO email_changes (token, user_id, new_email, expiry) VALUES ($1, $2, $3, $4)`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		_, err := tx.ExecContext(ctx, query, token, userID, newEmail, time.Now().Add(exp))
+		return err
+	})
+}
+
+// ConfirmEmailChange swaps in the pending address the token was sent to. Any
+// password reset links that went to the old address stop working.
+func (s *UserStore) ConfirmEmailChange(ctx context.Context, token string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		// 1. find the pending change
+		userID, newEmail, err := s.getEmailChange(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+
+		// 2. update the user
+		query := `UPDATE users SET email = $1 WHERE id = $2`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		if _, err := tx.ExecContext(qctx, query, newEmail, userID); err != nil {
+			return uniqueUserError(err)
+		}
+
+		// 3. the token is single use
+		if err := s.deleteEmailChanges(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		return s.deletePasswordResets(ctx, tx, userID)
+	})
+}
+
+func (s *UserStore) getEmailChange(ctx context.Context, tx *sql.Tx, token string) (int64, string, error) {
+	query := `
+	SELECT user_id, new_email
+	FROM email_changes
+	WHERE token = $1 AND expiry > $2
+	FOR UPDATE
+	`
+	hash := sha256.Sum256([]byte(token))
+	hashToken := hex.EncodeToString(hash[:])
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	var (
+		userID   int64
+		newEmail string
+	)
+	err := tx.QueryRowContext(ctx, query, hashToken, time.Now()).Scan(&userID, &newEmail)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return 0, "", ErrNotFound
+		default:
+			return 0, "", err
+		}
+	}
+	return userID, newEmail, nil
+}
+
+func (s *UserStore) deleteEmailChanges(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `DELETE FROM email_changes WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
+}
+
+// ChangeUsername renames the user unless they already did so within the
+// cooldown. The old name is kept in previous_usernames so nobody else can
+// claim it, while the user themselves may switch back to it.
+func (s *UserStore) ChangeUsername(ctx context.Context, userID int64, username string, cooldown time.Duration) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		held, err := s.usernameHeld(ctx, tx, username, userID)
+		if err != nil {
+			return err
+		}
+		if held {
+			return ErrDuplicateUsername
+		}
+
+		query := `
+		UPDATE users u
+		SET username = $1, username_changed_at = NOW(), version = u.version + 1, updated_at = NOW()
+		FROM (SELECT id, username FROM users WHERE id = $2 FOR UPDATE) old
+		WHERE u.id = old.id
+		  AND (u.username_changed_at IS NULL OR u.username_changed_at < $3)
+		RETURNING old.username
+		`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		var previous string
+		err = tx.QueryRowContext(qctx, query, username, userID, time.Now().Add(-cooldown)).Scan(&previous)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrUsernameCooldown
+			default:
+				return uniqueUserError(err)
+			}
+		}
+
+		query = `
+		INSERT INTO previous_usernames (username, user_id) VALUES ($1, $2)
+		ON CONFLICT (username) DO UPDATE SET changed_at = NOW()
+		`
+		if _, err := tx.ExecContext(qctx, query, previous, userID); err != nil {
+			return err
+		}
+
+		query = `DELETE FROM previous_usernames WHERE username = $1 AND user_id = $2`
+		_, err = tx.ExecContext(qctx, query, username, userID)
+		return err
+	})
+}
+
+// ScheduleDeletion marks the account for deletion at the given time and signs
+// it out everywhere, including its personal access tokens.
+func (s *UserStore) ScheduleDeletion(ctx context.Context, userID int64, at time.Time) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE users SET deletion_scheduled_at = $1 WHERE id = $2`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		if _, err := tx.ExecContext(qctx, query, at, userID); err != nil {
+			return err
+		}
+
+		query = `UPDATE personal_access_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+		if _, err := tx.ExecContext(qctx, query, userID); err != nil {
+			return err
+		}
+
+		return s.deleteUserSessions(ctx, tx, userID)
+	})
+}
+
+// CancelDeletion keeps an account that was scheduled for deletion. It reports
+// whether there was anything to cancel.
+func (s *UserStore) CancelDeletion(ctx context.Context, userID int64) (bool, error) {
+	query := `UPDATE users SET deletion_scheduled_at = NULL WHERE id = $1 AND deletion_scheduled_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteScheduled removes the accounts whose grace period ended before now.
+// Everything they own goes with them via the foreign keys.
+func (s *UserStore) DeleteScheduled(ctx context.Context, now time.Time) (int64, error) {
+	query := `DELETE FROM users WHERE deletion_scheduled_at <= $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// UserFilter narrows down the users admins list. Zero fields don't filter.
+type UserFilter struct {
+	// Query matches part of the username, email or display name.
+	Query     string
+	Activated *bool
+	Suspended *bool
+	Role      string
+	// CreatedAfter and CreatedBefore bound when the account was created,
+	// inclusive and exclusive.
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// List returns the users matching the filter, newest first.
+func (s *UserStore) List(ctx context.Context, filter UserFilter, limit, offset int) ([]User, error) {
+	query := `
+		SELECT id, username, email, display_name, bio, location, website,
+		       avatar_url, version, created_at, updated_at, activated, totp_enabled,
+		       deletion_scheduled_at, role, open_dms, suspended_at
+		FROM users
+		WHERE ($1 = '' OR username ILIKE $1 OR email ILIKE $1 OR display_name ILIKE $1)
+		  AND ($2::boolean IS NULL OR activated = $2)
+		  AND ($3::boolean IS NULL OR (suspended_at IS NOT NULL) = $3)
+		  AND ($4 = '' OR role = $4)
+		  AND ($5::timestamptz IS NULL OR created_at >= $5)
+		  AND ($6::timestamptz IS NULL OR created_at < $6)
+		ORDER BY id DESC
+		LIMIT $7 OFFSET $8
+	`
+
+	pattern := ""
+	if filter.Query != "" {
+		pattern = "%" + likeEscaper.Replace(filter.Query) + "%"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, pattern, filter.Activated, filter.Suspended, filter.Role,
+		filter.CreatedAfter, filter.CreatedBefore, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Email,
+			&user.DisplayName,
+			&user.Bio,
+			&user.Location,
+			&user.Website,
+			&user.AvatarURL,
+			&user.Version,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.IsActive,
+			&user.TwoFactorEnabled,
+			&user.DeletionScheduledAt,
+			&user.Role,
+			&user.OpenDMs,
+			&user.SuspendedAt,
+		); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// UserCounts sums up what an account did and how others relate to it.
+type UserCounts struct {
+	Posts           int `json:"posts"`
+	Comments        int `json:"comments"`
+	Followers       int `json:"followers"`
+	Following       int `json:"following"`
+	ReportsFiled    int `json:"reports_filed"`
+	ReportsReceived int `json:"reports_received"`
+	Sessions        int `json:"sessions"`
+	AccessTokens    int `json:"access_tokens"`
+}
+
+func (s *UserStore) GetCounts(ctx context.Context, userID int64) (*UserCounts, error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM posts WHERE user_id = $1),
+			(SELECT COUNT(*) FROM comments WHERE user_id = $1),
+			(SELECT COUNT(*) FROM followers WHERE user_id = $1),
+			(SELECT COUNT(*) FROM followers WHERE follower_id = $1),
+			(SELECT COUNT(*) FROM reports WHERE reporter_id = $1),
+			(SELECT COUNT(*) FROM reports WHERE target_user_id = $1 AND reporter_id IS NOT NULL),
+			(SELECT COUNT(*) FROM sessions WHERE user_id = $1 AND expiry > NOW()),
+			(SELECT COUNT(*) FROM personal_access_tokens
+			 WHERE user_id = $1 AND revoked_at IS NULL AND (expiry IS NULL OR expiry > NOW()))
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	var c UserCounts
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&c.Posts,
+		&c.Comments,
+		&c.Followers,
+		&c.Following,
+		&c.ReportsFiled,
+		&c.ReportsReceived,
+		&c.Sessions,
+		&c.AccessTokens,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Suspend blocks logging into the account and signs it out everywhere. It
+// reports whether the account wasn't suspended already.
+func (s *UserStore) Suspend(ctx context.Context, userID int64) (bool, error) {
+	var suspended bool
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE users SET suspended_at = NOW() WHERE id = $1 AND suspended_at IS NULL`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		res, err := tx.ExecContext(qctx, query, userID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil || n == 0 {
+			return err
+		}
+		suspended = true
+
+		return s.deleteUserSessions(ctx, tx, userID)
+	})
+	return suspended, err
+}
+
+// Unsuspend lets the account be used again. It reports whether the account
+// was suspended.
+func (s *UserStore) Unsuspend(ctx context.Context, userID int64) (bool, error) {
+	query := `UPDATE users SET suspended_at = NULL WHERE id = $1 AND suspended_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ForceActivate activates the account without its invitation, which is
+// thrown away. It reports whether the account wasn't active already.
+func (s *UserStore) ForceActivate(ctx context.Context, userID int64) (bool, error) {
+	var activated bool
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE users SET activated = TRUE WHERE id = $1 AND NOT activated`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		res, err := tx.ExecContext(qctx, query, userID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil || n == 0 {
+			return err
+		}
+		activated = true
+
+		return s.deleteUserInvitations(ctx, tx, userID)
+	})
+	return activated, err
+}
+
+// SignOutEverywhere ends every session of the user. Personal access tokens
+// are left alone, they are revoked by the user. It returns how many
+// sessions were ended.
+func (s *UserStore) SignOutEverywhere(ctx context.Context, userID int64) (int64, error) {
+	var n int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `DELETE FROM sessions WHERE user_id = $1`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		res, err := tx.ExecContext(qctx, query, userID)
+		if err != nil {
+			return err
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return err
+		}
+		return realtime.PublishDisconnect(ctx, tx, userID)
+	})
+	return n, err
+}
+
+func (s *UserStore) SetRole(ctx context.Context, userID int64, role string) error {
+	query := `UPDATE users SET role = $1 WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, role, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
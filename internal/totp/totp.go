@@ -0,0 +1,149 @@
+// Package totp implements time-based one-time passwords as described in
+// RFC 6238, using HMAC-SHA1 as deployed by common authenticator apps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var ErrInvalidSecret = errors.New("totp: invalid secret")
+
+type Config struct {
+	Issuer string
+	// Period is the time step, 30 seconds unless set.
+	Period time.Duration
+	// Digits is the code length, 6 unless set.
+	Digits int
+	// Skew is how many steps before and after the current one are accepted
+	// to allow for clock drift between the server and the device.
+	Skew int
+	// Now is the clock used for validation. It defaults to time.Now and is
+	// meant to be replaced in tests.
+	Now func() time.Time
+}
+
+// GenerateSecret returns a random 160-bit secret encoded in base32.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+func (c Config) period() time.Duration {
+	if c.Period <= 0 {
+		return 30 * time.Second
+	}
+	return c.Period
+}
+
+func (c Config) digits() int {
+	if c.Digits <= 0 {
+		return 6
+	}
+	return c.Digits
+}
+
+func (c Config) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+// Step returns the time step counter for t.
+func (c Config) Step(t time.Time) int64 {
+	return t.Unix() / int64(c.period()/time.Second)
+}
+
+// Code returns the code for the given time step.
+func (c Config) Code(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil || len(key) == 0 {
+		return "", ErrInvalidSecret
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < c.digits(); i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", c.digits(), bin%mod), nil
+}
+
+// Validate checks code against the current step and the steps within the
+// skew window. It returns the matching step so callers can reject reuse of
+// the same code.
+func (c Config) Validate(secret, code string) (int64, bool) {
+	return c.ValidateAfter(secret, code, math.MinInt64)
+}
+
+// ValidateAfter is Validate for a code that was last used at lastStep: only
+// later steps are accepted, so neither that code nor an earlier one can be
+// replayed.
+func (c Config) ValidateAfter(secret, code string, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != c.digits() {
+		return 0, false
+	}
+
+	current := c.Step(c.now())
+	for i := -c.Skew; i <= c.Skew; i++ {
+		step := current + int64(i)
+		if step <= lastStep {
+			continue
+		}
+		expected, err := c.Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the otpauth:// URI understood by authenticator apps.
+func (c Config) URI(account, secret string) string {
+	label := url.PathEscape(account)
+	if c.Issuer != "" {
+		label = url.PathEscape(c.Issuer) + ":" + label
+	}
+
+	v := url.Values{}
+	v.Set("secret", secret)
+	if c.Issuer != "" {
+		v.Set("issuer", c.Issuer)
+	}
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(c.digits()))
+	v.Set("period", fmt.Sprint(int(c.period()/time.Second)))
+
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
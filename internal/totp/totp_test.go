@@ -0,0 +1,238 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func clock(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	// RFC 6238 appendix B, SHA1
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	c := Config{Digits: 8}
+	for _, tt := range tests {
+		code, err := c.Code(rfcSecret, c.Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("%d: %v", tt.unix, err)
+		}
+		if code != tt.code {
+			t.Errorf("%d: got %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestCodeRFC4226(t *testing.T) {
+	// RFC 4226 appendix D, the six digit codes of counters 0 to 9
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	var c Config
+	for step, code := range want {
+		got, err := c.Code(rfcSecret, int64(step))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != code {
+			t.Errorf("step %d: got %s, want %s", step, got, code)
+		}
+	}
+}
+
+func TestCodeSecretFormat(t *testing.T) {
+	var c Config
+
+	want, _ := c.Code(rfcSecret, 1)
+	got, err := c.Code(" "+strings.ToLower(rfcSecret)+"\n", 1)
+	if err != nil || got != want {
+		t.Errorf("got %s %v, want %s", got, err, want)
+	}
+
+	for _, secret := range []string{"", "not base32!", "1"} {
+		if _, err := c.Code(secret, 1); err != ErrInvalidSecret {
+			t.Errorf("%q: got %v, want ErrInvalidSecret", secret, err)
+		}
+	}
+}
+
+func TestValidateSkewWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	c := Config{Skew: 1, Now: clock(now)}
+	current := c.Step(now)
+
+	tests := []struct {
+		offset int64
+		valid  bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+	for _, tt := range tests {
+		code, err := c.Code(rfcSecret, current+tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		step, ok := c.Validate(rfcSecret, code)
+		if ok != tt.valid {
+			t.Errorf("step %+d: got valid %v, want %v", tt.offset, ok, tt.valid)
+		}
+		if ok && step != current+tt.offset {
+			t.Errorf("step %+d: got step %d, want %d", tt.offset, step, current+tt.offset)
+		}
+	}
+}
+
+func TestValidateWithoutSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	c := Config{Now: clock(now)}
+
+	previous, _ := c.Code(rfcSecret, c.Step(now)-1)
+	if _, ok := c.Validate(rfcSecret, previous); ok {
+		t.Error("accepted the previous step without skew")
+	}
+
+	current, _ := c.Code(rfcSecret, c.Step(now))
+	if _, ok := c.Validate(rfcSecret, current); !ok {
+		t.Error("rejected the current step")
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	c := Config{Skew: 1, Now: clock(now)}
+	code, _ := c.Code(rfcSecret, c.Step(now))
+
+	if _, ok := c.Validate(rfcSecret, " "+code+" "); !ok {
+		t.Error("rejected a code with surrounding space")
+	}
+
+	for _, bad := range []string{"", code[:5], code + "0", "abcdef"} {
+		if _, ok := c.Validate(rfcSecret, bad); ok {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+	if _, ok := c.Validate("not base32!", code); ok {
+		t.Error("accepted a code for an invalid secret")
+	}
+}
+
+func TestValidateAfterRejectsReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	c := Config{Skew: 1, Now: clock(now)}
+	current := c.Step(now)
+
+	code, _ := c.Code(rfcSecret, current)
+	step, ok := c.ValidateAfter(rfcSecret, code, 0)
+	if !ok || step != current {
+		t.Fatalf("first use: got %d %v, want %d true", step, ok, current)
+	}
+
+	// The same code again, still within its window
+	c.Now = clock(now.Add(30 * time.Second))
+	if _, ok := c.ValidateAfter(rfcSecret, code, step); ok {
+		t.Error("accepted a replayed code")
+	}
+
+	// An older code that is still within the window
+	older, _ := c.Code(rfcSecret, current-1)
+	c.Now = clock(now)
+	if _, ok := c.ValidateAfter(rfcSecret, older, step); ok {
+		t.Error("accepted a code older than the last one used")
+	}
+
+	// The next one is fine
+	next, _ := c.Code(rfcSecret, current+1)
+	if got, ok := c.ValidateAfter(rfcSecret, next, step); !ok || got != current+1 {
+		t.Errorf("next code: got %d %v, want %d true", got, ok, current+1)
+	}
+}
+
+func TestStepPeriod(t *testing.T) {
+	tests := []struct {
+		period time.Duration
+		unix   int64
+		step   int64
+	}{
+		{0, 59, 1},
+		{0, 60, 2},
+		{60 * time.Second, 59, 0},
+		{60 * time.Second, 60, 1},
+	}
+	for _, tt := range tests {
+		c := Config{Period: tt.period}
+		if got := c.Step(time.Unix(tt.unix, 0)); got != tt.step {
+			t.Errorf("period %s at %d: got step %d, want %d", tt.period, tt.unix, got, tt.step)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if a == b {
+		t.Error("two secrets are the same")
+	}
+
+	key, err := b32.DecodeString(a)
+	if err != nil || len(key) != 20 {
+		t.Errorf("got a %d byte key, %v, want 20 bytes", len(key), err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	c := Config{Issuer: "Social App"}
+	uri := c.URI("ada@example.com", rfcSecret)
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" {
+		t.Errorf("got %s://%s, want otpauth://totp", u.Scheme, u.Host)
+	}
+	if u.Path != "/Social App:ada@example.com" {
+		t.Errorf("got label %q", u.Path)
+	}
+
+	q := u.Query()
+	want := map[string]string{
+		"secret":    rfcSecret,
+		"issuer":    "Social App",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("%s: got %q, want %q", k, q.Get(k), v)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package unfurl
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// metadata maps OpenGraph properties, meta names and "title" to the first
+// value found for them.
+type metadata map[string]string
+
+func (m metadata) first(keys ...string) string {
+	for _, key := range keys {
+		if v := m[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseMetadata reads the head of an HTML document. It stops at the body,
+// where no metadata is expected.
+func parseMetadata(r io.Reader) metadata {
+	meta := metadata{}
+	z := html.NewTokenizer(r)
+
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return meta
+
+		case html.TextToken:
+			if inTitle && meta["title"] == "" {
+				meta["title"] = string(z.Text())
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "body":
+				return meta
+			case "title":
+				inTitle = true
+			case "meta":
+				var key, content string
+				for _, attr := range tok.Attr {
+					switch attr.Key {
+					case "property", "name":
+						key = strings.ToLower(strings.TrimSpace(attr.Val))
+					case "content":
+						content = attr.Val
+					}
+				}
+				if key != "" && key != "title" && meta[key] == "" {
+					meta[key] = content
+				}
+			}
+
+		case html.EndTagToken:
+			switch z.Token().Data {
+			case "title":
+				inTitle = false
+			case "head":
+				return meta
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Package unfurl fetches web pages linked from posts and reads the metadata
+// used for preview cards: OpenGraph, Twitter cards and plain HTML as a
+// fallback.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrBlockedAddress = errors.New("unfurl: address is not publicly routable")
+	ErrNotHTML        = errors.New("unfurl: not an HTML page")
+	ErrNoMetadata     = errors.New("unfurl: page has no preview metadata")
+)
+
+const (
+	maxRedirects      = 3
+	maxTitleLength    = 300
+	maxDescLength     = 1000
+	maxImageURLLength = 2048
+)
+
+type Config struct {
+	// Timeout bounds a whole fetch, redirects and body included.
+	Timeout time.Duration
+	// MaxBodySize is how much of a page is read. Metadata is in the head,
+	// so the rest is not needed.
+	MaxBodySize int64
+	// AllowPrivate lets requests reach loopback and private addresses. It is
+	// meant for tests against local servers and must stay off in production.
+	AllowPrivate bool
+	UserAgent    string
+}
+
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+type Unfurler struct {
+	cfg    Config
+	client *http.Client
+}
+
+func New(cfg Config) *Unfurler {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.MaxBodySize == 0 {
+		cfg.MaxBodySize = 1 << 20
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = "SocialBot/1.0 (link previews)"
+	}
+
+	// The address is checked when connecting rather than when resolving, so
+	// DNS answers that change between the two can't sneak past it
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || (!cfg.AllowPrivate && !isPublic(ip)) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		// never go through a proxy from the environment, it would do the
+		// connecting for us
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.Timeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	client := &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// via holds the original request too
+			if len(via) > maxRedirects {
+				return errors.New("unfurl: too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("unfurl: redirect to %s URL", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+
+	return &Unfurler{cfg: cfg, client: client}
+}
+
+// Fetch downloads the page at rawURL and reads its preview metadata.
+func (u *Unfurler) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", u.cfg.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unfurl: %s returned %s", rawURL, resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	meta := parseMetadata(io.LimitReader(resp.Body, u.cfg.MaxBodySize))
+
+	// relative image URLs are relative to where we ended up
+	p := &Preview{
+		URL:         rawURL,
+		Title:       clean(meta.first("og:title", "twitter:title", "title"), maxTitleLength),
+		Description: clean(meta.first("og:description", "twitter:description", "description"), maxDescLength),
+		SiteName:    clean(meta.first("og:site_name"), maxTitleLength),
+		ImageURL:    imageURL(resp.Request.URL, meta.first("og:image", "og:image:url", "twitter:image", "twitter:image:src")),
+	}
+	if p.Title == "" && p.Description == "" {
+		return nil, ErrNoMetadata
+	}
+	return p, nil
+}
+
+// IsPublic reports whether ip is a globally routable unicast address, so not
+// loopback, private, link-local, shared, reserved or documentation space.
+func IsPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, block := range reserved {
+		if block.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPublic is what connections are checked with, replaced in tests that need
+// a local server to pass for a public one.
+var isPublic = IsPublic
+
+var reserved = func() []*net.IPNet {
+	cidrs := []string{
+		"0.0.0.0/8",       // this network
+		"100.64.0.0/10",   // carrier-grade NAT
+		"192.0.0.0/24",    // IETF protocol assignments
+		"192.0.2.0/24",    // documentation
+		"198.18.0.0/15",   // benchmarking
+		"198.51.100.0/24", // documentation
+		"203.0.113.0/24",  // documentation
+		"240.0.0.0/4",     // reserved, and broadcast
+		"64:ff9b::/96",    // NAT64, embeds IPv4 addresses
+		"64:ff9b:1::/48",  // local NAT64
+		"2001:db8::/32",   // documentation
+		"2002::/16",       // 6to4, embeds IPv4 addresses
+		"fec0::/10",       // deprecated site-local
+		"::ffff:0:0:0/96", // IPv4-translated
+		"100::/64",        // discard
+		"2001::/23",       // IETF protocol assignments
+	}
+	blocks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, blocks[i], _ = net.ParseCIDR(cidr)
+	}
+	return blocks
+}()
+
+func imageURL(base *url.URL, raw string) string {
+	if raw == "" {
+		return ""
+	}
+	ref, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return ""
+	}
+	abs := base.ResolveReference(ref)
+	if (abs.Scheme != "http" && abs.Scheme != "https") || len(abs.String()) > maxImageURLLength {
+		return ""
+	}
+	return abs.String()
+}
+
+// clean collapses whitespace and cuts s to at most n characters.
+func clean(s string, n int) string {
+	s = strings.Join(strings.Fields(strings.ToValidUTF8(s, "")), " ")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
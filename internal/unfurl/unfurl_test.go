@@ -0,0 +1,369 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveHTML starts a local server answering every request with page.
+func serveHTML(t *testing.T, page string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// listenOn starts a server on a specific loopback address.
+func listenOn(t *testing.T, addr string, h http.Handler) *httptest.Server {
+	t.Helper()
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("can't listen on %s: %v", addr, err)
+	}
+	srv := httptest.NewUnstartedServer(h)
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// treatAsPublic makes the addresses for which public returns true pass the
+// address check for the rest of the test.
+func treatAsPublic(t *testing.T, public func(net.IP) bool) {
+	t.Helper()
+
+	orig := isPublic
+	isPublic = public
+	t.Cleanup(func() { isPublic = orig })
+}
+
+func fetch(t *testing.T, cfg Config, url string) (*Preview, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return New(cfg).Fetch(ctx, url)
+}
+
+func TestFetchBlocksPrivateAddresses(t *testing.T) {
+	srv := serveHTML(t, `<title>secret</title>`)
+
+	_, err := fetch(t, Config{}, srv.URL)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("got %v, want ErrBlockedAddress", err)
+	}
+
+	p, err := fetch(t, Config{AllowPrivate: true}, srv.URL)
+	if err != nil || p.Title != "secret" {
+		t.Errorf("with AllowPrivate: got %+v %v", p, err)
+	}
+}
+
+func TestFetchBlocksRedirectsToPrivateAddresses(t *testing.T) {
+	internal := listenOn(t, "127.0.0.2:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<title>internal</title>`)
+	}))
+	public := listenOn(t, "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL+"/admin", http.StatusFound)
+	}))
+
+	// Only the first server passes for public
+	treatAsPublic(t, func(ip net.IP) bool { return ip.Equal(net.IPv4(127, 0, 0, 1)) })
+
+	_, err := fetch(t, Config{}, public.URL)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("got %v, want ErrBlockedAddress", err)
+	}
+}
+
+func TestFetchBlocksHostnamesResolvingToPrivateAddresses(t *testing.T) {
+	srv := serveHTML(t, `<title>local</title>`)
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	if _, err := fetch(t, Config{}, url); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("got %v, want ErrBlockedAddress", err)
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		if _, err := fmt.Sscanf(r.URL.Path, "/hop/%d", &n); err == nil && n > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<head><title>arrived</title><meta property="og:image" content="img/a.png"></head>`)
+	}))
+	defer srv.Close()
+
+	cfg := Config{AllowPrivate: true}
+
+	p, err := fetch(t, cfg, srv.URL+fmt.Sprintf("/hop/%d", maxRedirects))
+	if err != nil {
+		t.Fatalf("%d redirects: %v", maxRedirects, err)
+	}
+	if p.Title != "arrived" {
+		t.Errorf("got title %q", p.Title)
+	}
+	// Relative to where the redirects ended up
+	if want := srv.URL + "/hop/img/a.png"; p.ImageURL != want {
+		t.Errorf("got image %q, want %q", p.ImageURL, want)
+	}
+	if p.URL != srv.URL+fmt.Sprintf("/hop/%d", maxRedirects) {
+		t.Errorf("got URL %q, want the one asked for", p.URL)
+	}
+
+	_, err = fetch(t, cfg, srv.URL+fmt.Sprintf("/hop/%d", maxRedirects+1))
+	if err == nil || !strings.Contains(err.Error(), "too many redirects") {
+		t.Errorf("%d redirects: got %v, want too many redirects", maxRedirects+1, err)
+	}
+}
+
+func TestFetchRejectsRedirectsToOtherSchemes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	if _, err := fetch(t, Config{AllowPrivate: true}, srv.URL); err == nil {
+		t.Error("followed a redirect to a file URL")
+	}
+}
+
+func TestFetchContentTypes(t *testing.T) {
+	tests := []struct {
+		contentType string
+		err         error
+	}{
+		{"text/html", nil},
+		{"text/html; charset=ISO-8859-1", nil},
+		{"application/xhtml+xml", nil},
+		{"TEXT/HTML", nil},
+		{"application/json", ErrNotHTML},
+		{"image/png", ErrNotHTML},
+		{"text/plain", ErrNotHTML},
+		{"", ErrNotHTML},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Set even when empty, so it isn't sniffed
+				w.Header()["Content-Type"] = []string{tt.contentType}
+				fmt.Fprint(w, `<title>page</title>`)
+			}))
+			defer srv.Close()
+
+			_, err := fetch(t, Config{AllowPrivate: true}, srv.URL)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestFetchStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<title>not found</title>`)
+	}))
+	defer srv.Close()
+
+	if _, err := fetch(t, Config{AllowPrivate: true}, srv.URL); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got %v, want a 404 error", err)
+	}
+}
+
+func TestFetchBodySizeLimit(t *testing.T) {
+	padding := `<meta name="x" content="` + strings.Repeat("a", 4096) + `">`
+	srv := serveHTML(t, `<head>`+padding+`<title>late</title></head>`)
+
+	_, err := fetch(t, Config{AllowPrivate: true, MaxBodySize: 1024}, srv.URL)
+	if !errors.Is(err, ErrNoMetadata) {
+		t.Errorf("got %v, want ErrNoMetadata past the size limit", err)
+	}
+
+	p, err := fetch(t, Config{AllowPrivate: true, MaxBodySize: 8192}, srv.URL)
+	if err != nil || p.Title != "late" {
+		t.Errorf("within the limit: got %+v %v", p, err)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	if _, err := fetch(t, Config{AllowPrivate: true, Timeout: 100 * time.Millisecond}, srv.URL); err == nil {
+		t.Fatal("a hanging server didn't time out")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("took %s to time out", d)
+	}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want Preview
+		err  error
+	}{
+		{
+			name: "opengraph",
+			page: `<html><head>
+				<title>Plain title</title>
+				<meta name="description" content="Plain description">
+				<meta property="og:title" content="OG title">
+				<meta property="og:description" content="OG description">
+				<meta property="og:site_name" content="Example">
+				<meta property="og:image" content="https://cdn.example.com/a.png">
+			</head><body></body></html>`,
+			want: Preview{
+				Title:       "OG title",
+				Description: "OG description",
+				SiteName:    "Example",
+				ImageURL:    "https://cdn.example.com/a.png",
+			},
+		},
+		{
+			name: "twitter card",
+			page: `<head>
+				<meta name="twitter:title" content="Card title">
+				<meta name="twitter:description" content="Card description">
+				<meta name="twitter:image" content="/card.png">
+				<title>Plain title</title>
+			</head>`,
+			want: Preview{Title: "Card title", Description: "Card description", ImageURL: "/card.png"},
+		},
+		{
+			name: "title fallback",
+			page: `<html><head><title>  Just a
+				title </title><meta name="description" content="About it"></head></html>`,
+			want: Preview{Title: "Just a title", Description: "About it"},
+		},
+		{
+			name: "first value wins",
+			page: `<head><meta property="og:title" content="First"><meta property="og:title" content="Second"></head>`,
+			want: Preview{Title: "First"},
+		},
+		{
+			name: "case of property names",
+			page: `<head><meta property=" OG:Title " content="Shouting"></head>`,
+			want: Preview{Title: "Shouting"},
+		},
+		{
+			name: "a meta named title doesn't stand in for the title",
+			page: `<head><meta name="title" content="Meta"><title>Element</title></head>`,
+			want: Preview{Title: "Element"},
+		},
+		{
+			name: "body is not read",
+			page: `<head></head><body><title>In body</title><meta property="og:title" content="In body"></body>`,
+			err:  ErrNoMetadata,
+		},
+		{
+			name: "nothing",
+			page: `<html><head><meta charset="utf-8"></head></html>`,
+			err:  ErrNoMetadata,
+		},
+		{
+			name: "unsafe image",
+			page: `<head><title>t</title><meta property="og:image" content="javascript:alert(1)"></head>`,
+			want: Preview{Title: "t"},
+		},
+		{
+			name: "entities",
+			page: `<head><title>Tom &amp; Jerry</title><meta name="description" content="&lt;b&gt;"></head>`,
+			want: Preview{Title: "Tom & Jerry", Description: "<b>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := serveHTML(t, tt.page)
+
+			p, err := fetch(t, Config{AllowPrivate: true}, srv.URL)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			want := tt.want
+			want.URL = srv.URL
+			if strings.HasPrefix(want.ImageURL, "/") {
+				want.ImageURL = srv.URL + want.ImageURL
+			}
+			if *p != want {
+				t.Errorf("got %+v\nwant %+v", *p, want)
+			}
+		})
+	}
+}
+
+func TestClean(t *testing.T) {
+	if got := clean(" a \n\t b ", 10); got != "a b" {
+		t.Errorf("got %q", got)
+	}
+	if got := clean("héllo wörld", 6); got != "héllo…" {
+		t.Errorf("got %q, want a cut at 6 runes", got)
+	}
+	if got := clean("ok\xff", 10); got != "ok" {
+		t.Errorf("got %q, want invalid UTF-8 dropped", got)
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"192.0.2.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::7f00:1", false},
+		{"2002:7f00:1::", false},
+	}
+	for _, tt := range tests {
+		if got := IsPublic(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("IsPublic(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package unfurl
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var urlPattern = regexp.MustCompile(`(?i)https?://[^\s<>"'` + "`" + `]+`)
+
+// ExtractURLs finds up to max distinct http and https URLs in text, normalized,
+// in the order they appear.
+func ExtractURLs(text string, max int) []string {
+	var urls []string
+	seen := map[string]bool{}
+
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		// punctuation after a link belongs to the sentence, as do closing
+		// brackets that were not opened in the link
+		match = strings.TrimRight(match, ".,;:!?*_~")
+		for strings.HasSuffix(match, ")") && strings.Count(match, "(") < strings.Count(match, ")") {
+			match = strings.TrimSuffix(match, ")")
+		}
+
+		normalized, err := Normalize(match)
+		if err != nil || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		urls = append(urls, normalized)
+		if len(urls) == max {
+			break
+		}
+	}
+	return urls
+}
+
+// Normalize returns the form of an http or https URL that previews are keyed
+// by: lowercase scheme and host, no default port, fragment or tracking
+// parameters, and sorted query parameters.
+func Normalize(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("unfurl: only http and https URLs have previews")
+	}
+	if u.Hostname() == "" || u.User != nil {
+		return "", errors.New("unfurl: invalid URL")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	u.Host = host
+	if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	}
+	if port != "" {
+		u.Host += ":" + port
+	}
+
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	query := u.Query()
+	for key := range query {
+		if strings.HasPrefix(key, "utm_") || key == "fbclid" || key == "gclid" {
+			query.Del(key)
+		}
+	}
+	// Encode sorts by key, keeping the order of repeated keys
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
@@ -0,0 +1,370 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455), as much of it as pushing events to browsers needs: the opening
+// handshake, text and binary messages, fragmentation, ping/pong and the
+// closing handshake. Extensions such as compression are not negotiated.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types, the opcodes of RFC 6455.
+const (
+	continuationFrame = 0x0
+	TextMessage       = 0x1
+	BinaryMessage     = 0x2
+	closeFrame        = 0x8
+	pingFrame         = 0x9
+	pongFrame         = 0xa
+)
+
+// Close codes.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+const (
+	acceptGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	maxControlPayload = 125
+	// DefaultReadLimit is the largest message read unless SetReadLimit says
+	// otherwise.
+	DefaultReadLimit = 64 << 10
+)
+
+var (
+	ErrBadHandshake   = errors.New("websocket: not a valid websocket handshake")
+	ErrMessageTooBig  = errors.New("websocket: message too big")
+	ErrProtocol       = errors.New("websocket: protocol error")
+	ErrInvalidPayload = errors.New("websocket: text message is not valid UTF-8")
+	ErrClosed         = errors.New("websocket: connection closed")
+)
+
+// CloseError is returned by ReadMessage once the peer closed the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed by peer with code %d %q", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection. One goroutine may read while others write;
+// writes are serialized.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	readLimit int64
+	onPong    func()
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// Upgrade performs the opening handshake and takes the connection over from
+// the HTTP server. On error nothing has been written yet, so the caller can
+// still respond with an HTTP error.
+//
+// The deadlines the server set for the request are cleared; callers set
+// their own with SetReadDeadline and SetWriteDeadline.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		return nil, fmt.Errorf("%w: unsupported version", ErrBadHandshake)
+	}
+	key := r.Header.Get("Sec-Websocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, fmt.Errorf("%w: invalid key", ErrBadHandshake)
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	res := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(res)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:      conn,
+		br:        rw.Reader,
+		readLimit: DefaultReadLimit,
+	}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerContains reports whether the comma separated header has token.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetReadLimit sets the largest message ReadMessage accepts. Larger ones
+// close the connection.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetPongHandler sets a function called, from ReadMessage, for every pong the
+// peer sends.
+func (c *Conn) SetPongHandler(fn func()) {
+	c.onPong = fn
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and pongs handed to the pong handler while waiting for it. When the peer
+// closes the connection, the close is acknowledged and a *CloseError is
+// returned. Protocol violations close the connection with the matching code.
+func (c *Conn) ReadMessage() (messageType int, data []byte, err error) {
+	messageType = -1
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			c.failRead(err)
+			return -1, nil, err
+		}
+
+		switch opcode {
+		case pingFrame:
+			if err := c.writeFrame(pongFrame, payload); err != nil {
+				return -1, nil, err
+			}
+			continue
+		case pongFrame:
+			if c.onPong != nil {
+				c.onPong()
+			}
+			continue
+		case closeFrame:
+			return -1, nil, c.peerClosed(payload)
+		case TextMessage, BinaryMessage:
+			if messageType != -1 {
+				c.failRead(ErrProtocol)
+				return -1, nil, ErrProtocol
+			}
+			messageType = opcode
+			data = payload
+		case continuationFrame:
+			if messageType == -1 {
+				c.failRead(ErrProtocol)
+				return -1, nil, ErrProtocol
+			}
+			if int64(len(data)+len(payload)) > c.readLimit {
+				c.failRead(ErrMessageTooBig)
+				return -1, nil, ErrMessageTooBig
+			}
+			data = append(data, payload...)
+		default:
+			c.failRead(ErrProtocol)
+			return -1, nil, ErrProtocol
+		}
+
+		// Control frames may come between the fragments of a message
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(data) {
+				c.failRead(ErrInvalidPayload)
+				return -1, nil, ErrInvalidPayload
+			}
+			return messageType, data, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+
+	// No extensions are negotiated, so the reserved bits stay clear, and
+	// clients always mask what they send
+	if header[0]&0x70 != 0 || !masked {
+		return false, 0, nil, ErrProtocol
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	if opcode >= closeFrame && (!fin || length > maxControlPayload) {
+		return false, 0, nil, ErrProtocol
+	}
+	if length < 0 || length > c.readLimit {
+		return false, 0, nil, ErrMessageTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// peerClosed answers a close frame and closes the connection.
+func (c *Conn) peerClosed(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+	}
+
+	var reply []byte
+	if closeErr.Code != CloseNoStatus {
+		reply = payload[:2]
+	}
+	_ = c.writeFrame(closeFrame, reply)
+	c.conn.Close()
+	return closeErr
+}
+
+// failRead closes the connection after a read error, telling the peer why if
+// it broke the protocol.
+func (c *Conn) failRead(err error) {
+	switch {
+	case errors.Is(err, ErrProtocol):
+		_ = c.Close(CloseProtocolError, "")
+	case errors.Is(err, ErrMessageTooBig):
+		_ = c.Close(CloseMessageTooBig, "")
+	case errors.Is(err, ErrInvalidPayload):
+		_ = c.Close(CloseInvalidPayload, "")
+	default:
+		c.conn.Close()
+	}
+}
+
+// WriteMessage sends data as a single text or binary message.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// Ping sends a ping. The peer answers it with a pong, which ReadMessage hands
+// to the pong handler.
+func (c *Conn) Ping() error {
+	return c.writeFrame(pingFrame, nil)
+}
+
+// Close sends a close frame with code and reason and closes the connection,
+// without waiting for the peer to answer.
+func (c *Conn) Close(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+
+	err := c.writeFrame(closeFrame, payload)
+	if closeErr := c.conn.Close(); err == nil && !errors.Is(closeErr, net.ErrClosed) {
+		err = closeErr
+	}
+	return err
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	if opcode == closeFrame {
+		c.closed = true
+	}
+
+	// Server frames are never masked
+	header := make([]byte, 0, 10)
+	header = append(header, 0x80|byte(opcode))
+	switch n := len(payload); {
+	case n <= maxControlPayload:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	bufs := net.Buffers{header, payload}
+	_, err := bufs.WriteTo(c.conn)
+	return err
+}
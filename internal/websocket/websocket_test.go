@@ -0,0 +1,517 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMask = [4]byte{0x37, 0xfa, 0x21, 0x3d}
+
+// clientFrame encodes a frame as a client sends it, masked unless masked is
+// false.
+func clientFrame(fin bool, opcode int, payload []byte, masked bool) []byte {
+	var b []byte
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	b = append(b, first)
+
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		b = append(b, maskBit|byte(n))
+	case n <= 0xffff:
+		b = append(b, maskBit|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, maskBit|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+
+	if !masked {
+		return append(b, payload...)
+	}
+	b = append(b, testMask[:]...)
+	for i, c := range payload {
+		b = append(b, c^testMask[i%4])
+	}
+	return b
+}
+
+type frame struct {
+	fin     bool
+	opcode  int
+	payload []byte
+}
+
+// readServerFrame reads a frame the server sent, checking it isn't masked.
+func readServerFrame(t *testing.T, r io.Reader) frame {
+	t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatalf("reading frame header: %v", err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			t.Fatalf("reading extended length: %v", err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			t.Fatalf("reading extended length: %v", err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("reading payload: %v", err)
+	}
+	return frame{fin: header[0]&0x80 != 0, opcode: int(header[0] & 0x0f), payload: payload}
+}
+
+// newPipe returns a server connection and the client end of it.
+func newPipe(t *testing.T) (*Conn, net.Conn) {
+	t.Helper()
+
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	c := &Conn{conn: server, br: bufio.NewReader(server), readLimit: DefaultReadLimit}
+	return c, client
+}
+
+// send writes the frames from the client without waiting for the server to
+// read them; a pipe blocks writes until they are read.
+func send(client net.Conn, frames ...[]byte) {
+	go func() {
+		_, _ = client.Write(bytes.Join(frames, nil))
+	}()
+}
+
+type readResult struct {
+	messageType int
+	data        []byte
+	err         error
+}
+
+func readMessage(c *Conn) <-chan readResult {
+	ch := make(chan readResult, 1)
+	go func() {
+		typ, data, err := c.ReadMessage()
+		ch <- readResult{typ, data, err}
+	}()
+	return ch
+}
+
+func wait(t *testing.T, ch <-chan readResult) readResult {
+	t.Helper()
+
+	select {
+	case res := <-ch:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadMessage did not return")
+		return readResult{}
+	}
+}
+
+// expectClose reads a close frame from the client end and checks its code.
+func expectClose(t *testing.T, client io.Reader, code int) {
+	t.Helper()
+
+	f := readServerFrame(t, client)
+	if f.opcode != closeFrame {
+		t.Fatalf("got opcode %#x, want close", f.opcode)
+	}
+	if len(f.payload) < 2 {
+		t.Fatalf("close frame has no code")
+	}
+	if got := int(binary.BigEndian.Uint16(f.payload)); got != code {
+		t.Fatalf("got close code %d, want %d", got, code)
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example of RFC 6455, section 1.3
+	if got, want := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("acceptKey = %q, want %q", got, want)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = conn.WriteMessage(TextMessage, []byte("hello"))
+		_ = conn.Close(CloseNormal, "")
+	}))
+	defer srv.Close()
+
+	nc, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	_ = nc.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := "GET / HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := nc.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(nc)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d, want 101", res.StatusCode)
+	}
+	if got := res.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got Sec-WebSocket-Accept %q", got)
+	}
+
+	f := readServerFrame(t, br)
+	if !f.fin || f.opcode != TextMessage || string(f.payload) != "hello" {
+		t.Errorf("got frame %+v, want a final text frame with hello", f)
+	}
+	expectClose(t, br, CloseNormal)
+}
+
+func TestUpgradeRejectsBadHandshakes(t *testing.T) {
+	valid := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		return r
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *http.Request)
+	}{
+		{"post", func(r *http.Request) { r.Method = http.MethodPost }},
+		{"no upgrade", func(r *http.Request) { r.Header.Del("Upgrade") }},
+		{"no connection upgrade", func(r *http.Request) { r.Header.Set("Connection", "keep-alive") }},
+		{"old version", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") }},
+		{"missing key", func(r *http.Request) { r.Header.Del("Sec-WebSocket-Key") }},
+		{"short key", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "c2hvcnQ=") }},
+		{"key not base64", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "not base64!!!!!!!!!!!!!!") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid()
+			tt.modify(r)
+			if _, err := Upgrade(httptest.NewRecorder(), r); !errors.Is(err, ErrBadHandshake) {
+				t.Errorf("got %v, want ErrBadHandshake", err)
+			}
+		})
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	c, client := newPipe(t)
+	send(client, clientFrame(true, TextMessage, []byte("hello"), true))
+
+	res := wait(t, readMessage(c))
+	if res.err != nil || res.messageType != TextMessage || string(res.data) != "hello" {
+		t.Errorf("got %d %q %v, want text hello", res.messageType, res.data, res.err)
+	}
+}
+
+func TestReadMessageExtendedLengths(t *testing.T) {
+	for _, n := range []int{126, 0xffff, 0x10000} {
+		c, client := newPipe(t)
+		c.SetReadLimit(1 << 20)
+
+		payload := bytes.Repeat([]byte{0xab}, n)
+		send(client, clientFrame(true, BinaryMessage, payload, true))
+
+		res := wait(t, readMessage(c))
+		if res.err != nil || res.messageType != BinaryMessage || !bytes.Equal(res.data, payload) {
+			t.Errorf("%d bytes: got %d, %d bytes, %v", n, res.messageType, len(res.data), res.err)
+		}
+	}
+}
+
+func TestReadMessageRejectsUnmaskedFrames(t *testing.T) {
+	c, client := newPipe(t)
+	send(client, clientFrame(true, TextMessage, []byte("hello"), false))
+
+	ch := readMessage(c)
+	expectClose(t, client, CloseProtocolError)
+	if res := wait(t, ch); !errors.Is(res.err, ErrProtocol) {
+		t.Errorf("got %v, want ErrProtocol", res.err)
+	}
+}
+
+func TestReadMessageFragmented(t *testing.T) {
+	c, client := newPipe(t)
+	send(client,
+		clientFrame(false, TextMessage, []byte("Hel"), true),
+		clientFrame(false, continuationFrame, []byte("lo, "), true),
+		// Control frames may come between fragments
+		clientFrame(true, pingFrame, []byte("are you there"), true),
+		clientFrame(true, continuationFrame, []byte("world"), true),
+	)
+
+	ch := readMessage(c)
+
+	f := readServerFrame(t, client)
+	if f.opcode != pongFrame || string(f.payload) != "are you there" {
+		t.Errorf("got frame %+v, want a pong echoing the ping", f)
+	}
+
+	res := wait(t, ch)
+	if res.err != nil || res.messageType != TextMessage || string(res.data) != "Hello, world" {
+		t.Errorf("got %d %q %v, want text Hello, world", res.messageType, res.data, res.err)
+	}
+}
+
+func TestReadMessageCallsPongHandler(t *testing.T) {
+	c, client := newPipe(t)
+
+	pongs := 0
+	c.SetPongHandler(func() { pongs++ })
+	send(client,
+		clientFrame(true, pongFrame, nil, true),
+		clientFrame(false, BinaryMessage, []byte{1}, true),
+		clientFrame(true, pongFrame, nil, true),
+		clientFrame(true, continuationFrame, []byte{2}, true),
+	)
+
+	res := wait(t, readMessage(c))
+	if res.err != nil || !bytes.Equal(res.data, []byte{1, 2}) {
+		t.Fatalf("got %v %v, want [1 2]", res.data, res.err)
+	}
+	if pongs != 2 {
+		t.Errorf("pong handler called %d times, want 2", pongs)
+	}
+}
+
+func TestReadMessageProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{
+			"continuation without a message",
+			[][]byte{clientFrame(true, continuationFrame, []byte("x"), true)},
+		},
+		{
+			"new message inside a fragmented one",
+			[][]byte{
+				clientFrame(false, TextMessage, []byte("a"), true),
+				clientFrame(true, TextMessage, []byte("b"), true),
+			},
+		},
+		{
+			"fragmented control frame",
+			[][]byte{clientFrame(false, pingFrame, []byte("x"), true)},
+		},
+		{
+			"control frame too long",
+			[][]byte{clientFrame(true, pingFrame, bytes.Repeat([]byte("x"), 126), true)},
+		},
+		{
+			"reserved bits",
+			[][]byte{append([]byte{0x80 | 0x40 | TextMessage}, clientFrame(true, TextMessage, []byte("x"), true)[1:]...)},
+		},
+		{
+			"unknown opcode",
+			[][]byte{clientFrame(true, 0x3, []byte("x"), true)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := newPipe(t)
+			send(client, tt.frames...)
+
+			ch := readMessage(c)
+			expectClose(t, client, CloseProtocolError)
+			if res := wait(t, ch); !errors.Is(res.err, ErrProtocol) {
+				t.Errorf("got %v, want ErrProtocol", res.err)
+			}
+		})
+	}
+}
+
+func TestReadMessageTooBig(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{
+			"single frame",
+			[][]byte{clientFrame(true, BinaryMessage, bytes.Repeat([]byte{1}, 17), true)},
+		},
+		{
+			"fragments",
+			[][]byte{
+				clientFrame(false, BinaryMessage, bytes.Repeat([]byte{1}, 10), true),
+				clientFrame(true, continuationFrame, bytes.Repeat([]byte{1}, 10), true),
+			},
+		},
+		{
+			// The length alone gives it away, the payload never has to be read
+			"announced length",
+			[][]byte{{0x80 | BinaryMessage, 0x80 | 127, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := newPipe(t)
+			c.SetReadLimit(16)
+			send(client, tt.frames...)
+
+			ch := readMessage(c)
+			expectClose(t, client, CloseMessageTooBig)
+			if res := wait(t, ch); !errors.Is(res.err, ErrMessageTooBig) {
+				t.Errorf("got %v, want ErrMessageTooBig", res.err)
+			}
+		})
+	}
+}
+
+func TestReadMessageInvalidUTF8(t *testing.T) {
+	c, client := newPipe(t)
+	send(client, clientFrame(true, TextMessage, []byte{0xff, 0xfe}, true))
+
+	ch := readMessage(c)
+	expectClose(t, client, CloseInvalidPayload)
+	if res := wait(t, ch); !errors.Is(res.err, ErrInvalidPayload) {
+		t.Errorf("got %v, want ErrInvalidPayload", res.err)
+	}
+}
+
+func TestPeerClose(t *testing.T) {
+	c, client := newPipe(t)
+
+	payload := binary.BigEndian.AppendUint16(nil, CloseGoingAway)
+	payload = append(payload, "bye"...)
+	send(client, clientFrame(true, closeFrame, payload, true))
+
+	ch := readMessage(c)
+
+	// The close is acknowledged with the same code
+	f := readServerFrame(t, client)
+	if f.opcode != closeFrame || !bytes.Equal(f.payload, payload[:2]) {
+		t.Errorf("got frame %+v, want a close with code %d", f, CloseGoingAway)
+	}
+
+	res := wait(t, ch)
+	var closeErr *CloseError
+	if !errors.As(res.err, &closeErr) {
+		t.Fatalf("got %v, want a CloseError", res.err)
+	}
+	if closeErr.Code != CloseGoingAway || closeErr.Reason != "bye" {
+		t.Errorf("got %+v, want code %d and reason bye", closeErr, CloseGoingAway)
+	}
+
+	if err := c.WriteMessage(TextMessage, []byte("late")); !errors.Is(err, ErrClosed) {
+		t.Errorf("writing after close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestPeerCloseWithoutCode(t *testing.T) {
+	c, client := newPipe(t)
+	send(client, clientFrame(true, closeFrame, nil, true))
+
+	ch := readMessage(c)
+
+	f := readServerFrame(t, client)
+	if f.opcode != closeFrame || len(f.payload) != 0 {
+		t.Errorf("got frame %+v, want an empty close", f)
+	}
+	// A pipe, unlike TCP, blocks on writing the empty payload until it is
+	// read
+	_, _ = client.Read(make([]byte, 1))
+
+	var closeErr *CloseError
+	if res := wait(t, ch); !errors.As(res.err, &closeErr) || closeErr.Code != CloseNoStatus {
+		t.Errorf("got %v, want a CloseError with code %d", res.err, CloseNoStatus)
+	}
+}
+
+func TestClose(t *testing.T) {
+	c, client := newPipe(t)
+
+	reason := strings.Repeat("r", 200)
+	done := make(chan error, 1)
+	go func() { done <- c.Close(ClosePolicyViolation, reason) }()
+
+	f := readServerFrame(t, client)
+	if f.opcode != closeFrame || !f.fin {
+		t.Fatalf("got frame %+v, want a final close", f)
+	}
+	if code := binary.BigEndian.Uint16(f.payload); code != ClosePolicyViolation {
+		t.Errorf("got code %d, want %d", code, ClosePolicyViolation)
+	}
+	// The reason is cut to fit a control frame
+	if len(f.payload) != maxControlPayload {
+		t.Errorf("got a %d byte payload, want %d", len(f.payload), maxControlPayload)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if err := c.Ping(); !errors.Is(err, ErrClosed) {
+		t.Errorf("ping after close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	for _, n := range []int{0, 125, 126, 0xffff, 0x10000} {
+		c, client := newPipe(t)
+
+		payload := bytes.Repeat([]byte("a"), n)
+		go func() { _ = c.WriteMessage(TextMessage, payload) }()
+
+		f := readServerFrame(t, client)
+		if !f.fin || f.opcode != TextMessage || !bytes.Equal(f.payload, payload) {
+			t.Errorf("%d bytes: got fin=%v opcode=%#x and %d bytes", n, f.fin, f.opcode, len(f.payload))
+		}
+	}
+
+	c, _ := newPipe(t)
+	if err := c.WriteMessage(pingFrame, nil); err == nil {
+		t.Error("writing a control frame as a message succeeded")
+	}
+}